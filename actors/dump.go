@@ -4,11 +4,14 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"time"
 
 	"github.com/peterzandbergen/iec62056/model"
 )
 
 // CacheDumper type reads all entries from the Repo and dumps it to the Writer.
+// The entries are written one at a time, a repo that implements model.Walker
+// is dumped in constant memory.
 type CacheDumper struct {
 	Repo   model.MeasurementRepo
 	Writer io.Writer
@@ -16,15 +19,16 @@ type CacheDumper struct {
 
 // Do performst the actor task.
 func (c *CacheDumper) Do() error {
-	// Get all entries from the repo.
-	m, err := c.Repo.GetAll()
+	n := 0
+	err := model.Walk(c.Repo, "", time.Time{}, time.Time{}, func(m *model.Measurement) error {
+		n++
+		_, err := fmt.Fprintf(c.Writer, "%+v\n", *m)
+		return err
+	})
 	if err != nil {
-		log.Printf("error reading the local cache: %s\n", err.Error())
+		log.Printf("error reading the local cache after %d measurements: %s\n", n, err.Error())
 		return err
 	}
-	fmt.Printf("retrieved %d measurements\n", len(m))
-	for _, v := range m {
-		fmt.Fprintf(c.Writer, "%+v", *v)
-	}
+	fmt.Printf("dumped %d measurements\n", n)
 	return nil
 }
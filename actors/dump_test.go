@@ -1,12 +1,46 @@
 package actors
 
 import (
+	"bytes"
+	"errors"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/model"
 )
 
 var _ Actor = &CacheDumper{}
 
+// walkRepo walks the measurements and then fails with err.
+type walkRepo struct {
+	model.MeasurementRepo
+	mm  []*model.Measurement
+	err error
+}
+
+func (r *walkRepo) Walk(meterID string, from, to time.Time, fn model.WalkFunc) error {
+	for _, m := range r.mm {
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return r.err
+}
+
 func TestDump(t *testing.T) {
-	// TODO: Create dumper with mock repo.
-	// Call the actor.
+	repo := &walkRepo{mm: []*model.Measurement{{Identification: "m1"}, {Identification: "m2"}}}
+	buf := &bytes.Buffer{}
+	a := &CacheDumper{Repo: repo, Writer: buf}
+	if err := a.Do(); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if n := strings.Count(buf.String(), "\n"); n != 2 {
+		t.Errorf("expected %v, received %v", 2, n)
+	}
+
+	repo.err = errors.New("corrupt")
+	if err := a.Do(); err != repo.err {
+		t.Errorf("expected %v, received %v", repo.err, err)
+	}
 }
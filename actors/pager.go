@@ -3,6 +3,7 @@ package actors
 import (
 	"errors"
 	"log"
+	"time"
 
 	"github.com/peterzandbergen/iec62056/model"
 )
@@ -31,6 +32,43 @@ func (a *PagerActor) GetAll() ([]*model.Measurement, error) {
 	return a.Repo.GetAll()
 }
 
+// GetRange returns up to limit measurements of the meter in the time range.
+func (a *PagerActor) GetRange(meterID string, from, to time.Time, limit int) ([]*model.Measurement, error) {
+	if limit < 0 {
+		return nil, ErrBadArguments
+	}
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		return nil, ErrBadArguments
+	}
+	return a.Repo.GetRange(meterID, from, to, limit)
+}
+
+// Cursors returns true if the repo supports GetAfter.
+func (a *PagerActor) Cursors() bool {
+	_, ok := a.Repo.(model.CursorPager)
+	return ok
+}
+
+// GetAfter returns the page after the cursor and the cursor of the next page.
+func (a *PagerActor) GetAfter(cursor string, pagesize int) ([]*model.Measurement, string, error) {
+	if pagesize <= 0 {
+		return nil, "", ErrBadArguments
+	}
+	cp, ok := a.Repo.(model.CursorPager)
+	if !ok {
+		return nil, "", ErrNotImplemented
+	}
+	return cp.GetAfter(cursor, pagesize)
+}
+
+// Walk calls fn for the measurements of the meter in the time range, one at a time.
+func (a *PagerActor) Walk(meterID string, from, to time.Time, fn model.WalkFunc) error {
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		return ErrBadArguments
+	}
+	return model.Walk(a.Repo, meterID, from, to, fn)
+}
+
 func (a PagerActor) Get(fl string) (*model.Measurement, error) {
 	var msm *model.Measurement
 	var err error
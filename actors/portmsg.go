@@ -1,6 +1,7 @@
 package actors
 
 import (
+	"errors"
 	"log"
 
 	"github.com/peterzandbergen/iec62056/model"
@@ -18,6 +19,10 @@ type IecMessageHandler struct {
 // Performs a timeout on the Get to prevent blocking.
 func (h *IecMessageHandler) Do() error {
 	m, err := h.MeterRepo.Get(nil)
+	if errors.Is(err, model.ErrUnavailable) {
+		// The repo logs its state changes.
+		return err
+	}
 	if err != nil {
 		// Log error
 		log.Printf("Error getting measuerment from reader, error: %s", err.Error())
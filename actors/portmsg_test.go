@@ -1,55 +1,60 @@
 package actors
 
 import (
+	"errors"
 	"testing"
+	"time"
 
+	"github.com/peterzandbergen/iec62056/adapters/memory"
 	"github.com/peterzandbergen/iec62056/model"
 )
 
-// type MeasurementRepo interface {
-// 	Put(*Measurement) error
-// 	Get(key []byte) (*Measurement, error)
-// 	GetN(n int) ([]*Measurement, error)
-// 	Delete(*Measurement) error
-// }
-
-type noopRepo struct {
-}
-
-func (r noopRepo) Put(*model.Measurement) error {
-	return nil
-}
-
-func (r noopRepo) Get(key []byte) (*model.Measurement, error) {
-	return nil, nil
-}
-
-func (r noopRepo) GetN(n int) ([]*model.Measurement, error) {
-	return nil, nil
+// mockMeterRepo returns m or err from Get, like the meter repo that reads a
+// measurement from the port.
+type mockMeterRepo struct {
+	*memory.Repo
+	m   *model.Measurement
+	err error
 }
 
-func (r noopRepo) Delete(*model.Measurement) error {
-	return nil
+func (r *mockMeterRepo) Get(key []byte) (*model.Measurement, error) {
+	return r.m, r.err
 }
 
-// Mock repos
-type mockMeterRepo struct {
-	noopRepo
+func TestIecMessageHandlerDo(t *testing.T) {
+	m := &model.Measurement{
+		Time:           time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC),
+		ManufacturerID: "ISK",
+		Identification: "meter1",
+		Readings:       []model.DataSet{{Address: "1.8.1", Value: "000051.394", Unit: "kWh"}},
+	}
+	localRepo := memory.New()
+	actor := IecMessageHandler{
+		LocalRepo: localRepo,
+		MeterRepo: &mockMeterRepo{Repo: memory.New(), m: m},
+	}
+	if err := actor.Do(); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	ms, err := localRepo.GetAll()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(ms) != 1 || !ms[0].Time.Equal(m.Time) || ms[0].Identification != m.Identification {
+		t.Errorf("expected %v, received %v", []*model.Measurement{m}, ms)
+	}
 }
 
-type mockLocalRepo struct {
-	noopRepo
+func TestIecMessageHandlerDoUnavailable(t *testing.T) {
+	localRepo := memory.New()
+	actor := IecMessageHandler{
+		LocalRepo: localRepo,
+		MeterRepo: &mockMeterRepo{Repo: memory.New(), err: model.ErrUnavailable},
+	}
+	if err := actor.Do(); !errors.Is(err, model.ErrUnavailable) {
+		t.Errorf("expected %v, received %v", model.ErrUnavailable, err)
+	}
+	if _, err := localRepo.Get([]byte(model.Last)); !errors.Is(err, model.ErrNoElements) {
+		t.Errorf("expected %v, received %v", model.ErrNoElements, err)
+	}
 }
-
-// func TestIecMessageHandlerDo(t *testing.T) {
-// 	meterRepo := &mockMeterRepo{}
-// 	localRepo := &mockLocalRepo{}
-// 	actor := IecMessageHandler{
-// 		LocalRepo: localRepo,
-// 		MeterRepo: meterRepo,
-// 	}
-// 	actor.Do()
-// }
-
-
-func TestDummy(t *testing.T) {}
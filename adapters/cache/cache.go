@@ -8,11 +8,16 @@ import (
 	// Stdlib
 	"encoding/json"
 	"errors"
+	"fmt"
+	"sync"
+	"time"
 
 	// Vendor
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/comparer"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 var _ model.MeasurementRepo = &Cache{}
@@ -28,7 +33,9 @@ var (
 	ErrNotImplemented = errors.New("not implemented")
 	ErrClosed         = errors.New("db closed")
 	ErrBadArguments   = errors.New("bad argument(s)")
-	ErrNoElements     = errors.New("no elements")
+	ErrNoElements     = model.ErrNoElements
+	// ErrCorrupt is returned for a stored measurement that cannot be decoded.
+	ErrCorrupt = errors.New("corrupt measurement")
 )
 
 func marshalMeasurement(m *model.Measurement) ([]byte, error) {
@@ -45,8 +52,21 @@ func unmarshalMeasurement(b []byte) (*model.Measurement, error) {
 	return m, nil
 }
 
-func key(m *model.Measurement) []byte {
-	return []byte(m.Time.String() + "|" + m.ManufacturerID + "|" + m.Identification)
+// decodeMeasurement unmarshals the value, an error is an ErrCorrupt with the key.
+func (c *Cache) decodeMeasurement(k, v []byte) (*model.Measurement, error) {
+	m, err := c.unmarshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("%w: key %x: %s", ErrCorrupt, k, err.Error())
+	}
+	return m, nil
+}
+
+// metaPrefix starts the keys that do not hold a measurement, e.g. the outbox.
+var metaPrefix = []byte{0xff}
+
+// measurements returns an iterator over the measurements only.
+func (c *Cache) measurements() iterator.Iterator {
+	return c.db.NewIterator(&util.Range{Limit: metaPrefix}, nil)
 }
 
 func (c *Cache) Put(m *model.Measurement) error {
@@ -54,11 +74,23 @@ func (c *Cache) Put(m *model.Measurement) error {
 		return ErrClosed
 	}
 	k := key(m)
-	v, err := marshalMeasurement(m)
+	v, err := c.marshal(m)
 	if err != nil {
 		return err
 	}
-	return c.db.Put(k, v, nil)
+	// Store, index and queue for upload at once.
+	c.write.Lock()
+	defer c.write.Unlock()
+	b := new(leveldb.Batch)
+	if err := c.unindexStored(b, k); err != nil {
+		return err
+	}
+	indexSeries(b, k, m)
+	b.Put(k, v)
+	if c.outbox {
+		c.queue(b, k)
+	}
+	return c.db.Write(b, nil)
 }
 
 func (c *Cache) Get(key []byte) (*model.Measurement, error) {
@@ -69,57 +101,24 @@ func (c *Cache) Get(key []byte) (*model.Measurement, error) {
 	var err error
 	switch string(key) {
 	case model.First, model.Last:
-		v, err = c.getFirstLast(string(key))
+		_, v, err = c.firstLast(string(key) == model.Last)
 	default:
 		v, err = c.db.Get(key, nil)
 	}
 	if err != nil {
 		return nil, err
 	}
-	m, err := unmarshalMeasurement(v)
+	m, err := c.unmarshal(v)
 	if err != nil {
 		return nil, err
 	}
 	return m, nil
 }
 
+// GetAll returns all measurements in time order, see Walk to read them
+// one at a time.
 func (c *Cache) GetAll() ([]*model.Measurement, error) {
-	if c.db == nil {
-		return nil, ErrClosed
-	}
-	// Get an iterator.
-	it := c.db.NewIterator(nil, nil)
-	if it.Error() != nil {
-		return nil, it.Error()
-	}
-	defer it.Release()
-	ms := make([]*model.Measurement, 0)
-	for it.Next() {
-		if v, err := unmarshalMeasurement(it.Value()); err != nil {
-		} else {
-			ms = append(ms, v)
-		}
-	}
-	return ms, nil
-}
-
-func (c *Cache) getFirstLast(fl string) ([]byte, error) {
-	it := c.db.NewIterator(nil, nil)
-	if it.Error() != nil {
-		return nil, it.Error()
-	}
-	defer it.Release()
-	var ok bool
-	switch fl {
-	case model.First:
-		ok = it.First()
-	case model.Last:
-		ok = it.Last()
-	}
-	if !ok {
-		return nil, it.Error()
-	}
-	return it.Value(), nil
+	return c.GetRange("", time.Time{}, time.Time{}, 0)
 }
 
 // GetPage returns pagesize items from the given page. Page starts at 0.
@@ -132,7 +131,7 @@ func (c *Cache) GetPage(page, pagesize int) ([]*model.Measurement, error) {
 		return nil, ErrBadArguments
 	}
 	// Get an iterator.
-	it := c.db.NewIterator(nil, nil)
+	it := c.measurements()
 	if it.Error() != nil {
 		return nil, it.Error()
 	}
@@ -148,11 +147,11 @@ func (c *Cache) GetPage(page, pagesize int) ([]*model.Measurement, error) {
 	ms := make([]*model.Measurement, 0)
 	// hasElements := false
 	for i := pagesize; i > 0 && it.Next(); i-- {
-		if v, err := unmarshalMeasurement(it.Value()); err != nil {
-		} else {
-			ms = append(ms, v)
-			// hasElements = true
+		v, err := c.decodeMeasurement(it.Key(), it.Value())
+		if err != nil {
+			return nil, err
 		}
+		ms = append(ms, v)
 	}
 	if len(ms) == 0 {
 		return nil, ErrNoElements
@@ -168,8 +167,17 @@ func (c *Cache) Delete(m *model.Measurement) error {
 		return ErrClosed
 	}
 	k := key(m)
-	err := c.db.Delete(k, nil)
-	return err
+	c.write.Lock()
+	defer c.write.Unlock()
+	b := new(leveldb.Batch)
+	if err := c.unindexStored(b, k); err != nil {
+		return err
+	}
+	b.Delete(k)
+	if c.outbox {
+		b.Delete(outboxKey(k))
+	}
+	return c.db.Write(b, nil)
 }
 
 // Cache type wraps the leveldb. The cache stores the messages until the can be sent to the cloud storage.
@@ -179,16 +187,96 @@ type Cache struct {
 	db       *leveldb.DB
 	comparer comparer.Comparer
 	options  *opt.Options
+	outbox   bool
+	encoding Encoding
+	dict     *dictionary
+	policies []Policy
+	// write serialises the writes that read the stored measurement first,
+	// so the series indexes follow the measurements, and the outbox
+	// versions.
+	write         sync.Mutex
+	outboxVersion uint64
+}
+
+// Options for opening the cache.
+type Options struct {
+	// Outbox queues every stored measurement for upload, see Pending and Ack.
+	// Measurements stored before the outbox was enabled are queued on open.
+	Outbox bool
+	// Encoding of new records, EncodingCompact by default. Records in
+	// other encodings stay readable until Reencode converts them.
+	Encoding Encoding
+	// Policies are the retention policies, see ParsePolicies and Compact.
+	// Without policies nothing is rolled up or deleted.
+	Policies []Policy
+	// NoSeriesBuild skips indexing the measurements stored without series
+	// indexes on open, e.g. when RebuildSeries is called next.
+	NoSeriesBuild bool
+	// KeepOutboxState ignores Outbox and leaves the outbox as the last
+	// run stored it, e.g. for a command that does not store measurements.
+	KeepOutboxState bool
+	// ErrorIfMissing fails the open of a cache that does not exist, instead
+	// of creating an empty one.
+	ErrorIfMissing bool
 }
 
 func Open(filename string) (*Cache, error) {
-	db, err := leveldb.OpenFile(filename, nil)
+	return OpenWithOptions(filename, nil)
+}
+
+// OpenWithOptions opens the cache with the options, nil for the defaults.
+func OpenWithOptions(filename string, o *Options) (*Cache, error) {
+	if o == nil {
+		o = &Options{}
+	}
+	db, err := leveldb.OpenFile(filename, &opt.Options{ErrorIfMissing: o.ErrorIfMissing})
+	if err != nil {
+		return nil, err
+	}
+	if o.Encoding < EncodingCompact || o.Encoding > EncodingJSON {
+		db.Close()
+		return nil, ErrBadEncoding
+	}
+	policies := append([]Policy{}, o.Policies...)
+	if err := checkPolicies(policies); err != nil {
+		db.Close()
+		return nil, err
+	}
+	c := &Cache{
+		db:       db,
+		outbox:   o.Outbox,
+		encoding: o.Encoding,
+		policies: policies,
+	}
+	if c.dict, err = loadDictionary(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := c.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	switch {
+	case o.KeepOutboxState:
+		if c.outbox, err = db.Has(outboxEnabledKey, nil); err == nil && c.outbox {
+			err = c.loadOutboxVersion()
+		}
+	case c.outbox:
+		err = c.enableOutbox()
+	default:
+		err = c.disableOutbox()
+	}
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
-	return &Cache{
-		db: db,
-	}, nil
+	if !o.NoSeriesBuild {
+		if err := c.buildSeries(); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return c, nil
 }
 
 func (c *Cache) Close() error {
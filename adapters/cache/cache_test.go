@@ -1,18 +1,22 @@
 package cache
 
 import (
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/peterzandbergen/iec62056/model"
 )
 
-const testDB = "./.testdb"
+// testDB returns the name of a new database for the test.
+func testDB(t *testing.T) string {
+	return filepath.Join(t.TempDir(), "db")
+}
 
 func TestOpenDB(t *testing.T) {
 	var c *Cache
 
-	c, err := Open(testDB)
+	c, err := Open(testDB(t))
 	if err != nil {
 		t.Fatalf("Error opening database: %s", err.Error())
 	}
@@ -35,7 +39,7 @@ var putM = &model.Measurement{
 func TestPutMeasurement(t *testing.T) {
 	var c *Cache
 
-	c, err := Open(testDB)
+	c, err := Open(testDB(t))
 	if err != nil {
 		t.Fatalf("Error opening database: %s", err.Error())
 	}
@@ -63,7 +67,7 @@ var getM = &model.Measurement{
 func TestGetMeasurement(t *testing.T) {
 	var c *Cache
 
-	c, err := Open(testDB)
+	c, err := Open(testDB(t))
 	if err != nil {
 		t.Fatalf("Error opening database: %s", err.Error())
 	}
@@ -125,7 +129,7 @@ var getMultM = []*model.Measurement{
 func TestGetNMeasurement(t *testing.T) {
 	var c *Cache
 
-	c, err := Open(testDB)
+	c, err := Open(testDB(t))
 	if err != nil {
 		t.Fatalf("Error opening database: %s", err.Error())
 	}
@@ -0,0 +1,24 @@
+package cache
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/peterzandbergen/iec62056/model"
+	"github.com/peterzandbergen/iec62056/model/repotest"
+)
+
+func TestConformance(t *testing.T) {
+	for _, e := range []Encoding{EncodingJSON, EncodingCompact} {
+		t.Run(e.String(), func(t *testing.T) {
+			repotest.Run(t, func(t *testing.T) model.MeasurementRepo {
+				c, err := OpenWithOptions(filepath.Join(t.TempDir(), "db"), &Options{Outbox: true, Encoding: e})
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err.Error())
+				}
+				t.Cleanup(func() { c.Close() })
+				return c
+			})
+		})
+	}
+}
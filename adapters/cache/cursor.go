@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"encoding/base64"
+
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+var _ model.CursorPager = &Cache{}
+
+// encodeCursor returns the opaque cursor of the key.
+func encodeCursor(k []byte) string {
+	return base64.RawURLEncoding.EncodeToString(k)
+}
+
+func decodeCursor(cursor string) ([]byte, error) {
+	k, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, model.ErrBadCursor
+	}
+	if _, _, ok := splitKey(k); !ok {
+		return nil, model.ErrBadCursor
+	}
+	return k, nil
+}
+
+// GetAfter returns up to size measurements after the cursor in key order,
+// see model.CursorPager. The cursor is the last returned key, so a
+// measurement stored or deleted between the calls does not shift the pages.
+func (c *Cache) GetAfter(cursor string, size int) ([]*model.Measurement, string, error) {
+	if c.db == nil {
+		return nil, "", ErrClosed
+	}
+	if size <= 0 {
+		return nil, "", ErrBadArguments
+	}
+	it := c.measurements()
+	defer it.Release()
+	ok := it.First()
+	if len(cursor) > 0 {
+		k, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		// Seek finds the key or the one after it, if it was deleted.
+		if ok = it.Seek(k); ok && string(it.Key()) == string(k) {
+			ok = it.Next()
+		}
+	}
+	ms := make([]*model.Measurement, 0)
+	var last []byte
+	for ; ok && len(ms) < size; ok = it.Next() {
+		if _, _, valid := splitKey(it.Key()); !valid {
+			continue
+		}
+		m, err := c.decodeMeasurement(it.Key(), it.Value())
+		if err != nil {
+			return nil, "", err
+		}
+		ms = append(ms, m)
+		last = append(last[:0], it.Key()...)
+	}
+	if err := it.Error(); err != nil {
+		return nil, "", err
+	}
+	// Look ahead for a next page, ok is the position after the last one.
+	for ; ok; ok = it.Next() {
+		if _, _, valid := splitKey(it.Key()); valid {
+			return ms, encodeCursor(last), nil
+		}
+	}
+	return ms, "", it.Error()
+}
@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+func TestGetAfter(t *testing.T) {
+	c, err := Open(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatalf("Error opening database: %s", err.Error())
+	}
+	defer c.Close()
+	t0 := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	var mm []*model.Measurement
+	for i := 0; i < 10; i++ {
+		m := &model.Measurement{Time: t0.Add(time.Duration(i) * time.Minute), ManufacturerID: "ISK", Identification: "m1"}
+		mm = append(mm, m)
+		c.Put(m)
+	}
+
+	// Pages of 4, 4 and 2.
+	var all []*model.Measurement
+	cursor := ""
+	pages := 0
+	for {
+		page, next, err := c.GetAfter(cursor, 4)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		pages++
+		all = append(all, page...)
+		if len(next) == 0 {
+			break
+		}
+		cursor = next
+		if pages == 1 {
+			// Deleting the last returned measurement does not shift the pages.
+			c.Delete(mm[3])
+		}
+	}
+	if pages != 3 {
+		t.Errorf("expected %v, received %v", 3, pages)
+	}
+	if len(all) != 10 {
+		t.Errorf("expected %v, received %v", 10, len(all))
+	}
+	for i := range all {
+		if !all[i].Time.Equal(mm[i].Time) {
+			t.Errorf("expected %v, received %v", mm[i].Time, all[i].Time)
+		}
+	}
+
+	// A full last page has no next cursor.
+	if _, next, err := c.GetAfter("", 9); err != nil || len(next) != 0 {
+		t.Errorf("expected no cursor, received %v %v", next, err)
+	}
+	if _, _, err := c.GetAfter("not a cursor", 4); err != model.ErrBadCursor {
+		t.Errorf("expected %v, received %v", model.ErrBadCursor, err)
+	}
+}
@@ -0,0 +1,396 @@
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/model"
+
+	"github.com/golang/snappy"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Encoding of the stored measurements. Records are read in every encoding,
+// the encoding of the cache is used for new records and by Reencode.
+type Encoding int
+
+const (
+	// EncodingCompact stores the addresses and units as numbers from a
+	// dictionary and decimal values as scaled integers.
+	EncodingCompact Encoding = iota
+	// EncodingSnappy is the compact encoding compressed with snappy.
+	EncodingSnappy
+	// EncodingJSON stores the measurements as JSON, as in the first versions.
+	EncodingJSON
+)
+
+var encodingNames = []string{"compact", "snappy", "json"}
+
+func (e Encoding) String() string {
+	if e < 0 || int(e) >= len(encodingNames) {
+		return "unknown"
+	}
+	return encodingNames[e]
+}
+
+// ParseEncoding returns the encoding with the name, compact, snappy or json.
+func ParseEncoding(name string) (Encoding, error) {
+	for i, n := range encodingNames {
+		if n == name {
+			return Encoding(i), nil
+		}
+	}
+	return 0, ErrBadEncoding
+}
+
+// The first byte of a record tells the encoding, a JSON record starts with '{'.
+const (
+	recordCompact = 0x01
+	recordSnappy  = 0x02
+)
+
+// Kinds of values in the compact encoding.
+const (
+	valueString = 0
+	valueScaled = 1
+)
+
+// ErrBadEncoding is returned for an unknown encoding or record format.
+var ErrBadEncoding = errors.New("bad encoding")
+
+// dictPrefix starts the keys of the dictionary, followed by the big endian
+// ID, the value is the string.
+var dictPrefix = []byte("\xffdict/")
+
+// dictionary maps the addresses and units to numbers.
+type dictionary struct {
+	lock    sync.RWMutex
+	ids     map[string]uint32
+	strings []string
+}
+
+// loadDictionary reads the dictionary of the database.
+func loadDictionary(db *leveldb.DB) (*dictionary, error) {
+	d := &dictionary{ids: map[string]uint32{}}
+	it := db.NewIterator(util.BytesPrefix(dictPrefix), nil)
+	defer it.Release()
+	for it.Next() {
+		if len(it.Key()) != len(dictPrefix)+4 {
+			continue
+		}
+		id := binary.BigEndian.Uint32(it.Key()[len(dictPrefix):])
+		for uint32(len(d.strings)) <= id {
+			d.strings = append(d.strings, "")
+		}
+		d.strings[id] = string(it.Value())
+		d.ids[string(it.Value())] = id
+	}
+	return d, it.Error()
+}
+
+// id returns the ID of s. A new ID is stored before it is returned, so a
+// record never refers to a missing entry.
+func (d *dictionary) id(db *leveldb.DB, s string) (uint32, error) {
+	d.lock.RLock()
+	id, ok := d.ids[s]
+	d.lock.RUnlock()
+	if ok {
+		return id, nil
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if id, ok := d.ids[s]; ok {
+		return id, nil
+	}
+	id = uint32(len(d.strings))
+	k := binary.BigEndian.AppendUint32(append([]byte{}, dictPrefix...), id)
+	if err := db.Put(k, []byte(s), nil); err != nil {
+		return 0, err
+	}
+	d.strings = append(d.strings, s)
+	d.ids[s] = id
+	return id, nil
+}
+
+func (d *dictionary) string(id uint64) (string, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	if id >= uint64(len(d.strings)) {
+		return "", ErrBadEncoding
+	}
+	return d.strings[id], nil
+}
+
+// marshal encodes the measurement in the encoding of the cache.
+func (c *Cache) marshal(m *model.Measurement) ([]byte, error) {
+	switch c.encoding {
+	case EncodingJSON:
+		return marshalMeasurement(m)
+	case EncodingCompact, EncodingSnappy:
+	default:
+		return nil, ErrBadEncoding
+	}
+	b, err := c.marshalCompact(m)
+	if err != nil {
+		return nil, err
+	}
+	if c.encoding == EncodingSnappy {
+		return append([]byte{recordSnappy}, snappy.Encode(nil, b[1:])...), nil
+	}
+	return b, nil
+}
+
+// unmarshal decodes a record in any encoding.
+func (c *Cache) unmarshal(b []byte) (*model.Measurement, error) {
+	if len(b) == 0 {
+		return nil, ErrBadEncoding
+	}
+	switch b[0] {
+	case recordCompact:
+		return c.unmarshalCompact(b[1:])
+	case recordSnappy:
+		p, err := snappy.Decode(nil, b[1:])
+		if err != nil {
+			return nil, err
+		}
+		return c.unmarshalCompact(p)
+	}
+	return unmarshalMeasurement(b)
+}
+
+// recordEncoding returns the encoding of a record.
+func recordEncoding(b []byte) Encoding {
+	if len(b) > 0 {
+		switch b[0] {
+		case recordCompact:
+			return EncodingCompact
+		case recordSnappy:
+			return EncodingSnappy
+		}
+	}
+	return EncodingJSON
+}
+
+// The compact record is the time in nanoseconds and the zone offset in
+// seconds as varints, the manufacturer and the identification and the data
+// sets. A data set is the dictionary IDs of the address and the unit and
+// the value, a string or a scaled integer.
+func (c *Cache) marshalCompact(m *model.Measurement) ([]byte, error) {
+	b := []byte{recordCompact}
+	_, offset := m.Time.Zone()
+	b = binary.AppendVarint(b, m.Time.UnixNano())
+	b = binary.AppendVarint(b, int64(offset))
+	b = appendString(b, m.ManufacturerID)
+	b = appendString(b, m.Identification)
+	b = binary.AppendUvarint(b, uint64(len(m.Readings)))
+	for _, ds := range m.Readings {
+		a, err := c.dict.id(c.db, ds.Address)
+		if err != nil {
+			return nil, err
+		}
+		u, err := c.dict.id(c.db, ds.Unit)
+		if err != nil {
+			return nil, err
+		}
+		b = binary.AppendUvarint(b, uint64(a))
+		b = binary.AppendUvarint(b, uint64(u))
+		b = appendValue(b, ds.Value)
+	}
+	return b, nil
+}
+
+func (c *Cache) unmarshalCompact(b []byte) (*model.Measurement, error) {
+	r := &reader{b: b}
+	ns := r.varint()
+	offset := r.varint()
+	m := &model.Measurement{
+		Time:           zoned(time.Unix(0, ns), int(offset)),
+		ManufacturerID: r.string(),
+		Identification: r.string(),
+	}
+	n := r.uvarint()
+	if r.err == nil && n > uint64(len(b)) {
+		return nil, ErrBadEncoding
+	}
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		a, u := r.uvarint(), r.uvarint()
+		v := r.value()
+		if r.err != nil {
+			break
+		}
+		ds := model.DataSet{Value: v}
+		var err error
+		if ds.Address, err = c.dict.string(a); err != nil {
+			return nil, err
+		}
+		if ds.Unit, err = c.dict.string(u); err != nil {
+			return nil, err
+		}
+		m.Readings = append(m.Readings, ds)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return m, nil
+}
+
+// zoned returns t in the zone with the offset, the local zone if it has
+// the offset at t, as a JSON record would be decoded.
+func zoned(t time.Time, offset int) time.Time {
+	if offset == 0 {
+		return t.UTC()
+	}
+	if _, lo := t.In(time.Local).Zone(); lo == offset {
+		return t.In(time.Local)
+	}
+	return t.In(time.FixedZone("", offset))
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// appendValue stores a decimal value as a scaled integer with the number of
+// integer and fraction digits, so leading and trailing zeros are kept. Other
+// values are stored as strings.
+func appendValue(b []byte, v string) []byte {
+	mantissa, ints, fracs, ok := parseDecimal(v)
+	if !ok {
+		b = append(b, valueString)
+		return appendString(b, v)
+	}
+	b = append(b, valueScaled)
+	b = binary.AppendVarint(b, mantissa)
+	b = binary.AppendUvarint(b, uint64(ints))
+	return binary.AppendUvarint(b, uint64(fracs))
+}
+
+// parseDecimal parses an optionally negative decimal without exponent. ok
+// is false if formatDecimal would not return the same string.
+func parseDecimal(v string) (mantissa int64, ints, fracs int, ok bool) {
+	s := v
+	neg := strings.HasPrefix(s, "-")
+	if neg {
+		s = s[1:]
+	}
+	i, f, hasPoint := strings.Cut(s, ".")
+	if len(i) == 0 || (hasPoint && len(f) == 0) || len(i)+len(f) > 18 {
+		return 0, 0, 0, false
+	}
+	for _, c := range i + f {
+		if c < '0' || c > '9' {
+			return 0, 0, 0, false
+		}
+		mantissa = mantissa*10 + int64(c-'0')
+	}
+	if neg {
+		mantissa = -mantissa
+	}
+	ints, fracs = len(i), len(f)
+	if formatDecimal(mantissa, ints, fracs) != v {
+		// E.g. "-0".
+		return 0, 0, 0, false
+	}
+	return mantissa, ints, fracs, true
+}
+
+// formatDecimal formats the scaled integer with the digits.
+func formatDecimal(mantissa int64, ints, fracs int) string {
+	neg := mantissa < 0
+	if neg {
+		mantissa = -mantissa
+	}
+	digits := make([]byte, ints+fracs)
+	for i := len(digits) - 1; i >= 0; i-- {
+		digits[i] = byte('0' + mantissa%10)
+		mantissa /= 10
+	}
+	s := string(digits[:ints])
+	if fracs > 0 {
+		s += "." + string(digits[ints:])
+	}
+	if neg {
+		s = "-" + s
+	}
+	return s
+}
+
+// reader decodes a compact record, the first error is kept.
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.err = ErrBadEncoding
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *reader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.b)
+	if n <= 0 {
+		r.err = ErrBadEncoding
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *reader) string() string {
+	n := r.uvarint()
+	if r.err != nil {
+		return ""
+	}
+	if n > uint64(len(r.b)) {
+		r.err = ErrBadEncoding
+		return ""
+	}
+	s := string(r.b[:n])
+	r.b = r.b[n:]
+	return s
+}
+
+func (r *reader) value() string {
+	if r.err != nil {
+		return ""
+	}
+	if len(r.b) == 0 {
+		r.err = ErrBadEncoding
+		return ""
+	}
+	kind := r.b[0]
+	r.b = r.b[1:]
+	switch kind {
+	case valueString:
+		return r.string()
+	case valueScaled:
+		m := r.varint()
+		ints, fracs := r.uvarint(), r.uvarint()
+		if r.err == nil && (ints+fracs > 19 || ints+fracs < 1 || m == math.MinInt64) {
+			r.err = ErrBadEncoding
+		}
+		if r.err != nil {
+			return ""
+		}
+		return formatDecimal(m, int(ints), int(fracs))
+	}
+	r.err = ErrBadEncoding
+	return ""
+}
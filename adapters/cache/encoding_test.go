@@ -0,0 +1,189 @@
+package cache
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/model"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+var encodingM = &model.Measurement{
+	Time:           time.Date(2020, 1, 2, 15, 4, 5, 123, time.FixedZone("", 3600)),
+	ManufacturerID: "ISK",
+	Identification: "meter1",
+	Readings: []model.DataSet{
+		{Address: "1.8.1", Value: "000051.394", Unit: "kWh"},
+		{Address: "1.8.2", Value: "-12", Unit: "kWh"},
+		{Address: "0.9.1", Value: "(200102)", Unit: ""},
+		{Address: "1.7.0", Value: "1.5E3", Unit: "kW"},
+	},
+}
+
+func TestDecimal(t *testing.T) {
+	tests := []struct {
+		v  string
+		ok bool
+	}{
+		{"000051.394", true},
+		{"0", true},
+		{"-0.50", true},
+		{"12.", false},
+		{".5", false},
+		{"-0", false},
+		{"1e3", false},
+		{"1234567890123456789", false},
+		{"", false},
+	}
+	for _, tc := range tests {
+		m, i, f, ok := parseDecimal(tc.v)
+		if ok != tc.ok {
+			t.Errorf("%s: expected %v, received %v", tc.v, tc.ok, ok)
+			continue
+		}
+		if ok && formatDecimal(m, i, f) != tc.v {
+			t.Errorf("expected %v, received %v", tc.v, formatDecimal(m, i, f))
+		}
+	}
+}
+
+func TestEncodings(t *testing.T) {
+	for _, e := range []Encoding{EncodingCompact, EncodingSnappy, EncodingJSON} {
+		dir := filepath.Join(t.TempDir(), "db")
+		c, err := OpenWithOptions(dir, &Options{Encoding: e})
+		if err != nil {
+			t.Fatalf("Error opening database: %s", err.Error())
+		}
+		if err := c.Put(encodingM); err != nil {
+			t.Fatalf("%s: unexpected error: %s", e, err.Error())
+		}
+		v, _ := c.db.Get(key(encodingM), nil)
+		if recordEncoding(v) != e {
+			t.Errorf("expected %v, received %v", e, recordEncoding(v))
+		}
+		c.Close()
+
+		// The dictionary is read again.
+		c, err = Open(dir)
+		if err != nil {
+			t.Fatalf("Error opening database: %s", err.Error())
+		}
+		m, err := c.Get(key(encodingM))
+		c.Close()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", e, err.Error())
+		}
+		if !m.Time.Equal(encodingM.Time) {
+			t.Errorf("%s: expected %v, received %v", e, encodingM.Time, m.Time)
+		}
+		if _, off := m.Time.Zone(); off != 3600 {
+			t.Errorf("%s: expected %v, received %v", e, 3600, off)
+		}
+		if !reflect.DeepEqual(m.Readings, encodingM.Readings) {
+			t.Errorf("%s: expected %v, received %v", e, encodingM.Readings, m.Readings)
+		}
+	}
+}
+
+func TestReencode(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	c, err := OpenWithOptions(dir, &Options{Encoding: EncodingJSON})
+	if err != nil {
+		t.Fatalf("Error opening database: %s", err.Error())
+	}
+	t0 := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		m := *encodingM
+		m.Time = t0.Add(time.Duration(i) * time.Minute)
+		c.Put(&m)
+	}
+	c.Close()
+
+	c, err = OpenWithOptions(dir, &Options{Encoding: EncodingSnappy})
+	if err != nil {
+		t.Fatalf("Error opening database: %s", err.Error())
+	}
+	defer c.Close()
+	cursor, total, steps := "", 0, 0
+	for {
+		next, n, err := c.Reencode(cursor, 2)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		total += n
+		steps++
+		if len(next) == 0 {
+			break
+		}
+		cursor = next
+	}
+	if total != 5 || steps != 3 {
+		t.Errorf("expected %v in %v steps, received %v in %v", 5, 3, total, steps)
+	}
+	mm, err := c.GetAll()
+	if err != nil || len(mm) != 5 {
+		t.Fatalf("expected %v, received %v %v", 5, len(mm), err)
+	}
+	it := c.measurements()
+	defer it.Release()
+	for it.Next() {
+		if e := recordEncoding(it.Value()); e != EncodingSnappy {
+			t.Errorf("expected %v, received %v", EncodingSnappy, e)
+		}
+	}
+	// Nothing left.
+	if _, n, _ := c.Reencode("", 10); n != 0 {
+		t.Errorf("expected %v, received %v", 0, n)
+	}
+}
+
+// TestReencodeStale converts records that were deleted or replaced after
+// Reencode read them, they are not written back.
+func TestReencodeStale(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	c, err := OpenWithOptions(dir, &Options{Encoding: EncodingJSON})
+	if err != nil {
+		t.Fatalf("Error opening database: %s", err.Error())
+	}
+	t0 := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	var keys [][]byte
+	for i := 0; i < 3; i++ {
+		m := *encodingM
+		m.Time = t0.Add(time.Duration(i) * time.Minute)
+		c.Put(&m)
+		keys = append(keys, key(&m))
+	}
+	c.Close()
+
+	if c, err = OpenWithOptions(dir, &Options{Encoding: EncodingSnappy}); err != nil {
+		t.Fatalf("Error opening database: %s", err.Error())
+	}
+	defer c.Close()
+	deleted := *encodingM
+	deleted.Time = t0
+	if err := c.Delete(&deleted); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	replaced := *encodingM
+	replaced.Time = t0.Add(time.Minute)
+	replaced.Readings = []model.DataSet{{Address: "1.8.1", Value: "1", Unit: "kWh"}}
+	if err := c.Put(&replaced); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	n, err := c.reencode(keys)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if n != 1 {
+		t.Errorf("expected %v, received %v", 1, n)
+	}
+	if _, err := c.db.Get(keys[0], nil); err != leveldb.ErrNotFound {
+		t.Errorf("expected %v, received %v", leveldb.ErrNotFound, err)
+	}
+	if m, err := c.Get(keys[1]); err != nil || len(m.Readings) != 1 {
+		t.Errorf("expected %v, received %v %v", replaced.Readings, m, err)
+	}
+}
@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+// Import puts the measurements from r into the repo. r contains a JSON
+// array of measurements or one measurement per line (NDJSON).
+// Returns the number of imported measurements.
+func Import(repo model.MeasurementRepo, r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+	// Skip white space to find the start of an array.
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if b == ' ' || b == '\t' || b == '\r' || b == '\n' {
+			continue
+		}
+		br.UnreadByte()
+		if b == '[' {
+			var mm []*model.Measurement
+			if err := json.NewDecoder(br).Decode(&mm); err != nil {
+				return 0, err
+			}
+			for i, m := range mm {
+				if err := repo.Put(m); err != nil {
+					return i, err
+				}
+			}
+			return len(mm), nil
+		}
+		break
+	}
+	dec := json.NewDecoder(br)
+	n := 0
+	for {
+		m := &model.Measurement{}
+		err := dec.Decode(m)
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if err := repo.Put(m); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
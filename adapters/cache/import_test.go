@@ -0,0 +1,31 @@
+package cache
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImport(t *testing.T) {
+	for name, input := range map[string]string{
+		"array":  `[{"Time":"2020-01-02T15:04:05Z","ManufacturerID":"ISK","Identification":"m1"},{"Time":"2020-01-02T15:05:05Z","ManufacturerID":"ISK","Identification":"m1"}]`,
+		"ndjson": "{\"Time\":\"2020-01-02T15:04:05Z\",\"ManufacturerID\":\"ISK\",\"Identification\":\"m1\"}\n{\"Time\":\"2020-01-02T15:05:05Z\",\"ManufacturerID\":\"ISK\",\"Identification\":\"m1\"}\n",
+	} {
+		c, err := Open(filepath.Join(t.TempDir(), "db"))
+		if err != nil {
+			t.Fatalf("Error opening database: %s", err.Error())
+		}
+		n, err := Import(c, strings.NewReader(input))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", name, err.Error())
+		}
+		if n != 2 {
+			t.Errorf("%s: expected %v, received %v", name, 2, n)
+		}
+		mm, err := c.GetAll()
+		if err != nil || len(mm) != 2 {
+			t.Errorf("%s: expected %v, received %v %v", name, 2, len(mm), err)
+		}
+		c.Close()
+	}
+}
@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/model"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Keys of the measurements are the meter prefix, the meter ID followed by a
+// 0 byte, and the UTC time in nanoseconds, big endian with the sign bit
+// flipped. The keys of a meter sort by time.
+//
+// Version 1 keys were the formatted time, manufacturer and identification,
+// which did not sort by time across time zones. Open migrates them.
+
+const (
+	// keyVersion is the version of the key format.
+	keyVersion = "2"
+	// timeLen is the length of the time at the end of a key.
+	timeLen = 8
+	// migrateBatch is the number of measurements migrated per write.
+	migrateBatch = 1000
+)
+
+// versionKey holds the key version of the database.
+var versionKey = []byte("\xffversion")
+
+// meterPrefix returns the prefix of the keys of the meter.
+func meterPrefix(meterID string) []byte {
+	return append([]byte(meterID), 0)
+}
+
+// appendTime appends the sortable time to b.
+func appendTime(b []byte, t time.Time) []byte {
+	return binary.BigEndian.AppendUint64(b, uint64(t.UnixNano())^(1<<63))
+}
+
+// keyTime returns the time of the sortable time t.
+func keyTime(t []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(t)^(1<<63))).UTC()
+}
+
+func key(m *model.Measurement) []byte {
+	return appendTime(meterPrefix(m.MeterID()), m.Time)
+}
+
+// splitKey returns the meter prefix and the time of the key, ok is false
+// for a key in another format.
+func splitKey(k []byte) (prefix, t []byte, ok bool) {
+	i := bytes.IndexByte(k, 0)
+	if i < 0 || len(k) != i+1+timeLen {
+		return nil, nil, false
+	}
+	return k[:i+1], k[i+1:], true
+}
+
+// migrate converts the keys of the measurements to the current version.
+// Measurements are moved in batches, keys that are already converted are
+// skipped, so an interrupted migration continues at the next open.
+func (c *Cache) migrate() error {
+	v, err := c.db.Get(versionKey, nil)
+	if err == nil && string(v) == keyVersion {
+		return nil
+	}
+	if err != nil && err != leveldb.ErrNotFound {
+		return err
+	}
+	// The iterator reads a snapshot, the batches do not disturb it.
+	it := c.measurements()
+	defer it.Release()
+	b := new(leveldb.Batch)
+	for it.Next() {
+		old := it.Key()
+		if _, _, ok := splitKey(old); ok {
+			continue
+		}
+		m, err := c.unmarshal(it.Value())
+		if err != nil {
+			// Not a measurement, leave it.
+			continue
+		}
+		k := key(m)
+		b.Put(k, it.Value())
+		b.Delete(old)
+		if ok, err := c.db.Has(outboxKey(old), nil); err != nil {
+			return err
+		} else if ok {
+			b.Put(outboxKey(k), nil)
+			b.Delete(outboxKey(old))
+		}
+		if b.Len() >= migrateBatch {
+			if err := c.db.Write(b, nil); err != nil {
+				return err
+			}
+			b.Reset()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	b.Put(versionKey, []byte(keyVersion))
+	return c.db.Write(b, nil)
+}
+
+// firstLast returns the key and value of the first or the last measurement
+// in time. The keys sort by meter first, so the first or last measurement of
+// every meter is compared.
+func (c *Cache) firstLast(last bool) ([]byte, []byte, error) {
+	it := c.measurements()
+	defer it.Release()
+	var bestKey, bestValue, bestTime []byte
+	for ok := it.First(); ok; {
+		prefix, _, valid := splitKey(it.Key())
+		if !valid {
+			ok = it.Next()
+			continue
+		}
+		limit := util.BytesPrefix(append([]byte{}, prefix...)).Limit
+		if last {
+			if it.Seek(limit) {
+				it.Prev()
+			} else {
+				it.Last()
+			}
+		}
+		k := it.Key()
+		if _, t, valid := splitKey(k); valid && (bestKey == nil || (!last && bytes.Compare(t, bestTime) < 0) || (last && bytes.Compare(t, bestTime) > 0)) {
+			bestKey = append([]byte{}, k...)
+			bestValue = append([]byte{}, it.Value()...)
+			bestTime = bestKey[len(bestKey)-timeLen:]
+		}
+		ok = it.Seek(limit)
+	}
+	if err := it.Error(); err != nil {
+		return nil, nil, err
+	}
+	if bestKey == nil {
+		return nil, nil, ErrNoElements
+	}
+	return bestKey, bestValue, nil
+}
@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/model"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestKeyOrder(t *testing.T) {
+	t0 := time.Date(2020, 1, 2, 15, 0, 0, 0, time.UTC)
+	m1 := &model.Measurement{Time: t0, ManufacturerID: "ISK", Identification: "m1"}
+	m2 := &model.Measurement{Time: t0.Add(time.Nanosecond).In(time.FixedZone("CET", 3600)), ManufacturerID: "ISK", Identification: "m1"}
+	if bytes.Compare(key(m1), key(m2)) >= 0 {
+		t.Errorf("expected %v before %v", m1.Time, m2.Time)
+	}
+	// The same instant in another zone has the same key.
+	m3 := &model.Measurement{Time: t0.In(time.FixedZone("CET", 3600)), ManufacturerID: "ISK", Identification: "m1"}
+	if !bytes.Equal(key(m1), key(m3)) {
+		t.Errorf("expected %v, received %v", key(m1), key(m3))
+	}
+	before := &model.Measurement{Time: time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC), ManufacturerID: "ISK", Identification: "m1"}
+	if bytes.Compare(key(before), key(m1)) >= 0 {
+		t.Errorf("expected %v before %v", before.Time, m1.Time)
+	}
+}
+
+func TestFirstLastAcrossMeters(t *testing.T) {
+	c, err := Open(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatalf("Error opening database: %s", err.Error())
+	}
+	defer c.Close()
+	if _, err := c.Get([]byte(model.First)); err != ErrNoElements {
+		t.Errorf("expected %v, received %v", ErrNoElements, err)
+	}
+	t0 := time.Date(2020, 1, 2, 15, 0, 0, 0, time.UTC)
+	// Meter a sorts first but has neither the first nor the last measurement.
+	c.Put(&model.Measurement{Time: t0.Add(time.Minute), ManufacturerID: "a", Identification: "1"})
+	c.Put(&model.Measurement{Time: t0.Add(2 * time.Minute), ManufacturerID: "a", Identification: "1"})
+	c.Put(&model.Measurement{Time: t0, ManufacturerID: "b", Identification: "1"})
+	c.Put(&model.Measurement{Time: t0.Add(3 * time.Minute), ManufacturerID: "b", Identification: "2"})
+
+	first, err := c.Get([]byte(model.First))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if !first.Time.Equal(t0) || first.ManufacturerID != "b" {
+		t.Errorf("expected %v, received %v", t0, first.Time)
+	}
+	last, err := c.Get([]byte(model.Last))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if !last.Time.Equal(t0.Add(3*time.Minute)) || last.Identification != "2" {
+		t.Errorf("expected %v, received %v", t0.Add(3*time.Minute), last.Time)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	// Create a version 1 database with a queued measurement.
+	db, err := leveldb.OpenFile(dir, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	t0 := time.Date(2020, 1, 2, 15, 0, 0, 0, time.UTC)
+	var mm []*model.Measurement
+	for i := 0; i < 3; i++ {
+		m := &model.Measurement{Time: t0.Add(time.Duration(i) * time.Minute), ManufacturerID: "ISK", Identification: "m1"}
+		mm = append(mm, m)
+		k := []byte(m.Time.String() + "|" + m.ManufacturerID + "|" + m.Identification)
+		v, _ := marshalMeasurement(m)
+		db.Put(k, v, nil)
+		if i == 2 {
+			db.Put(outboxKey(k), nil, nil)
+		}
+	}
+	db.Put(outboxEnabledKey, nil, nil)
+	db.Close()
+
+	c, err := OpenWithOptions(dir, &Options{Outbox: true})
+	if err != nil {
+		t.Fatalf("Error opening database: %s", err.Error())
+	}
+	defer c.Close()
+	for _, m := range mm {
+		if _, err := c.Get(key(m)); err != nil {
+			t.Errorf("expected %v, received %v", nil, err)
+		}
+	}
+	all, err := c.GetAll()
+	if err != nil || len(all) != len(mm) {
+		t.Errorf("expected %v, received %v %v", len(mm), len(all), err)
+	}
+	keys, pending, err := c.Pending(10)
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("expected %v, received %v %v", 1, len(pending), err)
+	}
+	// The key of the measurement with version 0.
+	if expected := append(key(mm[2]), make([]byte, 8)...); !bytes.Equal(keys[0], expected) {
+		t.Errorf("expected %v, received %v", expected, keys[0])
+	}
+	v, err := c.db.Get(versionKey, nil)
+	if err != nil || string(v) != keyVersion {
+		t.Errorf("expected %v, received %v %v", keyVersion, string(v), err)
+	}
+}
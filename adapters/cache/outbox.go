@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"encoding/binary"
+
+	"github.com/peterzandbergen/iec62056/model"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+var (
+	// outboxPrefix starts the keys of the queued measurements, followed by
+	// the key of the measurement, so the queue of a meter is in time order.
+	outboxPrefix = []byte("\xffoutbox/")
+	// outboxEnabledKey is present while every stored measurement is queued,
+	// it is deleted when the cache is opened without the outbox.
+	outboxEnabledKey = []byte("\xffoutbox")
+)
+
+func outboxKey(k []byte) []byte {
+	return append(append([]byte{}, outboxPrefix...), k...)
+}
+
+// The value of an outbox key is the version of the queued measurement, a
+// number that increases with every Put. The keys of Pending end with the
+// version, Ack only removes the entry of that version, so a measurement
+// that was replaced after Pending stays queued. Entries of older caches have
+// no value, version 0.
+
+// queue adds the measurement with key k to the outbox. The caller holds the
+// write lock.
+func (c *Cache) queue(b *leveldb.Batch, k []byte) {
+	c.outboxVersion++
+	b.Put(outboxKey(k), binary.BigEndian.AppendUint64(nil, c.outboxVersion))
+}
+
+// outboxVersionOf returns the version of the outbox value.
+func outboxVersionOf(v []byte) uint64 {
+	if len(v) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(v)
+}
+
+// loadOutboxVersion sets the version to the highest in the outbox.
+func (c *Cache) loadOutboxVersion() error {
+	it := c.db.NewIterator(util.BytesPrefix(outboxPrefix), nil)
+	defer it.Release()
+	for it.Next() {
+		if v := outboxVersionOf(it.Value()); v > c.outboxVersion {
+			c.outboxVersion = v
+		}
+	}
+	return it.Error()
+}
+
+// enableOutbox queues the measurements stored before the outbox was enabled,
+// or while it was disabled. Measurements that were uploaded before it was
+// disabled are queued again, the uploads overwrite them.
+func (c *Cache) enableOutbox() error {
+	if err := c.loadOutboxVersion(); err != nil {
+		return err
+	}
+	if ok, err := c.db.Has(outboxEnabledKey, nil); err != nil || ok {
+		return err
+	}
+	b := new(leveldb.Batch)
+	it := c.measurements()
+	defer it.Release()
+	for it.Next() {
+		c.queue(b, it.Key())
+		if b.Len() >= migrateBatch {
+			if err := c.db.Write(b, nil); err != nil {
+				return err
+			}
+			b.Reset()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	b.Put(outboxEnabledKey, nil)
+	return c.db.Write(b, nil)
+}
+
+// disableOutbox records that measurements are stored without queuing them,
+// so the next enableOutbox queues them.
+func (c *Cache) disableOutbox() error {
+	return c.db.Delete(outboxEnabledKey, nil)
+}
+
+// Pending returns up to n measurements waiting for upload, per meter oldest
+// first, with their keys for Ack. Measurements stay pending until acknowledged,
+// also after a restart.
+func (c *Cache) Pending(n int) ([][]byte, []*model.Measurement, error) {
+	if c.db == nil {
+		return nil, nil, ErrClosed
+	}
+	var keys [][]byte
+	var mm []*model.Measurement
+	var gone [][]byte
+	it := c.db.NewIterator(util.BytesPrefix(outboxPrefix), nil)
+	defer it.Release()
+	for len(mm) < n && it.Next() {
+		k := it.Key()[len(outboxPrefix):]
+		// The key for Ack, with the version.
+		ak := binary.BigEndian.AppendUint64(append([]byte{}, k...), outboxVersionOf(it.Value()))
+		v, err := c.db.Get(k, nil)
+		if err == leveldb.ErrNotFound {
+			// Deleted before the upload.
+			gone = append(gone, ak)
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		m, err := c.unmarshal(v)
+		if err != nil {
+			// Cannot be uploaded, do not block the queue.
+			gone = append(gone, ak)
+			continue
+		}
+		keys = append(keys, ak)
+		mm = append(mm, m)
+	}
+	if err := it.Error(); err != nil {
+		return nil, nil, err
+	}
+	if len(gone) > 0 {
+		if err := c.Ack(gone); err != nil {
+			return nil, nil, err
+		}
+	}
+	return keys, mm, nil
+}
+
+// Ack removes the measurements with the keys of Pending from the upload
+// queue, unless they have been stored again since. The measurements stay
+// in the cache.
+func (c *Cache) Ack(keys [][]byte) error {
+	if c.db == nil {
+		return ErrClosed
+	}
+	c.write.Lock()
+	defer c.write.Unlock()
+	b := new(leveldb.Batch)
+	for _, ak := range keys {
+		if len(ak) < 8 {
+			return ErrBadArguments
+		}
+		ok := outboxKey(ak[:len(ak)-8])
+		v, err := c.db.Get(ok, nil)
+		if err == leveldb.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if outboxVersionOf(v) == binary.BigEndian.Uint64(ak[len(ak)-8:]) {
+			b.Delete(ok)
+		}
+	}
+	return c.db.Write(b, nil)
+}
+
+// Backlog returns the number of measurements waiting for upload.
+func (c *Cache) Backlog() (int, error) {
+	if c.db == nil {
+		return 0, ErrClosed
+	}
+	it := c.db.NewIterator(util.BytesPrefix(outboxPrefix), nil)
+	defer it.Release()
+	n := 0
+	for it.Next() {
+		n++
+	}
+	return n, it.Error()
+}
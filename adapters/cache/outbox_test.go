@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+func TestOutbox(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	c, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Error opening database: %s", err.Error())
+	}
+	t0 := time.Date(2020, 1, 2, 15, 0, 0, 0, time.UTC)
+	c.Put(&model.Measurement{Time: t0, Identification: "m1"})
+	c.Close()
+
+	// Enabling the outbox queues the existing measurement.
+	c, err = OpenWithOptions(dir, &Options{Outbox: true})
+	if err != nil {
+		t.Fatalf("Error opening database: %s", err.Error())
+	}
+	defer c.Close()
+	c.Put(&model.Measurement{Time: t0.Add(time.Minute), Identification: "m1"})
+	if n, err := c.Backlog(); err != nil || n != 2 {
+		t.Fatalf("expected %v, received %v %v", 2, n, err)
+	}
+	keys, mm, err := c.Pending(1)
+	if err != nil || len(mm) != 1 || !mm[0].Time.Equal(t0) {
+		t.Fatalf("unexpected pending: %v %v", mm, err)
+	}
+	if err := c.Ack(keys); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if n, _ := c.Backlog(); n != 1 {
+		t.Errorf("expected %v, received %v", 1, n)
+	}
+	// The outbox keys are not measurements.
+	if all, _ := c.GetAll(); len(all) != 2 {
+		t.Errorf("expected %v, received %v", 2, len(all))
+	}
+	last, err := c.Get([]byte(model.Last))
+	if err != nil || !last.Time.Equal(t0.Add(time.Minute)) {
+		t.Errorf("unexpected last: %v %v", last, err)
+	}
+}
+
+// TestOutboxDisabled queues the measurements stored in a run without the
+// outbox when it is enabled again.
+func TestOutboxDisabled(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	t0 := time.Date(2020, 1, 2, 15, 0, 0, 0, time.UTC)
+	for i, outbox := range []bool{true, false, true} {
+		c, err := OpenWithOptions(dir, &Options{Outbox: outbox})
+		if err != nil {
+			t.Fatalf("Error opening database: %s", err.Error())
+		}
+		if i == 0 {
+			// Uploaded.
+			c.Put(&model.Measurement{Time: t0, Identification: "m1"})
+			keys, _, err := c.Pending(10)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			c.Ack(keys)
+		}
+		if i == 1 {
+			c.Put(&model.Measurement{Time: t0.Add(time.Minute), Identification: "m1"})
+		}
+		if i == 2 {
+			// Both are queued, uploading the first again is harmless.
+			_, mm, err := c.Pending(10)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if len(mm) != 2 || !mm[1].Time.Equal(t0.Add(time.Minute)) {
+				t.Errorf("expected %v, received %v", "both measurements", mm)
+			}
+		}
+		c.Close()
+	}
+}
+
+// TestOutboxReplaced keeps a measurement queued that was stored again after
+// Pending returned it.
+func TestOutboxReplaced(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	c, err := OpenWithOptions(dir, &Options{Outbox: true})
+	if err != nil {
+		t.Fatalf("Error opening database: %s", err.Error())
+	}
+	t0 := time.Date(2020, 1, 2, 15, 0, 0, 0, time.UTC)
+	m := &model.Measurement{Time: t0, Identification: "m1", Readings: []model.DataSet{{Address: "1.8.1", Value: "1"}}}
+	c.Put(m)
+	keys, _, err := c.Pending(10)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	m.Readings[0].Value = "2"
+	c.Put(m)
+	if err := c.Ack(keys); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	c.Close()
+
+	// Also after a restart.
+	if c, err = OpenWithOptions(dir, &Options{Outbox: true}); err != nil {
+		t.Fatalf("Error opening database: %s", err.Error())
+	}
+	defer c.Close()
+	keys, mm, err := c.Pending(10)
+	if err != nil || len(mm) != 1 || mm[0].Readings[0].Value != "2" {
+		t.Fatalf("expected %v, received %v %v", "the replaced measurement", mm, err)
+	}
+	m.Readings[0].Value = "3"
+	c.Put(m)
+	c.Ack(keys)
+	if n, _ := c.Backlog(); n != 1 {
+		t.Errorf("expected %v, received %v", 1, n)
+	}
+}
+
+// TestOutboxKeepState opens the cache for maintenance between two runs with
+// the outbox, the uploaded measurements are not queued again.
+func TestOutboxKeepState(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	if _, err := OpenWithOptions(dir, &Options{ErrorIfMissing: true}); err == nil {
+		t.Errorf("expected an error for a missing cache")
+	}
+	t0 := time.Date(2020, 1, 2, 15, 0, 0, 0, time.UTC)
+	for _, o := range []*Options{{Outbox: true}, {KeepOutboxState: true, ErrorIfMissing: true}, {Outbox: true}} {
+		c, err := OpenWithOptions(dir, o)
+		if err != nil {
+			t.Fatalf("Error opening database: %s", err.Error())
+		}
+		if n, _ := c.Backlog(); n != 0 {
+			t.Errorf("%+v: expected %v, received %v", o, 0, n)
+		}
+		// Stored and uploaded.
+		c.Put(&model.Measurement{Time: t0, Identification: "m1"})
+		keys, _, err := c.Pending(10)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if len(keys) != 1 {
+			t.Errorf("%+v: expected %v, received %v", o, 1, len(keys))
+		}
+		c.Ack(keys)
+		c.Close()
+	}
+}
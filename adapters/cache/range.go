@@ -0,0 +1,160 @@
+package cache
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/model"
+
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+var _ model.Walker = &Cache{}
+
+// meterRange returns the key range of the measurements of the meter from
+// from up to to, a zero time leaves the range open at that end.
+func meterRange(prefix []byte, from, to time.Time) *util.Range {
+	r := util.BytesPrefix(prefix)
+	if !from.IsZero() {
+		r.Start = appendTime(append([]byte{}, prefix...), from)
+	}
+	if !to.IsZero() {
+		r.Limit = appendTime(append([]byte{}, prefix...), to)
+	}
+	return r
+}
+
+// GetRange returns up to limit measurements of the meter in the time range,
+// see model.MeasurementRepo. Without a meter ID the measurements of all
+// meters are merged in time order. With retention policies older ranges
+// come from the rollups, see Walk.
+func (c *Cache) GetRange(meterID string, from, to time.Time, limit int) ([]*model.Measurement, error) {
+	ms := make([]*model.Measurement, 0)
+	err := c.Walk(meterID, from, to, func(m *model.Measurement) error {
+		ms = append(ms, m)
+		if limit > 0 && len(ms) >= limit {
+			return model.ErrStopWalk
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+// Walk calls fn for the measurements of the meter in the time range, see
+// model.Walker. Only one measurement per meter is held in memory. A
+// measurement that cannot be decoded ends the walk with ErrCorrupt.
+//
+// With retention policies a range that starts before the raw measurements
+// expire is read from the finest rollups that still cover it, the rollups
+// are passed as measurements at the start of their period.
+func (c *Cache) Walk(meterID string, from, to time.Time, fn model.WalkFunc) error {
+	if c.db == nil {
+		return ErrClosed
+	}
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		return ErrBadArguments
+	}
+	if res := c.resolutionFor(from, time.Now()); res > 0 {
+		return c.walk(rollupSpace(res), meterID, from, to, c.decodeRollup, fn)
+	}
+	return c.walk(nil, meterID, from, to, c.decodeMeasurement, fn)
+}
+
+// walk merges the records of the meters in the key space in time order.
+func (c *Cache) walk(space []byte, meterID string, from, to time.Time, decode func(k, v []byte) (*model.Measurement, error), fn model.WalkFunc) error {
+	var prefixes [][]byte
+	if len(meterID) > 0 {
+		prefixes = [][]byte{meterPrefix(meterID)}
+	} else {
+		var err error
+		if prefixes, err = c.meterPrefixes(space); err != nil {
+			return err
+		}
+	}
+	// An iterator per meter, positioned at its next measurement.
+	its := make([]iterator.Iterator, 0, len(prefixes))
+	defer func() {
+		for _, it := range its {
+			it.Release()
+		}
+	}()
+	for _, p := range prefixes {
+		it := c.db.NewIterator(meterRange(append(append([]byte{}, space...), p...), from, to), nil)
+		its = append(its, it)
+		if !nextMeasurement(it, len(space)) {
+			if err := it.Error(); err != nil {
+				return err
+			}
+		}
+	}
+	for {
+		// Few meters, a linear search for the earliest is fast enough.
+		next := -1
+		for i, it := range its {
+			if !it.Valid() {
+				continue
+			}
+			if next < 0 || bytes.Compare(it.Key()[len(it.Key())-timeLen:], its[next].Key()[len(its[next].Key())-timeLen:]) < 0 {
+				next = i
+			}
+		}
+		if next < 0 {
+			return nil
+		}
+		it := its[next]
+		m, err := decode(it.Key(), it.Value())
+		if err != nil {
+			return err
+		}
+		if err := fn(m); err != nil {
+			if err == model.ErrStopWalk {
+				return nil
+			}
+			return err
+		}
+		if !nextMeasurement(it, len(space)) {
+			if err := it.Error(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// nextMeasurement moves the iterator to the next key in the measurement
+// key format, after the key space of skip bytes.
+func nextMeasurement(it iterator.Iterator, skip int) bool {
+	for it.Next() {
+		if _, _, ok := splitKey(it.Key()[skip:]); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// meterPrefixes returns the key prefixes of the meters in the key space,
+// the measurements for an empty space.
+func (c *Cache) meterPrefixes(space []byte) ([][]byte, error) {
+	var it iterator.Iterator
+	if len(space) > 0 {
+		it = c.db.NewIterator(util.BytesPrefix(space), nil)
+	} else {
+		it = c.measurements()
+	}
+	defer it.Release()
+	var prefixes [][]byte
+	for ok := it.First(); ok; {
+		prefix, _, valid := splitKey(it.Key()[len(space):])
+		if !valid {
+			ok = it.Next()
+			continue
+		}
+		prefix = append([]byte{}, prefix...)
+		prefixes = append(prefixes, prefix)
+		ok = it.Seek(util.BytesPrefix(append(append([]byte{}, space...), prefix...)).Limit)
+	}
+	return prefixes, it.Error()
+}
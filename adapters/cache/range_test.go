@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+func TestGetRange(t *testing.T) {
+	c, err := Open(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatalf("Error opening database: %s", err.Error())
+	}
+	defer c.Close()
+	t0 := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 48; i++ {
+		c.Put(&model.Measurement{Time: t0.Add(time.Duration(i) * time.Hour), ManufacturerID: "ISK", Identification: "m1"})
+		c.Put(&model.Measurement{Time: t0.Add(time.Duration(i)*time.Hour + time.Minute), ManufacturerID: "ISK", Identification: "m2"})
+	}
+	day := t0.Add(24 * time.Hour)
+
+	tests := []struct {
+		name     string
+		meter    string
+		from, to time.Time
+		limit    int
+		n        int
+		first    time.Time
+	}{
+		{"day", "ISK/m1", t0, day, 0, 24, t0},
+		{"limit", "ISK/m1", t0, day, 5, 5, t0},
+		{"open from", "ISK/m1", time.Time{}, t0.Add(2 * time.Hour), 0, 2, t0},
+		{"open to", "ISK/m1", day, time.Time{}, 0, 24, day},
+		{"unknown meter", "ISK/m3", time.Time{}, time.Time{}, 0, 0, time.Time{}},
+		{"all meters", "", t0, t0.Add(2 * time.Hour), 0, 4, t0},
+		{"all meters limit", "", day, time.Time{}, 3, 3, day},
+	}
+	for _, tc := range tests {
+		mm, err := c.GetRange(tc.meter, tc.from, tc.to, tc.limit)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.name, err.Error())
+			continue
+		}
+		if len(mm) != tc.n {
+			t.Errorf("%s: expected %v, received %v", tc.name, tc.n, len(mm))
+			continue
+		}
+		if tc.n > 0 && !mm[0].Time.Equal(tc.first) {
+			t.Errorf("%s: expected %v, received %v", tc.name, tc.first, mm[0].Time)
+		}
+		for i := 1; i < len(mm); i++ {
+			if mm[i].Time.Before(mm[i-1].Time) {
+				t.Errorf("%s: not in time order at %d", tc.name, i)
+			}
+		}
+	}
+	if _, err := c.GetRange("", day, t0, 0); err != ErrBadArguments {
+		t.Errorf("expected %v, received %v", ErrBadArguments, err)
+	}
+}
+
+func TestWalkCorrupt(t *testing.T) {
+	c, err := Open(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatalf("Error opening database: %s", err.Error())
+	}
+	defer c.Close()
+	t0 := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	m := &model.Measurement{Time: t0, ManufacturerID: "ISK", Identification: "m1"}
+	c.Put(m)
+	m2 := &model.Measurement{Time: t0.Add(time.Minute), ManufacturerID: "ISK", Identification: "m1"}
+	c.db.Put(key(m2), []byte("{bad"), nil)
+
+	n := 0
+	err = c.Walk("", time.Time{}, time.Time{}, func(*model.Measurement) error {
+		n++
+		return nil
+	})
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected %v, received %v", ErrCorrupt, err)
+	}
+	if n != 1 {
+		t.Errorf("expected %v, received %v", 1, n)
+	}
+	if _, err := c.GetAll(); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected %v, received %v", ErrCorrupt, err)
+	}
+	if _, err := c.GetPage(0, 10); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected %v, received %v", ErrCorrupt, err)
+	}
+}
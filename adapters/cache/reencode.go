@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// Reencode converts up to n records after the cursor to the encoding of the
+// cache, so the old JSON records shrink. It returns the cursor to continue
+// with, empty when all records have been visited, and the number of
+// converted records. Records that cannot be decoded are left alone.
+func (c *Cache) Reencode(cursor string, n int) (string, int, error) {
+	if c.db == nil {
+		return "", 0, ErrClosed
+	}
+	if n <= 0 {
+		return "", 0, ErrBadArguments
+	}
+	it := c.measurements()
+	defer it.Release()
+	ok := it.First()
+	if len(cursor) > 0 {
+		k, err := decodeCursor(cursor)
+		if err != nil {
+			return "", 0, err
+		}
+		if ok = it.Seek(k); ok && string(it.Key()) == string(k) {
+			ok = it.Next()
+		}
+	}
+	// The keys of the records to convert, read again under the write lock,
+	// so a record deleted or replaced meanwhile is not written back.
+	var keys [][]byte
+	var last []byte
+	for visited := 0; ok && visited < n; ok = it.Next() {
+		if _, _, valid := splitKey(it.Key()); !valid {
+			continue
+		}
+		visited++
+		last = append(last[:0], it.Key()...)
+		if recordEncoding(it.Value()) != c.encoding {
+			keys = append(keys, append([]byte{}, it.Key()...))
+		}
+	}
+	if err := it.Error(); err != nil {
+		return "", 0, err
+	}
+	converted, err := c.reencode(keys)
+	if err != nil {
+		return "", 0, err
+	}
+	if !ok {
+		return "", converted, nil
+	}
+	return encodeCursor(last), converted, nil
+}
+
+// reencode converts the records with the keys that are still stored in
+// another encoding and returns the number of converted records.
+func (c *Cache) reencode(keys [][]byte) (int, error) {
+	c.write.Lock()
+	defer c.write.Unlock()
+	b := new(leveldb.Batch)
+	for _, k := range keys {
+		v, err := c.db.Get(k, nil)
+		if err == leveldb.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if recordEncoding(v) == c.encoding {
+			continue
+		}
+		m, err := c.unmarshal(v)
+		if err != nil {
+			continue
+		}
+		if v, err = c.marshal(m); err != nil {
+			return 0, err
+		}
+		b.Put(k, v)
+	}
+	if b.Len() == 0 {
+		return 0, nil
+	}
+	return b.Len(), c.db.Write(b, nil)
+}
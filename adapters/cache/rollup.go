@@ -0,0 +1,558 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/iec/obis"
+	"github.com/peterzandbergen/iec62056/model"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// Policy keeps the records of a resolution for the retention period.
+type Policy struct {
+	// Resolution is the period of the rollups, 0 for the raw measurements.
+	Resolution time.Duration
+	// Retention is the age after which the records are deleted, 0 keeps
+	// them forever.
+	Retention time.Duration
+}
+
+// ErrBadPolicy is returned for a policy that cannot be parsed or applied.
+var ErrBadPolicy = errors.New("bad retention policy")
+
+// ParsePolicies parses a comma separated list of resolution=retention
+// pairs, e.g. raw=30d,15m=2y,1d=forever. Durations are Go durations or a
+// number of days (d) or years (y) of 365 days.
+func ParsePolicies(s string) ([]Policy, error) {
+	var pp []Policy
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		res, ret, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrBadPolicy, item)
+		}
+		p := Policy{}
+		var err error
+		if res != "raw" {
+			if p.Resolution, err = parseDuration(res); err != nil || p.Resolution <= 0 {
+				return nil, fmt.Errorf("%w: %s", ErrBadPolicy, item)
+			}
+		}
+		if ret != "forever" {
+			if p.Retention, err = parseDuration(ret); err != nil || p.Retention <= 0 {
+				return nil, fmt.Errorf("%w: %s", ErrBadPolicy, item)
+			}
+		}
+		pp = append(pp, p)
+	}
+	return pp, checkPolicies(pp)
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "y": 365 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			v, err := strconv.Atoi(n)
+			if err != nil {
+				return 0, err
+			}
+			return time.Duration(v) * unit, nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+// checkPolicies sorts the policies by resolution and checks that every
+// resolution occurs once and that a rollup divides a day or is a number of
+// days, so the periods start at UTC midnight.
+func checkPolicies(pp []Policy) error {
+	sort.Slice(pp, func(i, j int) bool { return pp[i].Resolution < pp[j].Resolution })
+	for i, p := range pp {
+		if i > 0 && p.Resolution == pp[i-1].Resolution {
+			return fmt.Errorf("%w: resolution %s occurs twice", ErrBadPolicy, p.Resolution)
+		}
+		day := 24 * time.Hour
+		if p.Resolution > 0 && day%p.Resolution != 0 && p.Resolution%day != 0 {
+			return fmt.Errorf("%w: resolution %s does not divide a day", ErrBadPolicy, p.Resolution)
+		}
+	}
+	return nil
+}
+
+// Rollup summarises the measurements of a meter in a period. A period is
+// rolled up once, when it has completed. A measurement stored later for a
+// period that has been rolled up is not added to the rollup, and expires
+// with the raw measurements.
+type Rollup struct {
+	// Start of the period, UTC. The rollups are stored and read by the
+	// start of their period.
+	Start          time.Time
+	Resolution     time.Duration
+	ManufacturerID string
+	Identification string
+	// Count is the number of measurements in the period.
+	Count     int
+	Registers []Register
+}
+
+// Register is the summary of a data set in a period.
+type Register struct {
+	Address string
+	Unit    string
+	// Last value in the period, the meter reading at the end of the period
+	// for a cumulative register.
+	Last string
+	// Delta is the increase of a cumulative register since the previous
+	// period, or since the first measurement of the period if there is none.
+	Delta string `json:",omitempty"`
+	// Mean of a numeric register that is not cumulative.
+	Mean string `json:",omitempty"`
+}
+
+// Measurement returns the rollup as a measurement at the start of the
+// period, with the last value of the cumulative registers and the mean of
+// the other numeric registers. The time of the measurement is the start of
+// the period for all registers, also for the cumulative registers that hold
+// the reading at the end of the period, so the measurement of a period is
+// found with a range that starts at the period.
+func (r *Rollup) Measurement() *model.Measurement {
+	m := &model.Measurement{
+		Time:           r.Start,
+		ManufacturerID: r.ManufacturerID,
+		Identification: r.Identification,
+	}
+	for _, reg := range r.Registers {
+		v := reg.Last
+		if len(reg.Mean) > 0 {
+			v = reg.Mean
+		}
+		m.Readings = append(m.Readings, model.DataSet{Address: reg.Address, Value: v, Unit: reg.Unit})
+	}
+	return m
+}
+
+// rollupPrefix starts the key spaces of the rollups, followed by the
+// resolution and the measurement key of the start of the period.
+var rollupPrefix = []byte("\xffrollup/")
+
+// markPrefix starts the keys with the end of the last completed period of
+// a resolution and meter.
+var markPrefix = []byte("\xffrollupmark/")
+
+func rollupSpace(res time.Duration) []byte {
+	return append(append([]byte{}, rollupPrefix...), res.String()+"/"...)
+}
+
+func markKey(res time.Duration, prefix []byte) []byte {
+	return append(append(append([]byte{}, markPrefix...), res.String()+"/"...), prefix...)
+}
+
+func (c *Cache) decodeRollup(k, v []byte) (*model.Measurement, error) {
+	r := &Rollup{}
+	if err := json.Unmarshal(v, r); err != nil {
+		return nil, fmt.Errorf("%w: key %x: %s", ErrCorrupt, k, err.Error())
+	}
+	return r.Measurement(), nil
+}
+
+// resolutionFor returns the finest resolution that still holds the records
+// from from, 0 for the raw measurements.
+func (c *Cache) resolutionFor(from, now time.Time) time.Duration {
+	if len(c.policies) == 0 || from.IsZero() || c.policies[0].Resolution > 0 {
+		// Without a raw policy the measurements are kept forever.
+		return 0
+	}
+	for _, p := range c.policies {
+		if p.Retention == 0 || !from.Before(now.Add(-p.Retention)) {
+			return p.Resolution
+		}
+	}
+	// Older than all retentions, the coarsest has the most left.
+	return c.policies[len(c.policies)-1].Resolution
+}
+
+// GetRollups returns the rollups of the meter at the resolution with a
+// start in the time range, all meters for an empty meter ID.
+func (c *Cache) GetRollups(res time.Duration, meterID string, from, to time.Time) ([]*Rollup, error) {
+	if c.db == nil {
+		return nil, ErrClosed
+	}
+	var prefixes [][]byte
+	if len(meterID) > 0 {
+		prefixes = [][]byte{meterPrefix(meterID)}
+	} else {
+		var err error
+		if prefixes, err = c.meterPrefixes(rollupSpace(res)); err != nil {
+			return nil, err
+		}
+	}
+	rr := make([]*Rollup, 0)
+	for _, p := range prefixes {
+		it := c.db.NewIterator(meterRange(append(rollupSpace(res), p...), from, to), nil)
+		for it.Next() {
+			r := &Rollup{}
+			if err := json.Unmarshal(it.Value(), r); err != nil {
+				it.Release()
+				return nil, fmt.Errorf("%w: key %x: %s", ErrCorrupt, it.Key(), err.Error())
+			}
+			rr = append(rr, r)
+		}
+		it.Release()
+		if err := it.Error(); err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(rr, func(i, j int) bool { return rr[i].Start.Before(rr[j].Start) })
+	return rr, nil
+}
+
+// Compact writes the rollups of the completed periods and deletes the
+// records older than their retention, and returns the number of rollups
+// written and records deleted. Raw measurements are only deleted after all
+// rollups of their period have been written and, with the outbox, after
+// they have been uploaded. Measurements that arrive after their period was
+// rolled up are not in the rollup.
+func (c *Cache) Compact(now time.Time) (rollups, deleted int, err error) {
+	if c.db == nil {
+		return 0, 0, ErrClosed
+	}
+	if len(c.policies) == 0 {
+		return 0, 0, nil
+	}
+	prefixes, err := c.compactPrefixes()
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, prefix := range prefixes {
+		// The raw measurements are kept until the slowest rollup.
+		rolled := now
+		for _, p := range c.policies {
+			if p.Resolution == 0 {
+				continue
+			}
+			n, mark, err := c.rollup(p.Resolution, prefix, now)
+			if err != nil {
+				return rollups, deleted, err
+			}
+			rollups += n
+			if mark.Before(rolled) {
+				rolled = mark
+			}
+		}
+		for _, p := range c.policies {
+			if p.Retention == 0 {
+				continue
+			}
+			limit := now.Add(-p.Retention)
+			space := []byte(nil)
+			if p.Resolution > 0 {
+				space = rollupSpace(p.Resolution)
+			} else if rolled.Before(limit) {
+				limit = rolled
+			}
+			n, err := c.expire(space, prefix, limit)
+			deleted += n
+			if err != nil {
+				return rollups, deleted, err
+			}
+		}
+	}
+	return rollups, deleted, nil
+}
+
+// compactPrefixes returns the prefixes of the meters with measurements or
+// rollups, so the rollups expire after the measurements of a meter.
+func (c *Cache) compactPrefixes() ([][]byte, error) {
+	seen := map[string]bool{}
+	var prefixes [][]byte
+	spaces := [][]byte{nil}
+	for _, p := range c.policies {
+		if p.Resolution > 0 {
+			spaces = append(spaces, rollupSpace(p.Resolution))
+		}
+	}
+	for _, space := range spaces {
+		pp, err := c.meterPrefixes(space)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range pp {
+			if !seen[string(p)] {
+				seen[string(p)] = true
+				prefixes = append(prefixes, p)
+			}
+		}
+	}
+	return prefixes, nil
+}
+
+// rollup writes the rollups of the meter for the periods that completed
+// since the mark, and returns the number and the new mark.
+func (c *Cache) rollup(res time.Duration, prefix []byte, now time.Time) (int, time.Time, error) {
+	mk := markKey(res, prefix)
+	var from time.Time
+	if v, err := c.db.Get(mk, nil); err == nil {
+		if err := from.UnmarshalBinary(v); err != nil {
+			return 0, time.Time{}, err
+		}
+	} else if err != leveldb.ErrNotFound {
+		return 0, time.Time{}, err
+	}
+	end := now.UTC().Truncate(res)
+	if !from.IsZero() && !from.Before(end) {
+		return 0, from, nil
+	}
+	var prev *Rollup
+	if !from.IsZero() {
+		// The last rollup for the deltas.
+		it := c.db.NewIterator(meterRange(append(rollupSpace(res), prefix...), time.Time{}, from), nil)
+		if it.Last() {
+			prev = &Rollup{}
+			if err := json.Unmarshal(it.Value(), prev); err != nil {
+				prev = nil
+			}
+		}
+		it.Release()
+	}
+
+	b := new(leveldb.Batch)
+	n := 0
+	var cur *Rollup
+	var acc *accumulator
+	flush := func() error {
+		if cur == nil {
+			return nil
+		}
+		acc.finish(cur, prev)
+		v, err := json.Marshal(cur)
+		if err != nil {
+			return err
+		}
+		b.Put(appendTime(append(rollupSpace(res), prefix...), cur.Start), v)
+		n++
+		prev = cur
+		if b.Len() < migrateBatch {
+			return nil
+		}
+		// The mark is written last, an interrupted rollup is repeated.
+		err = c.db.Write(b, nil)
+		b.Reset()
+		return err
+	}
+	it := c.db.NewIterator(meterRange(prefix, from, end), nil)
+	defer it.Release()
+	for nextMeasurement(it, 0) {
+		m, err := c.decodeMeasurement(it.Key(), it.Value())
+		if err != nil {
+			return 0, time.Time{}, err
+		}
+		start := m.Time.UTC().Truncate(res)
+		if cur == nil || !start.Equal(cur.Start) {
+			if err := flush(); err != nil {
+				return 0, time.Time{}, err
+			}
+			cur = &Rollup{Start: start, Resolution: res, ManufacturerID: m.ManufacturerID, Identification: m.Identification}
+			acc = &accumulator{}
+		}
+		cur.Count++
+		acc.add(m)
+	}
+	if err := it.Error(); err != nil {
+		return 0, time.Time{}, err
+	}
+	if err := flush(); err != nil {
+		return 0, time.Time{}, err
+	}
+	v, err := end.MarshalBinary()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	b.Put(mk, v)
+	return n, end, c.db.Write(b, nil)
+}
+
+// expire deletes the records of the meter in the key space before limit.
+// Measurements waiting in the outbox are kept.
+func (c *Cache) expire(space, prefix []byte, limit time.Time) (int, error) {
+	it := c.db.NewIterator(meterRange(append(append([]byte{}, space...), prefix...), time.Time{}, limit), nil)
+	defer it.Release()
+	b := new(leveldb.Batch)
+	var keys [][]byte
+	n := 0
+	for it.Next() {
+		k := append([]byte{}, it.Key()...)
+		if len(space) > 0 {
+			b.Delete(k)
+		} else {
+			keys = append(keys, k)
+		}
+		if b.Len()+len(keys) >= migrateBatch {
+			m, err := c.deleteRecords(b, keys)
+			if err != nil {
+				return n, err
+			}
+			n += m
+			b.Reset()
+			keys = keys[:0]
+		}
+	}
+	if err := it.Error(); err != nil {
+		return n, err
+	}
+	m, err := c.deleteRecords(b, keys)
+	return n + m, err
+}
+
+// deleteRecords writes the batch with the deletes of the records and of the
+// measurements with the keys, and returns the number of deletes. The
+// measurements are read again under the write lock, a measurement that was
+// queued for upload after the iterator was created is kept.
+func (c *Cache) deleteRecords(b *leveldb.Batch, keys [][]byte) (int, error) {
+	c.write.Lock()
+	defer c.write.Unlock()
+	n := b.Len()
+	for _, k := range keys {
+		if c.outbox {
+			if ok, err := c.db.Has(outboxKey(k), nil); err != nil {
+				return 0, err
+			} else if ok {
+				continue
+			}
+		}
+		if err := c.unindexStored(b, k); err != nil {
+			return 0, err
+		}
+		b.Delete(k)
+		n++
+	}
+	return n, c.db.Write(b, nil)
+}
+
+// cumulativeUnits are the units of registers that only increase.
+var cumulativeUnits = map[string]bool{
+	"Wh": true, "kWh": true, "MWh": true, "varh": true, "kvarh": true, "m3": true, "GJ": true,
+}
+
+// cumulative returns true for an energy or volume register, an OBIS time
+// integral or a register with an energy or volume unit.
+func cumulative(address, unit string) bool {
+	if code, err := obis.Parse(address); err == nil && code.D == 8 {
+		return true
+	}
+	return cumulativeUnits[unit]
+}
+
+// accumulator collects the data sets of a period in the order they first
+// occurred.
+type accumulator struct {
+	order []string
+	regs  map[string]*accRegister
+}
+
+type accRegister struct {
+	unit        string
+	first, last string
+	sum         int64
+	fracs       int
+	count       int
+	numeric     bool
+}
+
+func (a *accumulator) add(m *model.Measurement) {
+	if a.regs == nil {
+		a.regs = map[string]*accRegister{}
+	}
+	for _, ds := range m.Readings {
+		r, ok := a.regs[ds.Address]
+		if !ok {
+			r = &accRegister{unit: ds.Unit, first: ds.Value, numeric: true}
+			a.regs[ds.Address] = r
+			a.order = append(a.order, ds.Address)
+		}
+		r.last = ds.Value
+		r.count++
+		if !r.numeric {
+			continue
+		}
+		mant, _, fracs, ok := parseDecimal(ds.Value)
+		if !ok {
+			r.numeric = false
+			continue
+		}
+		r.sum, r.fracs = addScaled(r.sum, r.fracs, mant, fracs)
+	}
+}
+
+// finish stores the registers in the rollup, the deltas of the cumulative
+// registers are relative to the previous rollup.
+func (a *accumulator) finish(r *Rollup, prev *Rollup) {
+	last := map[string]string{}
+	if prev != nil {
+		for _, reg := range prev.Registers {
+			last[reg.Address] = reg.Last
+		}
+	}
+	for _, addr := range a.order {
+		ar := a.regs[addr]
+		reg := Register{Address: addr, Unit: ar.unit, Last: ar.last}
+		if ar.numeric {
+			if cumulative(addr, ar.unit) {
+				base, ok := last[addr]
+				if !ok {
+					base = ar.first
+				}
+				reg.Delta = subtractDecimal(ar.last, base)
+			} else {
+				reg.Mean = formatScaled(ar.sum/int64(ar.count), ar.fracs)
+			}
+		}
+		r.Registers = append(r.Registers, reg)
+	}
+}
+
+// addScaled adds two scaled integers at the larger scale.
+func addScaled(a int64, af int, b int64, bf int) (int64, int) {
+	for ; af < bf; af++ {
+		a *= 10
+	}
+	for ; bf < af; bf++ {
+		b *= 10
+	}
+	return a + b, af
+}
+
+// subtractDecimal returns a-b, empty if one is not a decimal.
+func subtractDecimal(a, b string) string {
+	am, _, af, ok := parseDecimal(a)
+	if !ok {
+		return ""
+	}
+	bm, _, bf, ok := parseDecimal(b)
+	if !ok {
+		return ""
+	}
+	d, fracs := addScaled(am, af, -bm, bf)
+	return formatScaled(d, fracs)
+}
+
+// formatScaled formats the scaled integer without leading zeros.
+func formatScaled(m int64, fracs int) string {
+	abs := m
+	if abs < 0 {
+		abs = -abs
+	}
+	ints := len(strconv.FormatInt(abs, 10)) - fracs
+	if ints < 1 {
+		ints = 1
+	}
+	return formatDecimal(m, ints, fracs)
+}
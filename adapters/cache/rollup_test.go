@@ -0,0 +1,185 @@
+package cache
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+func TestParsePolicies(t *testing.T) {
+	pp, err := ParsePolicies("1d=forever, raw=30d,15m=2y")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	expected := []Policy{
+		{Resolution: 0, Retention: 30 * 24 * time.Hour},
+		{Resolution: 15 * time.Minute, Retention: 2 * 365 * 24 * time.Hour},
+		{Resolution: 24 * time.Hour, Retention: 0},
+	}
+	if len(pp) != len(expected) {
+		t.Fatalf("expected %v, received %v", expected, pp)
+	}
+	for i := range pp {
+		if pp[i] != expected[i] {
+			t.Errorf("expected %v, received %v", expected[i], pp[i])
+		}
+	}
+	for _, s := range []string{"raw", "raw=x", "0m=1d", "7m=1d", "1h=1d,60m=2d", "1h=-1d"} {
+		if _, err := ParsePolicies(s); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+	if pp, err := ParsePolicies(""); err != nil || len(pp) != 0 {
+		t.Errorf("expected %v, received %v, %v", "no policies", pp, err)
+	}
+}
+
+// openRollups opens a cache with two days of measurements every 15 minutes
+// up to now, a cumulative register that increases by 1 and a power that is
+// 1 and 3 in turn.
+func openRollups(t *testing.T, o *Options) (*Cache, time.Time) {
+	c, err := OpenWithOptions(filepath.Join(t.TempDir(), "db"), o)
+	if err != nil {
+		t.Fatalf("Error opening database: %s", err.Error())
+	}
+	t.Cleanup(func() { c.Close() })
+	now := time.Now().UTC().Truncate(time.Hour)
+	for i := 0; i < 2*24*4; i++ {
+		m := &model.Measurement{
+			Time:           now.Add(-48 * time.Hour).Add(time.Duration(i) * 15 * time.Minute),
+			ManufacturerID: "ISK",
+			Identification: "meter1",
+			Readings: []model.DataSet{
+				{Address: "1.8.1", Value: strconv.Itoa(100 + i), Unit: "kWh"},
+				{Address: "1.7.0", Value: strconv.Itoa(1 + 2*(i%2)), Unit: "kW"},
+			},
+		}
+		if err := c.Put(m); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	return c, now
+}
+
+func TestCompact(t *testing.T) {
+	pp, _ := ParsePolicies("raw=1d,1h=30d,1d=forever")
+	c, now := openRollups(t, &Options{Policies: pp})
+
+	rollups, deleted, err := c.Compact(now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if deleted != 96 {
+		t.Errorf("expected %v, received %v", 96, deleted)
+	}
+	rr, err := c.GetRollups(time.Hour, "ISK/meter1", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(rr) != 48 {
+		t.Fatalf("expected %v, received %v", 48, len(rr))
+	}
+	days, _ := c.GetRollups(24*time.Hour, "", time.Time{}, time.Time{})
+	if rollups != len(rr)+len(days) {
+		t.Errorf("expected %v, received %v", len(rr)+len(days), rollups)
+	}
+	r := rr[1]
+	if r.Count != 4 || !r.Start.Equal(now.Add(-47*time.Hour)) {
+		t.Errorf("expected %v, received %v", "4 measurements at "+now.Add(-47*time.Hour).String(), r)
+	}
+	if reg := r.Registers[0]; reg.Last != "107" || reg.Delta != "4" || reg.Mean != "" {
+		t.Errorf("expected %v, received %+v", "last 107, delta 4", reg)
+	}
+	if reg := r.Registers[1]; reg.Mean != "2" || reg.Delta != "" {
+		t.Errorf("expected %v, received %+v", "mean 2", reg)
+	}
+	// The first period has no previous one.
+	if reg := rr[0].Registers[0]; reg.Delta != "3" {
+		t.Errorf("expected %v, received %v", "3", reg.Delta)
+	}
+
+	// Nothing new.
+	if rollups, deleted, err := c.Compact(now); err != nil || rollups != 0 || deleted != 0 {
+		t.Errorf("expected %v, received %v, %v, %v", "nothing", rollups, deleted, err)
+	}
+
+	// An old range comes from the rollups, a recent one from the raw
+	// measurements.
+	ms, err := c.GetRange("ISK/meter1", now.Add(-36*time.Hour), now.Add(-30*time.Hour), 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(ms) != 6 || !ms[0].Time.Equal(now.Add(-36*time.Hour)) || ms[0].Readings[1].Value != "2" {
+		t.Errorf("expected %v, received %v", "6 hourly rollups", ms)
+	}
+	ms, err = c.GetRange("", now.Add(-2*time.Hour), time.Time{}, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(ms) != 8 {
+		t.Errorf("expected %v, received %v", 8, len(ms))
+	}
+}
+
+// TestRollupMeasurement checks the time of a rollup as a measurement and
+// that a measurement stored after its period was rolled up only expires.
+func TestRollupMeasurement(t *testing.T) {
+	pp, _ := ParsePolicies("raw=1d,1h=forever")
+	c, now := openRollups(t, &Options{Policies: pp})
+	if _, _, err := c.Compact(now); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	rr, err := c.GetRollups(time.Hour, "ISK/meter1", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	// At the start of the period, with the reading at the end.
+	r := rr[1]
+	m := r.Measurement()
+	if !m.Time.Equal(r.Start) || m.Readings[0].Value != "107" || m.Readings[1].Value != "2" {
+		t.Errorf("expected %v, received %v", "107 and 2 at "+r.Start.String(), m)
+	}
+
+	late := &model.Measurement{
+		Time:           r.Start.Add(50 * time.Minute),
+		ManufacturerID: "ISK",
+		Identification: "meter1",
+		Readings:       []model.DataSet{{Address: "1.8.1", Value: "999", Unit: "kWh"}},
+	}
+	if err := c.Put(late); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	rollups, deleted, err := c.Compact(now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if rollups != 0 || deleted != 1 {
+		t.Errorf("expected %v, received %v, %v", "0 rollups and 1 deleted", rollups, deleted)
+	}
+	if rr, _ = c.GetRollups(time.Hour, "ISK/meter1", r.Start, r.Start.Add(time.Hour)); len(rr) != 1 || rr[0].Count != 4 || rr[0].Registers[0].Last != "107" {
+		t.Errorf("expected %v, received %v", r, rr)
+	}
+}
+
+func TestCompactOutbox(t *testing.T) {
+	pp, _ := ParsePolicies("raw=1d,1h=forever")
+	c, now := openRollups(t, &Options{Policies: pp, Outbox: true})
+	keys, _, err := c.Pending(10)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := c.Ack(keys); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	// Only the uploaded measurements expire.
+	_, deleted, err := c.Compact(now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if deleted != 10 {
+		t.Errorf("expected %v, received %v", 10, deleted)
+	}
+}
@@ -0,0 +1,232 @@
+package cache
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/iec/obis"
+	"github.com/peterzandbergen/iec62056/model"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// The series indexes hold the value of every register per meter, so a
+// register can be read without decoding the measurements. The keys are
+// seriesPrefix, the address, a 0 byte and the key of the measurement, the
+// values are the unit, a 0 byte and the value. Put, Delete and Compact keep
+// the indexes up to date, RebuildSeries rebuilds them, e.g. after an older
+// version of the cache has stored measurements.
+
+var _ model.SeriesWalker = &Cache{}
+
+var (
+	seriesPrefix = []byte("\xffseries/")
+	// seriesBuiltKey is present once the measurements have been indexed.
+	seriesBuiltKey = []byte("\xffseries")
+)
+
+// seriesAddress returns the address of the series of a register, the
+// canonical form of an OBIS code or else the address as read.
+func seriesAddress(address string) string {
+	if c, err := obis.Parse(address); err == nil {
+		return c.String()
+	}
+	return address
+}
+
+// seriesSpace returns the key space of the series of the address.
+func seriesSpace(address string) []byte {
+	return append(append(append([]byte{}, seriesPrefix...), address...), 0)
+}
+
+// indexSeries adds the samples of the measurement with key k to the batch.
+func indexSeries(b *leveldb.Batch, k []byte, m *model.Measurement) {
+	for _, r := range m.Readings {
+		b.Put(append(seriesSpace(seriesAddress(r.Address)), k...), []byte(r.Unit+"\x00"+r.Value))
+	}
+}
+
+// unindexSeries deletes the samples of the measurement with key k.
+func unindexSeries(b *leveldb.Batch, k []byte, m *model.Measurement) {
+	for _, r := range m.Readings {
+		b.Delete(append(seriesSpace(seriesAddress(r.Address)), k...))
+	}
+}
+
+// unindexStored deletes the samples of the measurement stored with key k,
+// if any. A measurement that cannot be decoded keeps its samples until the
+// indexes are rebuilt. The caller holds the write lock until the batch has
+// been written.
+func (c *Cache) unindexStored(b *leveldb.Batch, k []byte) error {
+	v, err := c.db.Get(k, nil)
+	if err == leveldb.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if m, err := c.unmarshal(v); err == nil {
+		unindexSeries(b, k, m)
+	}
+	return nil
+}
+
+// buildSeries indexes the measurements stored before the series indexes
+// were added.
+func (c *Cache) buildSeries() error {
+	if ok, err := c.db.Has(seriesBuiltKey, nil); err != nil || ok {
+		return err
+	}
+	_, err := c.RebuildSeries()
+	return err
+}
+
+// RebuildSeries deletes the series indexes and indexes all measurements
+// again. Measurements that cannot be decoded are skipped. It returns the
+// number of indexed measurements. Put and Delete wait for the rebuild.
+func (c *Cache) RebuildSeries() (int, error) {
+	if c.db == nil {
+		return 0, ErrClosed
+	}
+	c.write.Lock()
+	defer c.write.Unlock()
+	if err := c.db.Delete(seriesBuiltKey, nil); err != nil {
+		return 0, err
+	}
+	b := new(leveldb.Batch)
+	it := c.db.NewIterator(util.BytesPrefix(seriesPrefix), nil)
+	for it.Next() {
+		b.Delete(append([]byte{}, it.Key()...))
+		if b.Len() >= migrateBatch {
+			if err := c.db.Write(b, nil); err != nil {
+				it.Release()
+				return 0, err
+			}
+			b.Reset()
+		}
+	}
+	it.Release()
+	if err := it.Error(); err != nil {
+		return 0, err
+	}
+	n := 0
+	it = c.measurements()
+	defer it.Release()
+	for it.Next() {
+		if _, _, ok := splitKey(it.Key()); !ok {
+			continue
+		}
+		m, err := c.unmarshal(it.Value())
+		if err != nil {
+			continue
+		}
+		indexSeries(b, append([]byte{}, it.Key()...), m)
+		n++
+		if b.Len() >= migrateBatch {
+			if err := c.db.Write(b, nil); err != nil {
+				return n, err
+			}
+			b.Reset()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return n, err
+	}
+	b.Put(seriesBuiltKey, nil)
+	return n, c.db.Write(b, nil)
+}
+
+// seriesAddresses returns the addresses of the series that match the
+// address, in the order of their keys. An OBIS code matches the codes that
+// are equal in the groups that both have, see obis.Code.Matches, other
+// addresses must be equal.
+func (c *Cache) seriesAddresses(address string) ([]string, error) {
+	q, qerr := obis.Parse(address)
+	it := c.db.NewIterator(util.BytesPrefix(seriesPrefix), nil)
+	defer it.Release()
+	var addresses []string
+	for ok := it.First(); ok; {
+		rest := it.Key()[len(seriesPrefix):]
+		i := bytes.IndexByte(rest, 0)
+		if i < 0 {
+			ok = it.Next()
+			continue
+		}
+		a := string(rest[:i])
+		if a == address {
+			addresses = append(addresses, a)
+		} else if qerr == nil {
+			if code, err := obis.Parse(a); err == nil && q.Matches(code) {
+				addresses = append(addresses, a)
+			}
+		}
+		ok = it.Seek(util.BytesPrefix(seriesSpace(a)).Limit)
+	}
+	return addresses, it.Error()
+}
+
+// WalkSeries calls fn for the samples of the registers that match the
+// address, see model.SeriesWalker. The short form 1.7.0 matches 1-0:1.7.0.
+// The samples come from the stored measurements only, ranges that have
+// been rolled up have no samples.
+func (c *Cache) WalkSeries(address, meterID string, from, to time.Time, fn model.SampleFunc) error {
+	if c.db == nil {
+		return ErrClosed
+	}
+	if len(address) == 0 || (!from.IsZero() && !to.IsZero() && to.Before(from)) {
+		return ErrBadArguments
+	}
+	addresses, err := c.seriesAddresses(address)
+	if err != nil {
+		return err
+	}
+	for _, a := range addresses {
+		space := seriesSpace(a)
+		var prefixes [][]byte
+		if len(meterID) > 0 {
+			prefixes = [][]byte{meterPrefix(meterID)}
+		} else if prefixes, err = c.meterPrefixes(space); err != nil {
+			return err
+		}
+		for _, p := range prefixes {
+			if err := c.walkSeries(a, append(append([]byte{}, space...), p...), from, to, fn); err != nil {
+				if err == model.ErrStopWalk {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// walkSeries calls fn for the samples of the series with the prefix,
+// the space of the address and the meter prefix.
+func (c *Cache) walkSeries(address string, prefix []byte, from, to time.Time, fn model.SampleFunc) error {
+	it := c.db.NewIterator(meterRange(prefix, from, to), nil)
+	defer it.Release()
+	meterID := string(prefix[len(seriesSpace(address)) : len(prefix)-1])
+	for it.Next() {
+		k := it.Key()
+		if len(k) != len(prefix)+timeLen {
+			continue
+		}
+		v := it.Value()
+		i := bytes.IndexByte(v, 0)
+		if i < 0 {
+			continue
+		}
+		s := &model.Sample{
+			Time:    keyTime(k[len(prefix):]),
+			MeterID: meterID,
+			Address: address,
+			Unit:    string(v[:i]),
+			Value:   string(v[i+1:]),
+		}
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+	return it.Error()
+}
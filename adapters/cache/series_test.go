@@ -0,0 +1,189 @@
+package cache
+
+import (
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/model"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+func seriesSamples(t *testing.T, c *Cache, address, meterID string, from, to time.Time) []*model.Sample {
+	t.Helper()
+	var ss []*model.Sample
+	err := c.WalkSeries(address, meterID, from, to, func(s *model.Sample) error {
+		ss = append(ss, s)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	return ss
+}
+
+func TestSeries(t *testing.T) {
+	c, now := openRollups(t, nil)
+	from := now.Add(-24 * time.Hour)
+
+	// The short form in the measurements matches the full form.
+	ss := seriesSamples(t, c, "1-0:1.7.0", "", from, now)
+	if len(ss) != 96 {
+		t.Fatalf("expected %v, received %v", 96, len(ss))
+	}
+	for i, s := range ss {
+		expected := &model.Sample{
+			Time:    from.Add(time.Duration(i) * 15 * time.Minute),
+			MeterID: "ISK/meter1",
+			Address: "1.7.0",
+			Value:   strconv.Itoa(1 + 2*(i%2)),
+			Unit:    "kW",
+		}
+		if *s != *expected {
+			t.Fatalf("sample %d: expected %v, received %v", i, expected, s)
+		}
+	}
+	if ss := seriesSamples(t, c, "1.8.1", "ISK/meter1", from, from.Add(time.Hour)); len(ss) != 4 || ss[0].Value != "196" {
+		t.Errorf("expected %v, received %v", "4 samples from 196", ss)
+	}
+	if ss := seriesSamples(t, c, "1.7.0", "ISK/other", time.Time{}, time.Time{}); len(ss) != 0 {
+		t.Errorf("expected %v, received %v", 0, len(ss))
+	}
+	if ss := seriesSamples(t, c, "2.7.0", "", time.Time{}, time.Time{}); len(ss) != 0 {
+		t.Errorf("expected %v, received %v", 0, len(ss))
+	}
+	if err := c.WalkSeries("", "", time.Time{}, time.Time{}, nil); err != ErrBadArguments {
+		t.Errorf("expected %v, received %v", ErrBadArguments, err)
+	}
+
+	// A replaced measurement without the register and a deleted
+	// measurement have no samples.
+	m := &model.Measurement{
+		Time:           from,
+		ManufacturerID: "ISK",
+		Identification: "meter1",
+		Readings:       []model.DataSet{{Address: "1.8.1", Value: "500", Unit: "kWh"}},
+	}
+	if err := c.Put(m); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := c.Delete(&model.Measurement{Time: now.Add(-15 * time.Minute), ManufacturerID: "ISK", Identification: "meter1"}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if ss := seriesSamples(t, c, "1.7.0", "", from, now); len(ss) != 94 {
+		t.Errorf("expected %v, received %v", 94, len(ss))
+	}
+	if ss := seriesSamples(t, c, "1.8.1", "", from, from.Add(time.Minute)); len(ss) != 1 || ss[0].Value != "500" {
+		t.Errorf("expected %v, received %v", "500", ss)
+	}
+
+	n, err := c.RebuildSeries()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if n != 191 {
+		t.Errorf("expected %v, received %v", 191, n)
+	}
+	if ss := seriesSamples(t, c, "1.7.0", "", time.Time{}, time.Time{}); len(ss) != 190 {
+		t.Errorf("expected %v, received %v", 190, len(ss))
+	}
+}
+
+func TestSeriesCompact(t *testing.T) {
+	pp, _ := ParsePolicies("raw=1d,1h=forever")
+	c, now := openRollups(t, &Options{Policies: pp})
+	if _, _, err := c.Compact(now); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	ss := seriesSamples(t, c, "1.8.1", "", time.Time{}, time.Time{})
+	if len(ss) != 96 {
+		t.Fatalf("expected %v, received %v", 96, len(ss))
+	}
+	if first := now.Add(-24 * time.Hour); !ss[0].Time.Equal(first) {
+		t.Errorf("expected %v, received %v", first, ss[0].Time)
+	}
+}
+
+// TestSeriesConcurrentPut replaces a measurement from several goroutines,
+// only the samples of the stored measurement remain.
+func TestSeriesConcurrentPut(t *testing.T) {
+	c, err := Open(testDB(t))
+	if err != nil {
+		t.Fatalf("Error opening database: %s", err.Error())
+	}
+	defer c.Close()
+	t0 := time.Date(2020, 1, 2, 15, 0, 0, 0, time.UTC)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				c.Put(&model.Measurement{
+					Time:           t0,
+					ManufacturerID: "ISK",
+					Identification: "meter1",
+					Readings:       []model.DataSet{{Address: strconv.Itoa(1+g%2) + ".7.0", Value: strconv.Itoa(i), Unit: "kW"}},
+				})
+			}
+		}(g)
+	}
+	wg.Wait()
+	m, err := c.Get([]byte(model.Last))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	ss := append(seriesSamples(t, c, "1.7.0", "", time.Time{}, time.Time{}), seriesSamples(t, c, "2.7.0", "", time.Time{}, time.Time{})...)
+	if len(ss) != 1 || ss[0].Address != m.Readings[0].Address || ss[0].Value != m.Readings[0].Value {
+		t.Errorf("expected %v, received %v", m.Readings, ss)
+	}
+}
+
+// TestSeriesBuild opens a cache that was stored without series indexes.
+func TestSeriesBuild(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "db")
+	c, err := Open(filename)
+	if err != nil {
+		t.Fatalf("Error opening database: %s", err.Error())
+	}
+	for _, m := range getMultM {
+		if err := c.Put(m); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	b := new(leveldb.Batch)
+	it := c.db.NewIterator(util.BytesPrefix(seriesPrefix), nil)
+	for it.Next() {
+		b.Delete(append([]byte{}, it.Key()...))
+	}
+	it.Release()
+	b.Delete(seriesBuiltKey)
+	if err := c.db.Write(b, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if ss := seriesSamples(t, c, "1.2.3", "", time.Time{}, time.Time{}); len(ss) != 0 {
+		t.Fatalf("expected %v, received %v", 0, len(ss))
+	}
+	c.Close()
+
+	// Not built when skipped.
+	if c, err = OpenWithOptions(filename, &Options{NoSeriesBuild: true}); err != nil {
+		t.Fatalf("Error opening database: %s", err.Error())
+	}
+	if ss := seriesSamples(t, c, "1.2.3", "", time.Time{}, time.Time{}); len(ss) != 0 {
+		t.Fatalf("expected %v, received %v", 0, len(ss))
+	}
+	c.Close()
+
+	if c, err = Open(filename); err != nil {
+		t.Fatalf("Error opening database: %s", err.Error())
+	}
+	defer c.Close()
+	if ss := seriesSamples(t, c, "1.2.3", "", time.Time{}, time.Time{}); len(ss) != len(getMultM) {
+		t.Errorf("expected %v, received %v", len(getMultM), len(ss))
+	}
+}
@@ -0,0 +1,212 @@
+package cache
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// A snapshot archive is gzip compressed. It starts with snapshotMagic,
+// followed by the records, the key and the value each prefixed with the
+// uvarint length. A key of length 0 ends the records and is followed by the
+// uvarint number of records and the big endian CRC-32 (IEEE) of the records
+// and the end. The archive holds all keys, including the dictionary, the
+// outbox and the rollups, so it does not depend on the LevelDB files or
+// version.
+
+// snapshotMagic identifies version 1 of the archive format.
+const snapshotMagic = "IEC62056SNAP\x01"
+
+// maxSnapshotField limits the allocation for a key or value of a damaged
+// archive.
+const maxSnapshotField = 64 << 20
+
+var (
+	// ErrBadSnapshot is returned for an archive that is not a complete
+	// snapshot, e.g. a truncated download.
+	ErrBadSnapshot = errors.New("bad snapshot")
+	// ErrExists is returned when restoring into an existing database.
+	ErrExists = errors.New("database exists")
+)
+
+// Snapshot writes a consistent archive of the database to w, while the
+// cache stays in use. Returns the number of records written. The archive is
+// only complete when no error is returned; Restore rejects a partial one.
+func (c *Cache) Snapshot(w io.Writer) (int, error) {
+	if c.db == nil {
+		return 0, ErrClosed
+	}
+	snap, err := c.db.GetSnapshot()
+	if err != nil {
+		return 0, err
+	}
+	defer snap.Release()
+	it := snap.NewIterator(nil, nil)
+	defer it.Release()
+
+	zw := gzip.NewWriter(w)
+	bw := bufio.NewWriter(zw)
+	if _, err := bw.WriteString(snapshotMagic); err != nil {
+		return 0, err
+	}
+	crc := crc32.NewIEEE()
+	out := io.MultiWriter(bw, crc)
+	n := 0
+	var buf []byte
+	for it.Next() {
+		buf = binary.AppendUvarint(buf[:0], uint64(len(it.Key())))
+		buf = append(buf, it.Key()...)
+		buf = binary.AppendUvarint(buf, uint64(len(it.Value())))
+		buf = append(buf, it.Value()...)
+		if _, err := out.Write(buf); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := it.Error(); err != nil {
+		return n, err
+	}
+	if _, err := out.Write(binary.AppendUvarint(buf[:0], 0)); err != nil {
+		return n, err
+	}
+	buf = binary.AppendUvarint(buf[:0], uint64(n))
+	buf = binary.BigEndian.AppendUint32(buf, crc.Sum32())
+	if _, err := bw.Write(buf); err != nil {
+		return n, err
+	}
+	if err := bw.Flush(); err != nil {
+		return n, err
+	}
+	return n, zw.Close()
+}
+
+// Restore creates the database filename from the archive read from r and
+// returns the number of records. The database must not exist, an empty
+// directory is accepted. The records are written to a temporary directory
+// that is renamed when the archive is complete, so a failed restore leaves
+// nothing behind.
+func Restore(filename string, r io.Reader) (int, error) {
+	if entries, err := os.ReadDir(filename); err == nil {
+		if len(entries) > 0 {
+			return 0, ErrExists
+		}
+	} else if !os.IsNotExist(err) {
+		// E.g. a file.
+		return 0, fmt.Errorf("%w: %s", ErrExists, err.Error())
+	}
+	tmp := filename + ".restore"
+	if err := os.RemoveAll(tmp); err != nil {
+		return 0, err
+	}
+	n, err := restore(tmp, r)
+	if err != nil {
+		os.RemoveAll(tmp)
+		return 0, err
+	}
+	// Rename does not replace an empty directory on all systems.
+	os.Remove(filename)
+	if err := os.Rename(tmp, filename); err != nil {
+		os.RemoveAll(tmp)
+		return 0, err
+	}
+	return n, nil
+}
+
+func restore(dir string, r io.Reader) (int, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrBadSnapshot, err.Error())
+	}
+	br := bufio.NewReader(zr)
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != snapshotMagic {
+		return 0, ErrBadSnapshot
+	}
+	db, err := leveldb.OpenFile(dir, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	crc := crc32.NewIEEE()
+	in := &snapshotReader{r: br, crc: crc}
+	b := new(leveldb.Batch)
+	n := 0
+	for {
+		k := in.bytes()
+		if in.err != nil {
+			return n, in.err
+		}
+		if len(k) == 0 {
+			break
+		}
+		v := in.bytes()
+		if in.err != nil {
+			return n, in.err
+		}
+		b.Put(k, v)
+		n++
+		if b.Len() >= migrateBatch {
+			if err := db.Write(b, nil); err != nil {
+				return n, err
+			}
+			b.Reset()
+		}
+	}
+	sum := crc.Sum32()
+	count, err := binary.ReadUvarint(br)
+	if err != nil || count != uint64(n) {
+		return n, fmt.Errorf("%w: expected %d records, received %d", ErrBadSnapshot, count, n)
+	}
+	tail := make([]byte, 4)
+	if _, err := io.ReadFull(br, tail); err != nil || binary.BigEndian.Uint32(tail) != sum {
+		return n, fmt.Errorf("%w: checksum mismatch", ErrBadSnapshot)
+	}
+	// Reading to the end checks the gzip checksum.
+	if _, err := br.ReadByte(); err != io.EOF {
+		return n, fmt.Errorf("%w: no end after the records", ErrBadSnapshot)
+	}
+	if err := db.Write(b, nil); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// snapshotReader reads the length prefixed fields of the records, the
+// first error is kept.
+type snapshotReader struct {
+	r   *bufio.Reader
+	crc io.Writer
+	err error
+}
+
+// bytes returns the next field, empty for the end of the records.
+func (s *snapshotReader) bytes() []byte {
+	if s.err != nil {
+		return nil
+	}
+	l, err := binary.ReadUvarint(s.r)
+	if err != nil {
+		s.err = fmt.Errorf("%w: %s", ErrBadSnapshot, err.Error())
+		return nil
+	}
+	if l > maxSnapshotField {
+		s.err = fmt.Errorf("%w: field of %d bytes", ErrBadSnapshot, l)
+		return nil
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(s.r, b); err != nil {
+		s.err = fmt.Errorf("%w: %s", ErrBadSnapshot, err.Error())
+		return nil
+	}
+	s.crc.Write(binary.AppendUvarint(nil, l))
+	s.crc.Write(b)
+	return b
+}
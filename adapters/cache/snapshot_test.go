@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	dir := t.TempDir()
+	c, err := OpenWithOptions(filepath.Join(dir, "db"), &Options{Outbox: true})
+	if err != nil {
+		t.Fatalf("Error opening database: %s", err.Error())
+	}
+	defer c.Close()
+	t0 := time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		m := *encodingM
+		m.Time = t0.Add(time.Duration(i) * time.Minute)
+		if err := c.Put(&m); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	buf := &bytes.Buffer{}
+	n, err := c.Snapshot(buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	// Written after the snapshot, not in the archive.
+	if err := c.Put(&model.Measurement{Time: t0.Add(time.Hour), ManufacturerID: "ISK", Identification: "meter1"}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	restored := filepath.Join(dir, "restored")
+	if err := os.Mkdir(restored, 0755); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	rn, err := Restore(restored, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if rn != n {
+		t.Errorf("expected %v, received %v", n, rn)
+	}
+	r, err := OpenWithOptions(restored, &Options{Outbox: true})
+	if err != nil {
+		t.Fatalf("Error opening database: %s", err.Error())
+	}
+	defer r.Close()
+	all, err := r.GetAll()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(all) != 5 {
+		t.Fatalf("expected %v, received %v", 5, len(all))
+	}
+	if !sameReadings(all[0], encodingM, t0) {
+		t.Errorf("expected %v, received %v", encodingM, all[0])
+	}
+	if backlog, _ := r.Backlog(); backlog != 5 {
+		t.Errorf("expected %v, received %v", 5, backlog)
+	}
+
+	// Not over an existing database.
+	if _, err := Restore(restored, bytes.NewReader(buf.Bytes())); !errors.Is(err, ErrExists) {
+		t.Errorf("expected %v, received %v", ErrExists, err)
+	}
+}
+
+// sameReadings compares the readings of m with those of expected at t.
+func sameReadings(m, expected *model.Measurement, t time.Time) bool {
+	if !m.Time.Equal(t) || len(m.Readings) != len(expected.Readings) {
+		return false
+	}
+	for i := range m.Readings {
+		if m.Readings[i] != expected.Readings[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRestoreTruncated(t *testing.T) {
+	dir := t.TempDir()
+	c, err := Open(filepath.Join(dir, "db"))
+	if err != nil {
+		t.Fatalf("Error opening database: %s", err.Error())
+	}
+	defer c.Close()
+	if err := c.Put(encodingM); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	buf := &bytes.Buffer{}
+	if _, err := c.Snapshot(buf); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	for _, b := range [][]byte{buf.Bytes()[:buf.Len()-10], []byte("not a snapshot")} {
+		restored := filepath.Join(dir, "restored")
+		if _, err := Restore(restored, bytes.NewReader(b)); !errors.Is(err, ErrBadSnapshot) {
+			t.Errorf("expected %v, received %v", ErrBadSnapshot, err)
+		}
+		if _, err := os.Stat(restored); !os.IsNotExist(err) {
+			t.Errorf("expected %v, received %v", "no database", err)
+		}
+		if _, err := os.Stat(restored + ".restore"); !os.IsNotExist(err) {
+			t.Errorf("expected %v, received %v", "no temporary database", err)
+		}
+	}
+}
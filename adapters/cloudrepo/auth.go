@@ -0,0 +1,374 @@
+package cloudrepo
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers of a signed request.
+const (
+	HeaderTimestamp = "X-Timestamp"
+	HeaderNonce     = "X-Nonce"
+	HeaderSignature = "X-Signature"
+	HeaderKeyID     = "X-Key-Id"
+)
+
+// Environment variables with the credentials. A variable with the _FILE
+// suffix names a file with the value, e.g. CLOUDREPO_TOKEN_FILE.
+const (
+	EnvToken          = "CLOUDREPO_TOKEN"
+	EnvHMACKey        = "CLOUDREPO_HMAC_KEY"
+	EnvHMACKeyID      = "CLOUDREPO_HMAC_KEY_ID"
+	EnvClientCertFile = "CLOUDREPO_CLIENT_CERT_FILE"
+	EnvClientKeyFile  = "CLOUDREPO_CLIENT_KEY_FILE"
+	EnvCAFile         = "CLOUDREPO_CA_FILE"
+)
+
+var (
+	// ErrUnauthorized is returned for a request without valid credentials.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrBadSignature is returned for a request with a missing or wrong signature.
+	ErrBadSignature = errors.New("bad signature")
+	// ErrStaleRequest is returned for a timestamp too far from the current time.
+	ErrStaleRequest = errors.New("stale request")
+	// ErrReplay is returned for a nonce that has been used before.
+	ErrReplay = errors.New("replayed request")
+)
+
+// Credentials for the requests to the cloud service. All are optional.
+type Credentials struct {
+	// Token is sent as a bearer token.
+	Token string
+	// HMACKey signs the requests, KeyID tells the service which key was used.
+	HMACKey []byte
+	KeyID   string
+	// Certificate is the client certificate for mutual TLS.
+	Certificate *tls.Certificate
+	// RootCAs verify the server, the system pool if nil.
+	RootCAs *x509.CertPool
+}
+
+// CredentialsFromEnv reads the credentials from the environment variables.
+// Returns empty credentials if none are set.
+func CredentialsFromEnv() (*Credentials, error) {
+	return credentialsFromEnv(os.Getenv)
+}
+
+// envValue returns the value of the variable, or the content of the file
+// named by the variable with the _FILE suffix.
+func envValue(getenv func(string) string, name string) (string, error) {
+	if v := getenv(name); len(v) > 0 {
+		return v, nil
+	}
+	f := getenv(name + "_FILE")
+	if len(f) == 0 {
+		return "", nil
+	}
+	b, err := os.ReadFile(f)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func credentialsFromEnv(getenv func(string) string) (*Credentials, error) {
+	c := &Credentials{}
+	var err error
+	if c.Token, err = envValue(getenv, EnvToken); err != nil {
+		return nil, err
+	}
+	key, err := envValue(getenv, EnvHMACKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) > 0 {
+		c.HMACKey = []byte(key)
+	}
+	if c.KeyID, err = envValue(getenv, EnvHMACKeyID); err != nil {
+		return nil, err
+	}
+	certFile, keyFile := getenv(EnvClientCertFile), getenv(EnvClientKeyFile)
+	if len(certFile) > 0 {
+		if len(keyFile) == 0 {
+			keyFile = certFile
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificate = &cert
+	}
+	if ca := getenv(EnvCAFile); len(ca) > 0 {
+		if c.RootCAs, err = loadCertPool(ca); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// loadCertPool reads the PEM certificates from the file.
+func loadCertPool(filename string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, errors.New("no certificates found in " + filename)
+	}
+	return pool, nil
+}
+
+// HTTPClient returns a client that presents the client certificate.
+func (c *Credentials) HTTPClient() *http.Client {
+	if c == nil || (c.Certificate == nil && c.RootCAs == nil) {
+		return defaultClient
+	}
+	cfg := &tls.Config{RootCAs: c.RootCAs}
+	if c.Certificate != nil {
+		cfg.Certificates = []tls.Certificate{*c.Certificate}
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = cfg
+	return &http.Client{Timeout: defaultClient.Timeout, Transport: t}
+}
+
+// Get sends a signed GET request to a service that uses a Verifier, e.g. to
+// download a snapshot. The client has no timeout for long downloads, ctx
+// ends the request. A response other than 200 OK is a StatusError.
+func (c *Credentials) Get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.apply(req, nil, time.Now()); err != nil {
+		return nil, err
+	}
+	client := *c.HTTPClient()
+	client.Timeout = 0
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, &StatusError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Body:       strings.TrimSpace(string(b)),
+		}
+	}
+	return resp, nil
+}
+
+// Sign returns the signature of the request: the hex HMAC-SHA256 over the
+// method, path, canonical query, timestamp, nonce and body, separated by new
+// lines. The canonical query has the parameters sorted by name, see
+// url.Values.Encode, so the order of the parameters does not matter.
+func Sign(key []byte, method, path, query, timestamp, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, key)
+	io.WriteString(mac, method+"\n"+path+"\n"+canonicalQuery(query)+"\n"+timestamp+"\n"+nonce+"\n")
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// canonicalQuery returns the raw query with the parameters sorted, or the
+// raw query if it cannot be parsed.
+func canonicalQuery(raw string) string {
+	v, err := url.ParseQuery(raw)
+	if err != nil {
+		return raw
+	}
+	return v.Encode()
+}
+
+// apply adds the token and the signature to the request.
+func (c *Credentials) apply(req *http.Request, body []byte, now time.Time) error {
+	if c == nil {
+		return nil
+	}
+	if len(c.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	if len(c.HMACKey) == 0 {
+		return nil
+	}
+	n := make([]byte, 16)
+	if _, err := rand.Read(n); err != nil {
+		return err
+	}
+	nonce := hex.EncodeToString(n)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set(HeaderTimestamp, ts)
+	req.Header.Set(HeaderNonce, nonce)
+	if len(c.KeyID) > 0 {
+		req.Header.Set(HeaderKeyID, c.KeyID)
+	}
+	req.Header.Set(HeaderSignature, Sign(c.HMACKey, req.Method, req.URL.EscapedPath(), req.URL.RawQuery, ts, nonce, body))
+	return nil
+}
+
+// DefaultMaxSkew is the maximum difference between the timestamp of a
+// signed request and the clock of the service.
+const DefaultMaxSkew = 5 * time.Minute
+
+// DefaultMaxBody is the maximum size of the body of a signed request, the
+// body is read before the request is authenticated.
+const DefaultMaxBody = 16 << 20
+
+// Verifier checks the credentials of the requests at the service. The
+// checks for which the verifier has no credentials are skipped.
+type Verifier struct {
+	// Tokens that are accepted.
+	Tokens []string
+	// HMACKeys by key ID, requests without a key ID use the key with ID "".
+	HMACKeys map[string][]byte
+	// RequireClientCert rejects requests without a verified client
+	// certificate. The TLS config of the server verifies the certificate.
+	RequireClientCert bool
+	// MaxSkew of the timestamp, DefaultMaxSkew if 0.
+	MaxSkew time.Duration
+	// MaxBody is the maximum size of a signed body, DefaultMaxBody if 0.
+	MaxBody int64
+
+	lock   sync.Mutex
+	nonces map[string]time.Time
+}
+
+// NewVerifier returns a verifier that accepts the token and the HMAC key of
+// the credentials, so the service can use the same environment variables.
+func NewVerifier(c *Credentials) *Verifier {
+	v := &Verifier{}
+	if len(c.Token) > 0 {
+		v.Tokens = []string{c.Token}
+	}
+	if len(c.HMACKey) > 0 {
+		v.HMACKeys = map[string][]byte{c.KeyID: c.HMACKey}
+	}
+	return v
+}
+
+// Verify checks the request. The body is read and replaced, so the
+// handler can read it again. A body larger than MaxBody returns an
+// *http.MaxBytesError.
+func (v *Verifier) Verify(r *http.Request) error {
+	if v.RequireClientCert && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+		return ErrUnauthorized
+	}
+	if len(v.Tokens) > 0 && !v.validToken(r.Header.Get("Authorization")) {
+		return ErrUnauthorized
+	}
+	if len(v.HMACKeys) == 0 {
+		return nil
+	}
+	var body []byte
+	if r.Body != nil {
+		var err error
+		max := v.MaxBody
+		if max <= 0 {
+			max = DefaultMaxBody
+		}
+		if body, err = io.ReadAll(http.MaxBytesReader(nil, r.Body, max)); err != nil {
+			return err
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return v.verifySignature(r, body, time.Now())
+}
+
+func (v *Verifier) validToken(auth string) bool {
+	token := strings.TrimPrefix(auth, "Bearer ")
+	if token == auth {
+		return false
+	}
+	for _, t := range v.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *Verifier) verifySignature(r *http.Request, body []byte, now time.Time) error {
+	key, ok := v.HMACKeys[r.Header.Get(HeaderKeyID)]
+	if !ok {
+		return ErrUnauthorized
+	}
+	ts, nonce, sig := r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderNonce), r.Header.Get(HeaderSignature)
+	if len(ts) == 0 || len(nonce) == 0 || len(sig) == 0 {
+		return ErrBadSignature
+	}
+	expected := Sign(key, r.Method, r.URL.EscapedPath(), r.URL.RawQuery, ts, nonce, body)
+	if !hmac.Equal([]byte(expected), []byte(sig)) {
+		return ErrBadSignature
+	}
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+	skew := v.MaxSkew
+	if skew <= 0 {
+		skew = DefaultMaxSkew
+	}
+	t := time.Unix(sec, 0)
+	if t.Before(now.Add(-skew)) || t.After(now.Add(skew)) {
+		return ErrStaleRequest
+	}
+	return v.useNonce(nonce, now, skew)
+}
+
+// useNonce records the nonce, nonces older than twice the skew are
+// forgotten as their timestamp is rejected anyway.
+func (v *Verifier) useNonce(nonce string, now time.Time, skew time.Duration) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if v.nonces == nil {
+		v.nonces = map[string]time.Time{}
+	}
+	for n, t := range v.nonces {
+		if now.Sub(t) > 2*skew {
+			delete(v.nonces, n)
+		}
+	}
+	if _, ok := v.nonces[nonce]; ok {
+		return ErrReplay
+	}
+	v.nonces[nonce] = now
+	return nil
+}
+
+// Handler returns a handler that verifies the requests before h handles
+// them. Rejected requests receive 401 Unauthorized, a body larger than
+// MaxBody 413 Request Entity Too Large.
+func (v *Verifier) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := v.Verify(r); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
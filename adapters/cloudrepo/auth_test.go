@@ -0,0 +1,167 @@
+package cloudrepo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignedPut(t *testing.T) {
+	s := &testServer{}
+	creds := &Credentials{Token: "secret", HMACKey: []byte("key"), KeyID: "k1"}
+	v := NewVerifier(creds)
+	ts := httptest.NewServer(v.Handler(s))
+	t.Cleanup(ts.Close)
+	c, err := New(ts.URL + "/measurements")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	c.Credentials = creds
+	if err := c.Put(testMeasurement); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(s.stored) != 1 {
+		t.Errorf("expected %v, received %v", 1, len(s.stored))
+	}
+
+	// Wrong key.
+	c.Credentials = &Credentials{Token: "secret", HMACKey: []byte("other"), KeyID: "k1"}
+	if err := c.Put(testMeasurement); !errors.Is(err, ErrRejected) {
+		t.Errorf("expected %v, received %v", ErrRejected, err)
+	}
+	// No token.
+	c.Credentials = &Credentials{HMACKey: []byte("key"), KeyID: "k1"}
+	if err := c.Put(testMeasurement); !errors.Is(err, ErrRejected) {
+		t.Errorf("expected %v, received %v", ErrRejected, err)
+	}
+}
+
+func TestSignedGet(t *testing.T) {
+	creds := &Credentials{Token: "secret", HMACKey: []byte("key")}
+	ts := httptest.NewServer(NewVerifier(creds).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("snapshot"))
+	})))
+	t.Cleanup(ts.Close)
+	resp, err := creds.Get(context.Background(), ts.URL+"/snapshot")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "snapshot" {
+		t.Errorf("expected %v, received %v", "snapshot", string(b))
+	}
+	wrong := &Credentials{Token: "secret", HMACKey: []byte("other")}
+	var se *StatusError
+	if _, err := wrong.Get(context.Background(), ts.URL+"/snapshot"); !errors.As(err, &se) || se.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected %v, received %v", http.StatusUnauthorized, err)
+	}
+}
+
+func signedRequest(t *testing.T, key []byte, ts time.Time, nonce string, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/measurements", nil)
+	c := &Credentials{HMACKey: key}
+	if err := c.apply(r, []byte(body), ts); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(nonce) > 0 {
+		r.Header.Set(HeaderNonce, nonce)
+		tss := strconv.FormatInt(ts.Unix(), 10)
+		r.Header.Set(HeaderSignature, Sign(key, r.Method, "/measurements", "", tss, nonce, []byte(body)))
+	}
+	return r
+}
+
+func TestVerifySignature(t *testing.T) {
+	key := []byte("key")
+	now := time.Now()
+	v := &Verifier{HMACKeys: map[string][]byte{"": key}}
+
+	if err := v.verifySignature(signedRequest(t, key, now, "n1", "{}"), []byte("{}"), now); err != nil {
+		t.Errorf("expected %v, received %v", nil, err)
+	}
+	if err := v.verifySignature(signedRequest(t, key, now, "n1", "{}"), []byte("{}"), now); err != ErrReplay {
+		t.Errorf("expected %v, received %v", ErrReplay, err)
+	}
+	if err := v.verifySignature(signedRequest(t, key, now, "n2", "{}"), []byte("[]"), now); err != ErrBadSignature {
+		t.Errorf("expected %v, received %v", ErrBadSignature, err)
+	}
+	old := now.Add(-time.Hour)
+	if err := v.verifySignature(signedRequest(t, key, old, "n3", "{}"), []byte("{}"), now); err != ErrStaleRequest {
+		t.Errorf("expected %v, received %v", ErrStaleRequest, err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/measurements", nil)
+	if err := v.verifySignature(r, nil, now); err != ErrBadSignature {
+		t.Errorf("expected %v, received %v", ErrBadSignature, err)
+	}
+}
+
+// TestVerifyQuery rejects a signed request with a changed query and a body
+// that is too large.
+func TestVerifyQuery(t *testing.T) {
+	key := []byte("key")
+	v := &Verifier{HMACKeys: map[string][]byte{"": key}, MaxBody: 8}
+	c := &Credentials{HMACKey: key}
+	r := httptest.NewRequest(http.MethodDelete, "/measurements?meter=ISK%2F1&time=2020-01-02T15:04:05Z", nil)
+	if err := c.apply(r, nil, time.Now()); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	r.URL.RawQuery = "time=2020-01-02T15:04:05Z&meter=ISK%2F1"
+	if err := v.Verify(r); err != nil {
+		t.Errorf("expected %v, received %v", nil, err)
+	}
+	// Signed again with a new nonce.
+	if err := c.apply(r, nil, time.Now()); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	r.URL.RawQuery = "meter=ISK%2F2&time=2020-01-02T15:04:05Z"
+	if err := v.Verify(r); err != ErrBadSignature {
+		t.Errorf("expected %v, received %v", ErrBadSignature, err)
+	}
+
+	body := "[{}, {}, {}]"
+	r = httptest.NewRequest(http.MethodPost, "/measurements", strings.NewReader(body))
+	if err := c.apply(r, []byte(body), time.Now()); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	w := httptest.NewRecorder()
+	v.Handler(http.NotFoundHandler()).ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected %v, received %v", http.StatusRequestEntityTooLarge, w.Code)
+	}
+}
+
+func TestCredentialsFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	if err := os.WriteFile(tokenFile, []byte("file-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		EnvToken + "_FILE": tokenFile,
+		EnvHMACKey:         "key",
+	}
+	c, err := credentialsFromEnv(func(k string) string { return env[k] })
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if c.Token != "file-token" {
+		t.Errorf("expected %v, received %v", "file-token", c.Token)
+	}
+	if string(c.HMACKey) != "key" {
+		t.Errorf("expected %v, received %v", "key", string(c.HMACKey))
+	}
+
+	env[EnvClientCertFile] = filepath.Join(dir, "missing.pem")
+	if _, err := credentialsFromEnv(func(k string) string { return env[k] }); err == nil {
+		t.Errorf("expected an error for a missing certificate")
+	}
+}
@@ -1,10 +1,26 @@
 // Package cloudrepo implements a repo that stores measurements at
 // a remote service.
+//
+// The service accepts a Measurement resource or an array of them in a POST
+// to the end point. GET on the end point returns a MeasurementsResource,
+// paginated with the page and size parameters, or the measurements of a
+// time range with the meter, from and to parameters, up to size
+// measurements. GET on first and last below
+// the end point returns a single measurement. DELETE on the end point with
+// the meter and time parameters removes a measurement.
 package cloudrepo
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/peterzandbergen/iec62056/model"
@@ -35,21 +51,401 @@ type DataSet struct {
 	Unit    string `json:"unit"`
 }
 
+// MeasurementsResource is the response of a GET on the end point.
+type MeasurementsResource struct {
+	Data []*Measurement `json:"data"`
+}
+
+// DecodeMeasurements decodes the body of a POST, a single measurement or
+// an array of them.
+func DecodeMeasurements(b []byte) ([]*Measurement, error) {
+	b = bytes.TrimSpace(b)
+	var mm []*Measurement
+	if len(b) > 0 && b[0] == '[' {
+		if err := json.Unmarshal(b, &mm); err != nil {
+			return nil, err
+		}
+	} else {
+		m := &Measurement{}
+		if err := json.Unmarshal(b, m); err != nil {
+			return nil, err
+		}
+		mm = append(mm, m)
+	}
+	for _, m := range mm {
+		if m == nil || m.JSONTime.IsZero() || len(m.ManufacturerID) == 0 || len(m.Identification) == 0 {
+			return nil, ErrBadMeasurement
+		}
+	}
+	return mm, nil
+}
+
+// NewMeasurement converts the measurement to the resource. The time is sent in UTC.
+func NewMeasurement(m *model.Measurement) *Measurement {
+	r := &Measurement{
+		JSONTime:       m.Time.UTC(),
+		ManufacturerID: m.ManufacturerID,
+		Identification: m.Identification,
+		Readings:       make([]DataSet, 0, len(m.Readings)),
+	}
+	for _, ds := range m.Readings {
+		r.Readings = append(r.Readings, DataSet(ds))
+	}
+	return r
+}
+
+// Model converts the resource to a measurement.
+func (r *Measurement) Model() *model.Measurement {
+	m := &model.Measurement{
+		Time:           r.JSONTime,
+		ManufacturerID: r.ManufacturerID,
+		Identification: r.Identification,
+	}
+	for _, ds := range r.Readings {
+		m.Readings = append(m.Readings, model.DataSet(ds))
+	}
+	return m
+}
+
+var (
+	// ErrRejected is returned when the service rejects the request with a 4xx
+	// status. The request is not retried.
+	ErrRejected = errors.New("request rejected by the cloud service")
+	// ErrServer is returned when the service fails with a 5xx status after all retries.
+	ErrServer = fmt.Errorf("cloud service error: %w", model.ErrUnavailable)
+	// ErrUnreachable is returned when the service cannot be reached after all retries.
+	ErrUnreachable = fmt.Errorf("cloud service unreachable: %w", model.ErrUnavailable)
+	// ErrNoElements is returned when a page or first or last has no measurements.
+	ErrNoElements = model.ErrNoElements
+	// ErrBadKey is returned by Get for a key other than model.First and model.Last.
+	ErrBadKey = errors.New("only first and last can be retrieved")
+	// ErrBadMeasurement is returned by DecodeMeasurements for a measurement
+	// without time, manufacturer or identification.
+	ErrBadMeasurement = errors.New("measurement without time, manufacturer or identification")
+)
+
+// StatusError is returned for a response with an error status.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	// Body of the response, truncated.
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	if len(e.Body) > 0 {
+		return fmt.Sprintf("%s: %s", e.Status, e.Body)
+	}
+	return e.Status
+}
+
+// Unwrap returns ErrRejected or ErrServer.
+func (e *StatusError) Unwrap() error {
+	if e.Temporary() {
+		return ErrServer
+	}
+	return ErrRejected
+}
+
+// Temporary returns true if the request can be retried.
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusRequestTimeout
+}
+
+// Defaults for the retries and the batches.
+const (
+	DefaultMaxRetries = 3
+	DefaultMinBackoff = time.Second
+	DefaultMaxBackoff = 30 * time.Second
+	DefaultBatchSize  = 100
+)
+
 // CloudRepo implements a repo somewhere on the internet.
 type CloudRepo struct {
 	EndPoint *url.URL
-	// TODO: Add credentials.
+	// Client for the requests, a client with a 30 second timeout if nil.
+	// For mutual TLS use the client of Credentials.HTTPClient.
+	Client *http.Client
+	// MaxRetries of a failed request, DefaultMaxRetries if 0, no retries if negative.
+	MaxRetries int
+	// MinBackoff and MaxBackoff limit the wait between retries.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// BatchSize is the maximum number of measurements per request in PutBatch.
+	BatchSize int
+	// Credentials authenticate and sign the requests, if not nil.
+	Credentials *Credentials
+
+	// sleep is replaced in tests.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+var _ model.MeasurementRepo = &CloudRepo{}
+
+var defaultClient = &http.Client{Timeout: 30 * time.Second}
+
+// New creates a repo for the end point URL.
+func New(endPoint string) (*CloudRepo, error) {
+	u, err := url.Parse(endPoint)
+	if err != nil {
+		return nil, err
+	}
+	return &CloudRepo{EndPoint: u}, nil
 }
 
-func (c *CloudRepo) Put(*model.Measurement) error {
+// Put sends the measurement to the service.
+func (c *CloudRepo) Put(m *model.Measurement) error {
+	return c.post(context.Background(), NewMeasurement(m))
+}
+
+// PutBatch sends the measurements in batches of BatchSize. It stops at the
+// first batch that fails, the earlier batches have been stored.
+func (c *CloudRepo) PutBatch(ctx context.Context, mm []*model.Measurement) error {
+	size := c.BatchSize
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	for len(mm) > 0 {
+		n := size
+		if n > len(mm) {
+			n = len(mm)
+		}
+		batch := make([]*Measurement, 0, n)
+		for _, m := range mm[:n] {
+			batch = append(batch, NewMeasurement(m))
+		}
+		if err := c.post(ctx, batch); err != nil {
+			return err
+		}
+		mm = mm[n:]
+	}
 	return nil
 }
+
+// Get returns the first or the last measurement, with model.First or model.Last as the key.
 func (c *CloudRepo) Get(key []byte) (*model.Measurement, error) {
-	return nil, nil
+	var p string
+	switch string(key) {
+	case model.First:
+		p = "first"
+	case model.Last:
+		p = "last"
+	default:
+		return nil, ErrBadKey
+	}
+	u := *c.EndPoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + p
+	var r struct {
+		Data *Measurement `json:"data"`
+	}
+	if err := c.get(context.Background(), &u, &r); err != nil {
+		return nil, err
+	}
+	if r.Data == nil {
+		return nil, ErrNoElements
+	}
+	return r.Data.Model(), nil
+}
+
+// GetPage returns pagesize measurements from the page, page starts at 0.
+func (c *CloudRepo) GetPage(page, pagesize int) ([]*model.Measurement, error) {
+	q := url.Values{}
+	q.Set("page", strconv.Itoa(page))
+	q.Set("size", strconv.Itoa(pagesize))
+	mm, err := c.getMeasurements(q)
+	if err != nil {
+		return nil, err
+	}
+	if len(mm) == 0 {
+		return nil, ErrNoElements
+	}
+	return mm, nil
+}
+
+// GetRange returns up to limit measurements of the meter in the time range,
+// see model.MeasurementRepo.
+func (c *CloudRepo) GetRange(meterID string, from, to time.Time, limit int) ([]*model.Measurement, error) {
+	q := url.Values{}
+	if len(meterID) > 0 {
+		q.Set("meter", meterID)
+	}
+	if !from.IsZero() {
+		q.Set("from", from.UTC().Format(time.RFC3339Nano))
+	}
+	if !to.IsZero() {
+		q.Set("to", to.UTC().Format(time.RFC3339Nano))
+	}
+	if limit > 0 {
+		q.Set("size", strconv.Itoa(limit))
+	}
+	return c.getMeasurements(q)
+}
+
+// GetAll returns all measurements.
+func (c *CloudRepo) GetAll() ([]*model.Measurement, error) {
+	return c.getMeasurements(nil)
 }
-func (c *CloudRepo) GetN(n int) ([]*model.Measurement, error) {
-	return nil, nil
+
+func (c *CloudRepo) getMeasurements(q url.Values) ([]*model.Measurement, error) {
+	u := *c.EndPoint
+	if q != nil {
+		u.RawQuery = q.Encode()
+	}
+	var r MeasurementsResource
+	if err := c.get(context.Background(), &u, &r); err != nil {
+		return nil, err
+	}
+	mm := make([]*model.Measurement, 0, len(r.Data))
+	for _, m := range r.Data {
+		mm = append(mm, m.Model())
+	}
+	return mm, nil
+}
+
+// Delete removes the measurement of the meter at the time of m.
+func (c *CloudRepo) Delete(m *model.Measurement) error {
+	u := *c.EndPoint
+	q := url.Values{}
+	q.Set("meter", m.ManufacturerID+"/"+m.Identification)
+	q.Set("time", m.Time.UTC().Format(time.RFC3339Nano))
+	u.RawQuery = q.Encode()
+	resp, err := c.do(context.Background(), http.MethodDelete, &u, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
 }
-func (c *CloudRepo) Delete(*model.Measurement) error {
+
+func (c *CloudRepo) post(ctx context.Context, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, http.MethodPost, c.EndPoint, b)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
 	return nil
 }
+
+func (c *CloudRepo) get(ctx context.Context, u *url.URL, v interface{}) error {
+	resp, err := c.do(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// do performs the request and retries it with backoff after a network
+// error or a temporary error status. A response with an error status is
+// returned as a *StatusError.
+func (c *CloudRepo) do(ctx context.Context, method string, u *url.URL, body []byte) (*http.Response, error) {
+	retries := c.MaxRetries
+	if retries == 0 {
+		retries = DefaultMaxRetries
+	}
+	var err error
+	for attempt := 0; ; attempt++ {
+		var resp *http.Response
+		resp, err = c.try(ctx, method, u, body)
+		if err == nil {
+			return resp, nil
+		}
+		var se *StatusError
+		if errors.As(err, &se) && !se.Temporary() {
+			return nil, err
+		}
+		if attempt >= retries || ctx.Err() != nil {
+			return nil, err
+		}
+		wait := c.backoff(attempt)
+		if resp != nil {
+			if s, perr := strconv.Atoi(resp.Header.Get("Retry-After")); perr == nil && s > 0 {
+				wait = time.Duration(s) * time.Second
+			}
+		}
+		if serr := c.wait(ctx, wait); serr != nil {
+			return nil, err
+		}
+	}
+}
+
+// try performs the request once. With an error status the response is also
+// returned, for the headers.
+func (c *CloudRepo) try(ctx context.Context, method string, u *url.URL, body []byte) (*http.Response, error) {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), rd)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if err := c.Credentials.apply(req, body, time.Now()); err != nil {
+		return nil, err
+	}
+	client := c.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %s", ErrUnreachable, err.Error())
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	resp.Body.Close()
+	return resp, &StatusError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Body:       strings.TrimSpace(string(b)),
+	}
+}
+
+// backoff returns the wait before the retry after attempt, doubling from MinBackoff.
+func (c *CloudRepo) backoff(attempt int) time.Duration {
+	min, max := c.MinBackoff, c.MaxBackoff
+	if min <= 0 {
+		min = DefaultMinBackoff
+	}
+	if max <= 0 {
+		max = DefaultMaxBackoff
+	}
+	if max < min {
+		max = min
+	}
+	d := min
+	for i := 0; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+func (c *CloudRepo) wait(ctx context.Context, d time.Duration) error {
+	if c.sleep != nil {
+		return c.sleep(ctx, d)
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,167 @@
+package cloudrepo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+// testServer stores the posted measurements and fails the first requests
+// with the status codes in fail.
+type testServer struct {
+	lock     sync.Mutex
+	fail     []int
+	requests int
+	stored   []*Measurement
+}
+
+func (s *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests++
+	if len(s.fail) > 0 {
+		code := s.fail[0]
+		s.fail = s.fail[1:]
+		http.Error(w, "failed", code)
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		var mm []*Measurement
+		dec := json.NewDecoder(r.Body)
+		if r.ContentLength > 0 {
+			var raw json.RawMessage
+			dec.Decode(&raw)
+			if len(raw) > 0 && raw[0] == '[' {
+				json.Unmarshal(raw, &mm)
+			} else {
+				m := &Measurement{}
+				json.Unmarshal(raw, m)
+				mm = append(mm, m)
+			}
+		}
+		s.stored = append(s.stored, mm...)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(&MeasurementsResource{Data: s.stored})
+	}
+}
+
+func newTestRepo(t *testing.T, s *testServer) *CloudRepo {
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	c, err := New(ts.URL + "/measurements")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	c.sleep = func(ctx context.Context, d time.Duration) error { return nil }
+	return c
+}
+
+var testMeasurement = &model.Measurement{
+	Time:           time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC),
+	ManufacturerID: "ISK",
+	Identification: "meter1",
+	Readings:       []model.DataSet{{Address: "1.8.1", Value: "000051.394", Unit: "kWh"}},
+}
+
+func TestPut(t *testing.T) {
+	s := &testServer{}
+	c := newTestRepo(t, s)
+	if err := c.Put(testMeasurement); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	mm, err := c.GetAll()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(mm) != 1 || !mm[0].Time.Equal(testMeasurement.Time) || mm[0].Readings[0] != testMeasurement.Readings[0] {
+		t.Errorf("unexpected measurements: %+v", mm)
+	}
+}
+
+func TestPutRetry(t *testing.T) {
+	s := &testServer{fail: []int{http.StatusServiceUnavailable, http.StatusBadGateway}}
+	c := newTestRepo(t, s)
+	if err := c.Put(testMeasurement); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if s.requests != 3 {
+		t.Errorf("expected %v, received %v", 3, s.requests)
+	}
+}
+
+func TestPutServerError(t *testing.T) {
+	s := &testServer{fail: []int{500, 500, 500, 500, 500}}
+	c := newTestRepo(t, s)
+	err := c.Put(testMeasurement)
+	if !errors.Is(err, ErrServer) || !errors.Is(err, model.ErrUnavailable) {
+		t.Errorf("expected %v, received %v", ErrServer, err)
+	}
+	if s.requests != DefaultMaxRetries+1 {
+		t.Errorf("expected %v, received %v", DefaultMaxRetries+1, s.requests)
+	}
+}
+
+func TestPutRejected(t *testing.T) {
+	s := &testServer{fail: []int{http.StatusBadRequest}}
+	c := newTestRepo(t, s)
+	err := c.Put(testMeasurement)
+	var se *StatusError
+	if !errors.Is(err, ErrRejected) || !errors.As(err, &se) || se.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected %v, received %v", ErrRejected, err)
+	}
+	if s.requests != 1 {
+		t.Errorf("expected %v, received %v", 1, s.requests)
+	}
+}
+
+func TestPutUnreachable(t *testing.T) {
+	c, _ := New("http://127.0.0.1:1/measurements")
+	c.MaxRetries = -1
+	if err := c.Put(testMeasurement); !errors.Is(err, ErrUnreachable) {
+		t.Errorf("expected %v, received %v", ErrUnreachable, err)
+	}
+}
+
+func TestPutBatch(t *testing.T) {
+	s := &testServer{}
+	c := newTestRepo(t, s)
+	c.BatchSize = 2
+	mm := []*model.Measurement{testMeasurement, testMeasurement, testMeasurement}
+	if err := c.PutBatch(context.Background(), mm); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if s.requests != 2 || len(s.stored) != 3 {
+		t.Errorf("expected 2 requests and 3 measurements, received %v and %v", s.requests, len(s.stored))
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	c := &CloudRepo{MinBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	for attempt, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second} {
+		if d := c.backoff(attempt); d != expected {
+			t.Errorf("expected %v, received %v", expected, d)
+		}
+	}
+}
+
+func TestDecodeMeasurements(t *testing.T) {
+	mm, err := DecodeMeasurements([]byte(`{"time":"2020-01-02T15:04:05Z","manufacturerID":"ISK","identification":"meter1"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(mm) != 1 {
+		t.Errorf("expected %v, received %v", 1, len(mm))
+	}
+	if _, err := DecodeMeasurements([]byte(`[{"manufacturerID":"ISK"}]`)); err != ErrBadMeasurement {
+		t.Errorf("expected %v, received %v", ErrBadMeasurement, err)
+	}
+}
@@ -0,0 +1,176 @@
+// Package memory implements a MeasurementRepo in memory, for tests and for
+// loggers that do not need to keep the measurements.
+package memory
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+var (
+	_ model.MeasurementRepo = &Repo{}
+	_ model.Walker          = &Repo{}
+)
+
+var (
+	ErrBadArguments = errors.New("bad argument(s)")
+	ErrNoElements   = model.ErrNoElements
+)
+
+// Repo stores the measurements in memory, in time order and measurements
+// with the same time in the order of their meter ID. A measurement with
+// the time and meter of a stored one replaces it. The repo stores and
+// returns copies, so the callers can change their measurements. Get only
+// supports model.First and model.Last. The zero value is an empty repo.
+type Repo struct {
+	lock sync.RWMutex
+	ms   []*model.Measurement
+}
+
+// New returns an empty repo.
+func New() *Repo {
+	return &Repo{}
+}
+
+func clone(m *model.Measurement) *model.Measurement {
+	c := *m
+	c.Readings = append([]model.DataSet(nil), m.Readings...)
+	return &c
+}
+
+// less orders the measurements by time and meter ID.
+func less(a *model.Measurement, t time.Time, meterID string) bool {
+	if !a.Time.Equal(t) {
+		return a.Time.Before(t)
+	}
+	return a.MeterID() < meterID
+}
+
+// search returns the index of the first measurement at or after the time
+// and meter ID.
+func (r *Repo) search(t time.Time, meterID string) int {
+	return sort.Search(len(r.ms), func(i int) bool { return !less(r.ms[i], t, meterID) })
+}
+
+func (r *Repo) Put(m *model.Measurement) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	i := r.search(m.Time, m.MeterID())
+	if i < len(r.ms) && r.ms[i].Time.Equal(m.Time) && r.ms[i].MeterID() == m.MeterID() {
+		r.ms[i] = clone(m)
+		return nil
+	}
+	r.ms = append(r.ms, nil)
+	copy(r.ms[i+1:], r.ms[i:])
+	r.ms[i] = clone(m)
+	return nil
+}
+
+func (r *Repo) Delete(m *model.Measurement) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	i := r.search(m.Time, m.MeterID())
+	if i < len(r.ms) && r.ms[i].Time.Equal(m.Time) && r.ms[i].MeterID() == m.MeterID() {
+		r.ms = append(r.ms[:i], r.ms[i+1:]...)
+	}
+	return nil
+}
+
+// Get returns the first or the last measurement for model.First or
+// model.Last, other keys are ErrBadArguments.
+func (r *Repo) Get(key []byte) (*model.Measurement, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	var i int
+	switch string(key) {
+	case model.First:
+		i = 0
+	case model.Last:
+		i = len(r.ms) - 1
+	default:
+		return nil, ErrBadArguments
+	}
+	if len(r.ms) == 0 {
+		return nil, ErrNoElements
+	}
+	return clone(r.ms[i]), nil
+}
+
+// GetPage returns pagesize items from the given page. Page starts at 0.
+func (r *Repo) GetPage(page, pagesize int) ([]*model.Measurement, error) {
+	if page < 0 || pagesize < 0 {
+		return nil, ErrBadArguments
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	start := page * pagesize
+	if start >= len(r.ms) || pagesize == 0 {
+		return nil, ErrNoElements
+	}
+	end := start + pagesize
+	if end > len(r.ms) {
+		end = len(r.ms)
+	}
+	ms := make([]*model.Measurement, 0, end-start)
+	for _, m := range r.ms[start:end] {
+		ms = append(ms, clone(m))
+	}
+	return ms, nil
+}
+
+// GetAll returns all measurements in time order.
+func (r *Repo) GetAll() ([]*model.Measurement, error) {
+	return r.GetRange("", time.Time{}, time.Time{}, 0)
+}
+
+// GetRange returns up to limit measurements of the meter in the time range,
+// see model.MeasurementRepo.
+func (r *Repo) GetRange(meterID string, from, to time.Time, limit int) ([]*model.Measurement, error) {
+	ms := make([]*model.Measurement, 0)
+	err := r.Walk(meterID, from, to, func(m *model.Measurement) error {
+		ms = append(ms, m)
+		if limit > 0 && len(ms) >= limit {
+			return model.ErrStopWalk
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+// Walk calls fn for the measurements of the meter in the time range, see
+// model.Walker. The repo is locked for reading during the walk, fn must not
+// change it.
+func (r *Repo) Walk(meterID string, from, to time.Time, fn model.WalkFunc) error {
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		return ErrBadArguments
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	i := 0
+	if !from.IsZero() {
+		i = r.search(from, "")
+	}
+	for ; i < len(r.ms); i++ {
+		m := r.ms[i]
+		if !to.IsZero() && !m.Time.Before(to) {
+			break
+		}
+		if len(meterID) > 0 && m.MeterID() != meterID {
+			continue
+		}
+		if err := fn(clone(m)); err != nil {
+			if err == model.ErrStopWalk {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/peterzandbergen/iec62056/model"
+	"github.com/peterzandbergen/iec62056/model/repotest"
+)
+
+func TestConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) model.MeasurementRepo {
+		return New()
+	})
+}
+
+func TestCopies(t *testing.T) {
+	r := New()
+	m := repotest.Measurement("a", 0)
+	if err := r.Put(m); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	m.Readings[0].Value = "changed"
+	first, err := r.Get([]byte(model.First))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if first.Readings[0].Value == "changed" {
+		t.Errorf("expected a copy, received the stored measurement")
+	}
+	first.Readings[0].Value = "changed"
+	last, err := r.Get([]byte(model.Last))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if last.Readings[0].Value == "changed" {
+		t.Errorf("expected a copy, received the stored measurement")
+	}
+}
+
+func TestBadKey(t *testing.T) {
+	if _, err := New().Get([]byte("key")); err != ErrBadArguments {
+		t.Errorf("expected %v, received %v", ErrBadArguments, err)
+	}
+}
@@ -0,0 +1,183 @@
+package meter
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/iec"
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+// ConnectionState of the serial port of a meter.
+type ConnectionState int
+
+const (
+	// StateUnknown until the first read.
+	StateUnknown ConnectionState = iota
+	// StateConnected after a successful open of the port.
+	StateConnected
+	// StateDisconnected when the port is absent or cannot be opened.
+	StateDisconnected
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateDisconnected:
+		return "disconnected"
+	}
+	return "unknown"
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s ConnectionState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+var (
+	// ErrPortUnavailable is returned while the port is absent and the meter backs off.
+	ErrPortUnavailable = fmt.Errorf("serial port unavailable: %w", model.ErrUnavailable)
+)
+
+const (
+	// DefaultMinBackoff is the first wait after the port disappeared.
+	DefaultMinBackoff = 5 * time.Second
+	// DefaultMaxBackoff limits the wait between attempts to open an absent port.
+	DefaultMaxBackoff = 5 * time.Minute
+)
+
+// Status reports the connection state of the meter.
+type Status struct {
+	State ConnectionState
+	// Device node used for the last successful read.
+	Device string `json:",omitempty"`
+	// Since is the time of the last state change.
+	Since time.Time
+	// LastError while the port is disconnected.
+	LastError string `json:",omitempty"`
+	// NextAttempt to open the port while disconnected.
+	NextAttempt time.Time `json:",omitempty"`
+}
+
+// health tracks the connection state and the back off while the port is absent.
+type health struct {
+	lock        sync.Mutex
+	state       ConnectionState
+	device      string
+	since       time.Time
+	lastErr     error
+	failures    int
+	nextAttempt time.Time
+	min, max    time.Duration
+}
+
+// available returns false while backing off.
+func (h *health) available(now time.Time) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.state != StateDisconnected || !now.Before(h.nextAttempt)
+}
+
+// setBackoff sets the limits of the back off, zero for the defaults.
+func (h *health) setBackoff(min, max time.Duration) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.min, h.max = min, max
+}
+
+// connected records a successful open of device.
+func (h *health) connected(now time.Time, device string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.state != StateConnected || h.device != device {
+		log.Printf("meter connected on %s", device)
+		h.since = now
+	}
+	h.state = StateConnected
+	h.device = device
+	h.lastErr = nil
+	h.failures = 0
+	h.nextAttempt = time.Time{}
+}
+
+// disconnected records that the port is absent and doubles the back off.
+func (h *health) disconnected(now time.Time, err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.state != StateDisconnected {
+		log.Printf("meter disconnected: %s", err.Error())
+		h.since = now
+	}
+	h.state = StateDisconnected
+	h.lastErr = err
+	h.failures++
+	h.nextAttempt = now.Add(h.backoff())
+}
+
+func (h *health) backoff() time.Duration {
+	min, max := h.min, h.max
+	if min <= 0 {
+		min = DefaultMinBackoff
+	}
+	if max <= 0 {
+		max = DefaultMaxBackoff
+	}
+	if max < min {
+		max = min
+	}
+	d := min
+	for i := 1; i < h.failures && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+func (h *health) status() Status {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	s := Status{
+		State:       h.state,
+		Device:      h.device,
+		Since:       h.since,
+		NextAttempt: h.nextAttempt,
+	}
+	if h.lastErr != nil {
+		s.LastError = h.lastErr.Error()
+	}
+	return s
+}
+
+// deviceExists returns true if the device node exists.
+func deviceExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// resolveDevice returns the device node for the meter. A stable name is
+// followed to the current node. If the port name does not exist, the
+// port is looked up by the serial number of the USB adapter.
+func (m *Meter) resolveDevice() (string, error) {
+	if deviceExists(m.PortName) {
+		return iec.ResolvePort(m.PortName)
+	}
+	if len(m.SerialNumber) == 0 {
+		return "", ErrPortUnavailable
+	}
+	ports, err := iec.ListPorts()
+	if err != nil {
+		return "", err
+	}
+	for _, p := range ports {
+		if p.SerialNumber == m.SerialNumber {
+			return p.Name, nil
+		}
+	}
+	return "", ErrPortUnavailable
+}
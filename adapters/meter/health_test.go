@@ -0,0 +1,110 @@
+package meter
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/iec"
+	"github.com/peterzandbergen/iec62056/iec/portmgr"
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+func TestHealthBackoff(t *testing.T) {
+	h := &health{min: time.Second, max: 4 * time.Second}
+	now := time.Now()
+	if !h.available(now) {
+		t.Fatal("expected available in unknown state")
+	}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second}
+	for _, d := range expected {
+		h.disconnected(now, ErrPortUnavailable)
+		if h.nextAttempt.Sub(now) != d {
+			t.Errorf("expected back off %s, received %s", d, h.nextAttempt.Sub(now))
+		}
+	}
+	if h.available(now) {
+		t.Error("expected unavailable while backing off")
+	}
+	if !h.available(now.Add(4 * time.Second)) {
+		t.Error("expected available after back off")
+	}
+	h.connected(now, "/dev/ttyUSB1")
+	s := h.status()
+	if s.State != StateConnected || s.Device != "/dev/ttyUSB1" || s.LastError != "" {
+		t.Errorf("unexpected status: %+v", s)
+	}
+	if !h.available(now) {
+		t.Error("expected available when connected")
+	}
+}
+
+func TestGetWhilePortAbsent(t *testing.T) {
+	m := &Meter{
+		PortSettings: iec.NewDefaultSettings(),
+		PortName:     filepath.Join(t.TempDir(), "ttyUSB0"),
+		MinBackoff:   time.Hour,
+	}
+	_, err := m.Get(nil)
+	if !errors.Is(err, model.ErrUnavailable) {
+		t.Fatalf("expected %v, received %v", model.ErrUnavailable, err)
+	}
+	if s := m.Status(); s.State != StateDisconnected {
+		t.Errorf("expected state %s, received %s", StateDisconnected, s.State)
+	}
+	if s := m.Status(); s.NextAttempt.Before(time.Now().Add(59 * time.Minute)) {
+		t.Errorf("expected next attempt in an hour, received %s", s.NextAttempt)
+	}
+}
+
+// TestConcurrentReads reads like the timer and /meter/read at once, run
+// with -race.
+func TestConcurrentReads(t *testing.T) {
+	m := &Meter{
+		PortSettings: iec.NewDefaultSettings(),
+		PortName:     filepath.Join(t.TempDir(), "ttyUSB0"),
+		MinBackoff:   time.Millisecond,
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if _, err := m.Read(context.Background(), portmgr.ClassOnDemand); !errors.Is(err, model.ErrUnavailable) {
+					t.Errorf("expected %v, received %v", model.ErrUnavailable, err)
+				}
+				m.Status()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestResolveDevice(t *testing.T) {
+	dir := t.TempDir()
+	dev := filepath.Join(dir, "ttyUSB1")
+	if err := os.WriteFile(dev, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "usb-FTDI_FT232R_A1B2C3-if00-port0")
+	if err := os.Symlink(dev, link); err != nil {
+		t.Fatal(err)
+	}
+	m := &Meter{PortName: link}
+	d, err := m.resolveDevice()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if d != dev {
+		t.Errorf("expected %s, received %s", dev, d)
+	}
+	m = &Meter{PortName: filepath.Join(dir, "missing")}
+	if _, err := m.resolveDevice(); err != ErrPortUnavailable {
+		t.Errorf("expected %v, received %v", ErrPortUnavailable, err)
+	}
+}
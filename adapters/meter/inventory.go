@@ -0,0 +1,113 @@
+package meter
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/iec"
+)
+
+// ErrMeterNotInInventory is returned when a meter is not in the inventory.
+var ErrMeterNotInInventory = errors.New("meter not in inventory")
+
+// InventoryEntry maps a serial port to the meter connected to it.
+type InventoryEntry struct {
+	// Port is the stable name of the port if available, otherwise the device node.
+	Port string
+	// Device is the device node at the time of discovery.
+	Device       string
+	VID          string
+	PID          string
+	SerialNumber string
+	// ManufacturerID and MeterID of the meter, empty if no meter was found.
+	ManufacturerID string
+	MeterID        string
+	// Settings that produced a valid response from the meter.
+	Settings   *iec.PortSettings `json:",omitempty"`
+	Discovered time.Time
+}
+
+// Inventory lists the serial ports and the meters found on them.
+type Inventory struct {
+	Entries []*InventoryEntry
+}
+
+// NewInventoryEntry creates an entry for the port, without meter.
+func NewInventoryEntry(pi *iec.PortInfo) *InventoryEntry {
+	e := &InventoryEntry{
+		Port:         pi.StableName,
+		Device:       pi.Name,
+		VID:          pi.VID,
+		PID:          pi.PID,
+		SerialNumber: pi.SerialNumber,
+		Discovered:   time.Now(),
+	}
+	if len(e.Port) == 0 {
+		e.Port = pi.Name
+	}
+	return e
+}
+
+// HasMeter returns true if a meter was found on the port.
+func (e *InventoryEntry) HasMeter() bool {
+	return e.Settings != nil
+}
+
+// Find returns the entry for the meter with the given ID.
+func (inv *Inventory) Find(meterID string) (*InventoryEntry, error) {
+	for _, e := range inv.Entries {
+		if e.HasMeter() && e.MeterID == meterID {
+			return e, nil
+		}
+	}
+	return nil, ErrMeterNotInInventory
+}
+
+// PortSettings returns settings for the meter that use the stable port name.
+func (e *InventoryEntry) PortSettings() *iec.PortSettings {
+	s := *e.Settings
+	s.PortName = e.Port
+	return &s
+}
+
+// ReadInventory reads a JSON inventory from r.
+func ReadInventory(r io.Reader) (*Inventory, error) {
+	inv := &Inventory{}
+	if err := json.NewDecoder(r).Decode(inv); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// WriteInventory writes the inventory to w as JSON.
+func WriteInventory(w io.Writer, inv *Inventory) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(inv)
+}
+
+// LoadInventory reads the inventory from a JSON file.
+func LoadInventory(filename string) (*Inventory, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadInventory(f)
+}
+
+// SaveInventory writes the inventory to a JSON file.
+func SaveInventory(filename string, inv *Inventory) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := WriteInventory(f, inv); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,52 @@
+package meter
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/peterzandbergen/iec62056/iec"
+)
+
+func TestInventoryRoundTrip(t *testing.T) {
+	e := NewInventoryEntry(&iec.PortInfo{
+		Name:         "/dev/ttyUSB1",
+		StableName:   "/dev/serial/by-id/usb-FTDI_FT232R_A1B2C3-if00-port0",
+		IsUSB:        true,
+		VID:          "0403",
+		PID:          "6001",
+		SerialNumber: "A1B2C3",
+	})
+	e.ManufacturerID = "CTA"
+	e.MeterID = "ZIV-METER"
+	e.Settings = iec.NewDefaultSettings().WithCandidate(iec.DefaultProbeCandidates[1])
+	e.Settings.PortName = "/dev/ttyUSB1"
+	inv := &Inventory{
+		Entries: []*InventoryEntry{
+			NewInventoryEntry(&iec.PortInfo{Name: "/dev/ttyUSB0"}),
+			e,
+		},
+	}
+
+	b := &bytes.Buffer{}
+	if err := WriteInventory(b, inv); err != nil {
+		t.Fatalf("error writing inventory: %s", err.Error())
+	}
+	inv, err := ReadInventory(b)
+	if err != nil {
+		t.Fatalf("error reading inventory: %s", err.Error())
+	}
+	found, err := inv.Find("ZIV-METER")
+	if err != nil {
+		t.Fatalf("error finding meter: %s", err.Error())
+	}
+	ps := found.PortSettings()
+	if ps.PortName != e.Port {
+		t.Errorf("expected port %s, received %s", e.Port, ps.PortName)
+	}
+	if ps.Protocol != iec.ProtocolP1 || ps.InitialBaudRateModeABC != 115200 {
+		t.Errorf("unexpected settings: %+v", ps)
+	}
+	if _, err := inv.Find("unknown"); err != ErrMeterNotInInventory {
+		t.Errorf("expected %v, received %v", ErrMeterNotInInventory, err)
+	}
+}
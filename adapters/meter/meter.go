@@ -1,11 +1,13 @@
 package meter
 
 import (
+	"context"
 	"errors"
 	"log"
 	"time"
 
 	"github.com/peterzandbergen/iec62056/iec"
+	"github.com/peterzandbergen/iec62056/iec/portmgr"
 	"github.com/peterzandbergen/iec62056/model"
 )
 
@@ -18,12 +20,17 @@ type Meter struct {
 	PortName string
 	// TimeOut for reading the meter in seconds. Default is 60.
 	TimeOut time.Duration
-}
-
-// Measurement is used internally.
-type measurement struct {
-	m   *model.Measurement
-	err error
+	// SerialNumber of the USB adapter, optional. Used to find the port
+	// when PortName does not exist, e.g. after the adapter was replugged.
+	SerialNumber string
+	// MinBackoff and MaxBackoff limit the wait between attempts to open the
+	// port while it is absent. Defaults are DefaultMinBackoff and DefaultMaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Manager serialises the access to the port, optional.
+	Manager *portmgr.Manager
+
+	health health
 }
 
 // Check if the interface has been fully implemented.
@@ -32,6 +39,9 @@ var _ model.MeasurementRepo = &Meter{}
 // ErrTimeout indicates that reading the meter took too long.
 var ErrTimeout = errors.New("timeout reading from meter")
 
+// ErrUnsupported is returned for queries the meter cannot answer.
+var ErrUnsupported = errors.New("not supported by the meter")
+
 // Copy one reading.
 func copyReading(src iec.DataSet) (dst model.DataSet) {
 	return model.DataSet{
@@ -51,9 +61,35 @@ func copyReadings(src []iec.DataSet) (dst []model.DataSet) {
 
 // Get returns a measurement from the meter.
 // The Key parameter is ignored and can be set to nil.
+// Returns ErrPortUnavailable without accessing the port while backing off.
 func (m *Meter) Get(key []byte) (*model.Measurement, error) {
+	return m.Read(context.Background(), portmgr.ClassTimer)
+}
+
+// Read returns a measurement from the meter. With a Manager the read waits
+// in the queue for the class, ctx only limits that wait. Once started the
+// read is limited by TimeOut and the read timeout of the port settings.
+func (m *Meter) Read(ctx context.Context, class portmgr.Class) (*model.Measurement, error) {
 	t := time.Now()
-	mm, err := m.readWithTimeout()
+	if !m.health.available(t) {
+		return nil, ErrPortUnavailable
+	}
+	m.health.setBackoff(m.MinBackoff, m.MaxBackoff)
+	// Find the current device node for the port.
+	device, err := m.resolveDevice()
+	if err != nil {
+		m.health.disconnected(time.Now(), err)
+		return nil, err
+	}
+	// Only used when the read completed, a read can outlive ctx.
+	var mm *model.Measurement
+	err = m.do(ctx, device, class, func(ctx context.Context) error {
+		// Time of the reading, not of the request.
+		t = time.Now()
+		res, err := m.readWithTimeout(device)
+		mm = res
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -61,6 +97,14 @@ func (m *Meter) Get(key []byte) (*model.Measurement, error) {
 	return mm, nil
 }
 
+// do runs fn through the manager if there is one.
+func (m *Meter) do(ctx context.Context, device string, class portmgr.Class, fn func(ctx context.Context) error) error {
+	if m.Manager == nil {
+		return fn(ctx)
+	}
+	return m.Manager.Do(ctx, device, class, fn)
+}
+
 // Put is a noop and should not be called.
 // TODO: Return an unsupported error.
 func (m *Meter) Put(*model.Measurement) error {
@@ -82,6 +126,11 @@ func (m *Meter) GetPage(page, pagesize int) ([]*model.Measurement, error) {
 	}, nil
 }
 
+// GetRange is not supported, the meter only has the current measurement.
+func (m *Meter) GetRange(meterID string, from, to time.Time, limit int) ([]*model.Measurement, error) {
+	return nil, ErrUnsupported
+}
+
 func copyMsgToMsm(msg *iec.DataMessage) *model.Measurement {
 	return &model.Measurement{
 		Identification: msg.MeterID,
@@ -92,51 +141,42 @@ func copyMsgToMsm(msg *iec.DataMessage) *model.Measurement {
 
 // readWithTimeout opens the port with the correct settings and reads a value.
 // Limits the time to read the measurement with the configured timeout.
-func (m *Meter) readWithTimeout() (*model.Measurement, error) {
-	if m.TimeOut <= 0 {
-		m.TimeOut = 60
+func (m *Meter) readWithTimeout(device string) (*model.Measurement, error) {
+	timeout := m.TimeOut
+	if timeout <= 0 {
+		timeout = 60
 	}
 	// Open the serial port repo.
 	port := iec.New(m.PortSettings)
-	err := port.Open(m.PortName)
+	err := port.Open(device)
 	if err != nil {
-		// Log error.
+		m.health.disconnected(time.Now(), err)
 		return nil, err
 	}
+	m.health.connected(time.Now(), device)
 	// Close the port when done.
 	defer port.Close()
 
 	// Sleep to make sure the port is ready.
 	time.Sleep(500 * time.Millisecond)
-	// Channel to receive the measurement message.
-	mc := make(chan *measurement)
-	// Perform measurement in the background.
-	go func() {
-		msg := &measurement{}
-		dm, err := port.Read()
-		if err != nil {
-			msg.err = err
-			mc <- msg
-			return
-		}
-		msg.m, msg.err = copyMsgToMsm(dm), nil
-		mc <- msg
-	}()
-	// Wait for measurement or timeout.
-	select {
-	case <-time.NewTimer(m.TimeOut * time.Second).C:
-		// Log timeout reading measurement.
+	// A read that takes too long is aborted, ReadTimeout only returns when
+	// the read has ended, so the port is not used after the manager
+	// released it.
+	dm, err := port.ReadTimeout(timeout * time.Second)
+	if err == iec.ErrReadTimeout {
 		log.Printf("timeout reading a measurement")
 		return nil, ErrTimeout
-	case msg := <-mc:
-		// Measurement or error received.
-		if msg.err != nil {
-			// Log error reading measurement.
-			log.Printf("error reading a measurement: %s", msg.err.Error())
-			return nil, msg.err
+	}
+	if err != nil {
+		// The adapter was unplugged during the read.
+		if !deviceExists(device) {
+			m.health.disconnected(time.Now(), err)
 		}
-		return msg.m, nil
+		// Log error reading measurement.
+		log.Printf("error reading a measurement: %s", err.Error())
+		return nil, err
 	}
+	return copyMsgToMsm(dm), nil
 }
 
 // Delete is a noop and should not be called.
@@ -145,17 +185,27 @@ func (m *Meter) Delete(*model.Measurement) error {
 	return nil
 }
 
+// Status returns the connection state of the meter port.
+func (m *Meter) Status() Status {
+	return m.health.status()
+}
+
 // PortExists tests if the port exists and can be opened.
-// Should only be called once for it will interfere with a Get call.
+// Without a Manager it will interfere with a concurrent Get call.
 func (m *Meter) PortExists() bool {
-	// Open the serial port repo.
-	port := iec.New(m.PortSettings)
-	err := port.Open(m.PortName)
+	device, err := m.resolveDevice()
 	if err != nil {
-		// Log error.
 		return false
 	}
-	// Close the port when done.
-	defer port.Close()
-	return true
+	err = m.do(context.Background(), device, portmgr.ClassProbe, func(ctx context.Context) error {
+		// Open the serial port repo.
+		port := iec.New(m.PortSettings)
+		if err := port.Open(device); err != nil {
+			return err
+		}
+		// Close the port when done.
+		port.Close()
+		return nil
+	})
+	return err == nil
 }
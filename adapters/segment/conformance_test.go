@@ -0,0 +1,19 @@
+package segment
+
+import (
+	"testing"
+
+	"github.com/peterzandbergen/iec62056/model"
+	"github.com/peterzandbergen/iec62056/model/repotest"
+)
+
+func TestConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) model.MeasurementRepo {
+		r, err := OpenWithOptions(t.TempDir(), &Options{NoSync: true})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		t.Cleanup(func() { r.Close() })
+		return r
+	})
+}
@@ -0,0 +1,182 @@
+package segment
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+// outbox tracks the records that have not been uploaded. Every record has a
+// sequence number, the mark is the sequence number of the last record that
+// was acknowledged. The keys of Pending are the big endian sequence numbers.
+// A disabled outbox tracks nothing.
+type outbox struct {
+	enabled bool
+	mark    uint64
+	// pending are the puts after the mark in sequence order.
+	pending []pendingRecord
+	// latest is the sequence number of the last record of a key, for the
+	// keys with records after the mark.
+	latest map[string]uint64
+}
+
+type pendingRecord struct {
+	seq uint64
+	key string
+	seg *segment
+	off int64
+}
+
+// loadMark returns the stored mark. A damaged mark file is read as 0, the
+// measurements are uploaded again rather than the repo not opening.
+func loadMark(dir string) (uint64, error) {
+	name := filepath.Join(dir, markFile)
+	b, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(b) != 8 {
+		log.Printf("segment: %s is damaged, uploading all measurements again", name)
+		return 0, nil
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func (o *outbox) added(s *segment, rec *record, off int64) {
+	if !o.enabled || rec.seq <= o.mark {
+		return
+	}
+	if o.latest == nil {
+		o.latest = map[string]uint64{}
+	}
+	k := rec.key()
+	o.latest[k] = rec.seq
+	if rec.kind == kindPut {
+		o.pending = append(o.pending, pendingRecord{seq: rec.seq, key: k, seg: s, off: off})
+	}
+}
+
+// sort orders the pending records after the scan of the segments.
+func (o *outbox) sort() {
+	sort.Slice(o.pending, func(i, j int) bool { return o.pending[i].seq < o.pending[j].seq })
+}
+
+// valid returns true if the record is still the last of its key.
+func (o *outbox) valid(p pendingRecord) bool {
+	return o.latest[p.key] == p.seq
+}
+
+// holds returns true if the segment has records that wait for upload.
+func (o *outbox) holds(s *segment) bool {
+	for _, p := range o.pending {
+		if p.seg == s && o.valid(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Pending returns up to n measurements that have not been uploaded, in the
+// order they were stored, with their keys. Replaced and deleted
+// measurements are skipped.
+func (r *Repo) Pending(n int) ([][]byte, []*model.Measurement, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if r.closed {
+		return nil, nil, ErrClosed
+	}
+	var keys [][]byte
+	var ms []*model.Measurement
+	for _, p := range r.pending {
+		if len(ms) >= n {
+			break
+		}
+		if !r.valid(p) {
+			continue
+		}
+		m, err := readMeasurement(p.seg, p.off)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, binary.BigEndian.AppendUint64(nil, p.seq))
+		ms = append(ms, m)
+	}
+	return keys, ms, nil
+}
+
+func readMeasurement(s *segment, off int64) (*model.Measurement, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rec, _, err := newRecordReader(io.NewSectionReader(f, off, s.size-off), off).next()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s at %d", ErrCorrupt, s.path, off)
+	}
+	m, err := rec.measurement()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s at %d", ErrCorrupt, s.path, off)
+	}
+	return m, nil
+}
+
+// Ack marks the measurements with the keys and all that were stored before
+// them as uploaded. Pending returns them in the order they were stored, so
+// acknowledging a batch of Pending does not skip any.
+func (r *Repo) Ack(keys [][]byte) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return ErrClosed
+	}
+	mark := r.mark
+	for _, k := range keys {
+		if len(k) != 8 {
+			return ErrBadArguments
+		}
+		if seq := binary.BigEndian.Uint64(k); seq > mark {
+			mark = seq
+		}
+	}
+	if mark == r.mark {
+		return nil
+	}
+	if err := writeFile(filepath.Join(r.dir, markFile), binary.BigEndian.AppendUint64(nil, mark)); err != nil {
+		return err
+	}
+	r.mark = mark
+	i := sort.Search(len(r.pending), func(i int) bool { return r.pending[i].seq > mark })
+	r.pending = append([]pendingRecord{}, r.pending[i:]...)
+	for k, seq := range r.latest {
+		if seq <= mark {
+			delete(r.latest, k)
+		}
+	}
+	return nil
+}
+
+// Backlog returns the number of measurements that wait for upload.
+func (r *Repo) Backlog() (int, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if r.closed {
+		return 0, ErrClosed
+	}
+	n := 0
+	for _, p := range r.pending {
+		if r.valid(p) {
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,139 @@
+package segment
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+// A segment file starts with segmentMagic, followed by the records. A record
+// is the big endian length and CRC-32C of the payload and the payload: the
+// kind, the uvarint sequence number, the varint time in nanoseconds, the
+// uvarint length and the meter ID and for a put the measurement as JSON.
+
+const (
+	segmentMagic = "IECSEG\x00\x01"
+	// headerLen is the length of the record header.
+	headerLen = 8
+	// maxRecord limits the allocation for the length of a damaged record.
+	maxRecord = 16 << 20
+)
+
+// Kinds of records.
+const (
+	kindPut    = 1
+	kindDelete = 2
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// errTorn is returned for a record that is incomplete or fails the CRC,
+// the end of the valid records.
+var errTorn = errors.New("torn record")
+
+// record is a decoded record.
+type record struct {
+	kind    byte
+	seq     uint64
+	time    int64
+	meterID string
+	body    []byte
+}
+
+// key identifies the measurement of the record, a later record with the
+// same key replaces or deletes it.
+func (r *record) key() string {
+	return r.meterID + "\x00" + string(binary.BigEndian.AppendUint64(nil, uint64(r.time)))
+}
+
+func (r *record) measurement() (*model.Measurement, error) {
+	m := &model.Measurement{}
+	if err := json.Unmarshal(r.body, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// appendRecord appends the encoded record to b.
+func appendRecord(b []byte, r *record) []byte {
+	p := []byte{r.kind}
+	p = binary.AppendUvarint(p, r.seq)
+	p = binary.AppendVarint(p, r.time)
+	p = binary.AppendUvarint(p, uint64(len(r.meterID)))
+	p = append(p, r.meterID...)
+	p = append(p, r.body...)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(p)))
+	b = binary.BigEndian.AppendUint32(b, crc32.Checksum(p, castagnoli))
+	return append(b, p...)
+}
+
+// recordReader reads the records of a segment.
+type recordReader struct {
+	r *bufio.Reader
+	// off is the offset of the next record.
+	off int64
+	hdr [headerLen]byte
+}
+
+func newRecordReader(r io.Reader, off int64) *recordReader {
+	return &recordReader{r: bufio.NewReader(r), off: off}
+}
+
+// next returns the next record and its offset, io.EOF at the end and
+// errTorn for a damaged record.
+func (rr *recordReader) next() (*record, int64, error) {
+	off := rr.off
+	if _, err := io.ReadFull(rr.r, rr.hdr[:]); err != nil {
+		if err == io.EOF {
+			return nil, off, io.EOF
+		}
+		return nil, off, errTorn
+	}
+	l := binary.BigEndian.Uint32(rr.hdr[:4])
+	if l == 0 || l > maxRecord {
+		return nil, off, errTorn
+	}
+	p := make([]byte, l)
+	if _, err := io.ReadFull(rr.r, p); err != nil {
+		return nil, off, errTorn
+	}
+	if crc32.Checksum(p, castagnoli) != binary.BigEndian.Uint32(rr.hdr[4:]) {
+		return nil, off, errTorn
+	}
+	r, err := decodePayload(p)
+	if err != nil {
+		return nil, off, errTorn
+	}
+	rr.off += headerLen + int64(l)
+	return r, off, nil
+}
+
+func decodePayload(p []byte) (*record, error) {
+	r := &record{kind: p[0]}
+	if r.kind != kindPut && r.kind != kindDelete {
+		return nil, errTorn
+	}
+	p = p[1:]
+	var n int
+	if r.seq, n = binary.Uvarint(p); n <= 0 {
+		return nil, errTorn
+	}
+	p = p[n:]
+	if r.time, n = binary.Varint(p); n <= 0 {
+		return nil, errTorn
+	}
+	p = p[n:]
+	l, n := binary.Uvarint(p)
+	if n <= 0 || l > uint64(len(p)-n) {
+		return nil, errTorn
+	}
+	p = p[n:]
+	r.meterID = string(p[:l])
+	r.body = p[l:]
+	return r, nil
+}
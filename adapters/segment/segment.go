@@ -0,0 +1,625 @@
+// Package segment stores the measurements in append-only files, an
+// alternative to the LevelDB cache for loggers that lose power.
+//
+// The measurements are appended to a segment file per period of their time,
+// a day by default, with a CRC per record. A changed or deleted measurement
+// is a new record that replaces the earlier one, nothing is written in
+// place. Open scans the segments, truncates a record that was torn by a
+// power loss and builds a sparse time index in memory. Retention removes
+// whole segments.
+package segment
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+var (
+	_ model.MeasurementRepo = &Repo{}
+	_ model.Walker          = &Repo{}
+)
+
+var (
+	ErrClosed       = errors.New("repo closed")
+	ErrBadArguments = errors.New("bad argument(s)")
+	ErrNoElements   = model.ErrNoElements
+	// ErrCorrupt is returned for a file in the directory that is not a
+	// segment or a record that cannot be decoded.
+	ErrCorrupt = errors.New("corrupt segment")
+)
+
+// Defaults for the options.
+const (
+	DefaultSegmentDuration = 24 * time.Hour
+	DefaultIndexInterval   = 64
+)
+
+const (
+	segmentExt = ".seg"
+	// segmentFormat is the UTC start of a segment in its file name.
+	segmentFormat = "20060102T150405Z"
+	// configFile holds the segment duration of the directory.
+	configFile = "segments.conf"
+	// markFile holds the sequence number of the last uploaded record.
+	markFile = "outbox.mark"
+)
+
+// Options for opening the repo.
+type Options struct {
+	// SegmentDuration is the period of a segment, it must divide a day or be
+	// a number of days. The duration of a directory cannot be changed, 0
+	// uses the duration of the directory or DefaultSegmentDuration.
+	SegmentDuration time.Duration
+	// Retention is the age after which a segment is removed by Compact, 0
+	// keeps the segments forever. With the outbox, segments with
+	// measurements that wait for upload are kept.
+	Retention time.Duration
+	// Outbox tracks the measurements that wait for upload, see Pending and
+	// Ack. Without the outbox nothing is pending. Enabling it again queues
+	// the measurements stored since the last Ack.
+	Outbox bool
+	// IndexInterval is the number of records per entry of the sparse time
+	// index, DefaultIndexInterval if 0.
+	IndexInterval int
+	// NoSync skips the fsync after every write. Faster, but a power loss can
+	// lose the latest measurements.
+	NoSync bool
+}
+
+// Repo is a MeasurementRepo in a directory of segments. Measurements are
+// returned in time order, measurements with the same time in the order of
+// their meter ID. Get only supports model.First and model.Last.
+type Repo struct {
+	dir     string
+	period  time.Duration
+	options Options
+
+	lock     sync.RWMutex
+	closed   bool
+	segments []*segment
+	// seq is the sequence number of the last record.
+	seq uint64
+	// w appends to the segment ws.
+	w  *os.File
+	ws *segment
+	outbox
+}
+
+// segment is the metadata of a segment file.
+type segment struct {
+	start time.Time
+	path  string
+	// size of the valid records, a read stops there.
+	size  int64
+	count int
+	// last is the time of the last record, sorted is true while the
+	// records are in time order.
+	last   int64
+	sorted bool
+	// index has an entry every IndexInterval records of a sorted segment.
+	index []indexEntry
+}
+
+type indexEntry struct {
+	time int64
+	off  int64
+}
+
+func Open(dir string) (*Repo, error) {
+	return OpenWithOptions(dir, nil)
+}
+
+// OpenWithOptions opens the repo in the directory with the options, nil for
+// the defaults. The directory is created if needed.
+func OpenWithOptions(dir string, o *Options) (*Repo, error) {
+	if o == nil {
+		o = &Options{}
+	}
+	if o.Retention < 0 || o.SegmentDuration < 0 {
+		return nil, ErrBadArguments
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	period, err := loadPeriod(dir, o.SegmentDuration)
+	if err != nil {
+		return nil, err
+	}
+	r := &Repo{dir: dir, period: period, options: *o}
+	if r.options.IndexInterval <= 0 {
+		r.options.IndexInterval = DefaultIndexInterval
+	}
+	r.outbox.enabled = o.Outbox
+	if r.mark, err = loadMark(dir); err != nil {
+		return nil, err
+	}
+	// The acknowledged records may have been removed by the retention.
+	r.seq = r.mark
+	names, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		start, err := time.Parse(segmentFormat, strings.TrimSuffix(filepath.Base(name), segmentExt))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrCorrupt, name)
+		}
+		s := &segment{start: start, path: name, sorted: true}
+		if err := r.recover(s); err != nil {
+			return nil, err
+		}
+		r.segments = append(r.segments, s)
+	}
+	r.outbox.sort()
+	return r, nil
+}
+
+// loadPeriod returns the segment duration of the directory, which is stored
+// at the first open.
+func loadPeriod(dir string, period time.Duration) (time.Duration, error) {
+	name := filepath.Join(dir, configFile)
+	b, err := os.ReadFile(name)
+	if err == nil {
+		stored, err := time.ParseDuration(strings.TrimSpace(string(b)))
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s", ErrCorrupt, name)
+		}
+		if period != 0 && period != stored {
+			return 0, fmt.Errorf("%w: the segment duration of %s is %s", ErrBadArguments, dir, stored)
+		}
+		return stored, nil
+	}
+	if !os.IsNotExist(err) {
+		return 0, err
+	}
+	if period == 0 {
+		period = DefaultSegmentDuration
+	}
+	day := 24 * time.Hour
+	if day%period != 0 && period%day != 0 {
+		return 0, fmt.Errorf("%w: segment duration %s does not divide a day", ErrBadArguments, period)
+	}
+	return period, writeFile(name, []byte(period.String()+"\n"))
+}
+
+// writeFile replaces the file at once, through a temporary file that is
+// synced before the rename, so a power loss leaves the old or the new file.
+func writeFile(name string, b []byte) error {
+	tmp := name + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(name))
+}
+
+// syncDir syncs the directory, so the files created or renamed in it
+// survive a power loss.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// recover reads the segment, truncates it at the first damaged record and
+// builds its metadata.
+func (r *Repo) recover(s *segment) error {
+	f, err := os.OpenFile(s.path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	magic := make([]byte, len(segmentMagic))
+	if n, err := io.ReadFull(f, magic); err != nil {
+		if n > 0 && !bytes.HasPrefix([]byte(segmentMagic), magic[:n]) {
+			return fmt.Errorf("%w: %s", ErrCorrupt, s.path)
+		}
+		// Torn while it was created.
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := f.WriteAt([]byte(segmentMagic), 0); err != nil {
+			return err
+		}
+		s.size = int64(len(segmentMagic))
+		return nil
+	}
+	if string(magic) != segmentMagic {
+		return fmt.Errorf("%w: %s", ErrCorrupt, s.path)
+	}
+	rr := newRecordReader(f, int64(len(segmentMagic)))
+	for {
+		rec, off, err := rr.next()
+		if err == io.EOF {
+			s.size = off
+			return nil
+		}
+		if err == errTorn {
+			fi, serr := f.Stat()
+			if serr != nil {
+				return serr
+			}
+			log.Printf("segment: %s: truncating %d bytes of a torn record at %d", s.path, fi.Size()-off, off)
+			s.size = off
+			return f.Truncate(off)
+		}
+		if err != nil {
+			return err
+		}
+		r.added(s, rec, off)
+	}
+}
+
+// added updates the metadata with the record at off.
+func (r *Repo) added(s *segment, rec *record, off int64) {
+	if s.count > 0 && rec.time < s.last {
+		s.sorted = false
+		s.index = nil
+	}
+	if s.sorted && s.count%r.options.IndexInterval == 0 {
+		s.index = append(s.index, indexEntry{time: rec.time, off: off})
+	}
+	s.count++
+	s.last = rec.time
+	if rec.seq > r.seq {
+		r.seq = rec.seq
+	}
+	r.outbox.added(s, rec, off)
+}
+
+func (r *Repo) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if r.w != nil {
+		return r.w.Close()
+	}
+	return nil
+}
+
+func (r *Repo) Put(m *model.Measurement) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return r.append(&record{kind: kindPut, time: m.Time.UnixNano(), meterID: m.MeterID(), body: b})
+}
+
+// Delete appends a record that deletes the measurement.
+func (r *Repo) Delete(m *model.Measurement) error {
+	return r.append(&record{kind: kindDelete, time: m.Time.UnixNano(), meterID: m.MeterID()})
+}
+
+func (r *Repo) append(rec *record) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return ErrClosed
+	}
+	s, err := r.segmentFor(time.Unix(0, rec.time))
+	if err != nil {
+		return err
+	}
+	if r.ws != s {
+		if r.w != nil {
+			r.w.Close()
+			r.w, r.ws = nil, nil
+		}
+		if r.w, err = os.OpenFile(s.path, os.O_WRONLY, 0); err != nil {
+			return err
+		}
+		r.ws = s
+	}
+	rec.seq = r.seq + 1
+	b := appendRecord(nil, rec)
+	if _, err := r.w.WriteAt(b, s.size); err != nil {
+		// Remove a partial record, the next write starts at the size.
+		r.w.Truncate(s.size)
+		return err
+	}
+	if !r.options.NoSync {
+		if err := r.w.Sync(); err != nil {
+			return err
+		}
+	}
+	off := s.size
+	s.size += int64(len(b))
+	r.added(s, rec, off)
+	return nil
+}
+
+// segmentFor returns the segment for the time, a new one is created.
+func (r *Repo) segmentFor(t time.Time) (*segment, error) {
+	start := t.UTC().Truncate(r.period)
+	i := sort.Search(len(r.segments), func(i int) bool { return !r.segments[i].start.Before(start) })
+	if i < len(r.segments) && r.segments[i].start.Equal(start) {
+		return r.segments[i], nil
+	}
+	s := &segment{
+		start:  start,
+		path:   filepath.Join(r.dir, start.Format(segmentFormat)+segmentExt),
+		size:   int64(len(segmentMagic)),
+		sorted: true,
+	}
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	_, err = f.Write([]byte(segmentMagic))
+	if err == nil && !r.options.NoSync {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && !r.options.NoSync {
+		err = syncDir(r.dir)
+	}
+	if err != nil {
+		os.Remove(s.path)
+		return nil, err
+	}
+	r.segments = append(r.segments, nil)
+	copy(r.segments[i+1:], r.segments[i:])
+	r.segments[i] = s
+	return s, nil
+}
+
+// view returns copies of the segments that can be read without the lock.
+func (r *Repo) view() ([]segment, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if r.closed {
+		return nil, ErrClosed
+	}
+	v := make([]segment, len(r.segments))
+	for i, s := range r.segments {
+		v[i] = *s
+	}
+	return v, nil
+}
+
+// load returns the measurement records of the meter in the time range of
+// the segment, in the order of the repo. A later record with the same key
+// replaces or deletes an earlier one. The index is used for the start of
+// a sorted segment.
+func (r *Repo) load(s *segment, meterID string, from, to int64, hasFrom, hasTo bool) ([]*record, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	off := int64(len(segmentMagic))
+	if s.sorted && hasFrom {
+		i := sort.Search(len(s.index), func(i int) bool { return s.index[i].time >= from })
+		if i > 0 {
+			off = s.index[i-1].off
+		}
+	}
+	rr := newRecordReader(io.NewSectionReader(f, off, s.size-off), off)
+	latest := map[string]*record{}
+	for {
+		rec, _, err := rr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s at %d", ErrCorrupt, s.path, rr.off)
+		}
+		if hasTo && rec.time >= to {
+			if s.sorted {
+				break
+			}
+			continue
+		}
+		if (hasFrom && rec.time < from) || (len(meterID) > 0 && rec.meterID != meterID) {
+			continue
+		}
+		latest[rec.key()] = rec
+	}
+	recs := make([]*record, 0, len(latest))
+	for _, rec := range latest {
+		if rec.kind == kindPut {
+			recs = append(recs, rec)
+		}
+	}
+	sort.Slice(recs, func(i, j int) bool {
+		if recs[i].time != recs[j].time {
+			return recs[i].time < recs[j].time
+		}
+		return recs[i].meterID < recs[j].meterID
+	})
+	return recs, nil
+}
+
+// Walk calls fn for the measurements of the meter in the time range, see
+// model.Walker. One segment is held in memory at a time.
+func (r *Repo) Walk(meterID string, from, to time.Time, fn model.WalkFunc) error {
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		return ErrBadArguments
+	}
+	segments, err := r.view()
+	if err != nil {
+		return err
+	}
+	for i := range segments {
+		s := &segments[i]
+		if (!to.IsZero() && !s.start.Before(to)) || (!from.IsZero() && !s.start.Add(r.period).After(from)) {
+			continue
+		}
+		recs, err := r.load(s, meterID, from.UnixNano(), to.UnixNano(), !from.IsZero(), !to.IsZero())
+		if err != nil {
+			return err
+		}
+		for _, rec := range recs {
+			m, err := rec.measurement()
+			if err != nil {
+				return fmt.Errorf("%w: %s: %s", ErrCorrupt, s.path, err.Error())
+			}
+			if err := fn(m); err != nil {
+				if err == model.ErrStopWalk {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GetRange returns up to limit measurements of the meter in the time range,
+// see model.MeasurementRepo.
+func (r *Repo) GetRange(meterID string, from, to time.Time, limit int) ([]*model.Measurement, error) {
+	ms := make([]*model.Measurement, 0)
+	err := r.Walk(meterID, from, to, func(m *model.Measurement) error {
+		ms = append(ms, m)
+		if limit > 0 && len(ms) >= limit {
+			return model.ErrStopWalk
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+// GetAll returns all measurements in time order.
+func (r *Repo) GetAll() ([]*model.Measurement, error) {
+	return r.GetRange("", time.Time{}, time.Time{}, 0)
+}
+
+// GetPage returns pagesize items from the given page. Page starts at 0.
+func (r *Repo) GetPage(page, pagesize int) ([]*model.Measurement, error) {
+	if page < 0 || pagesize < 0 {
+		return nil, ErrBadArguments
+	}
+	skip := page * pagesize
+	ms := make([]*model.Measurement, 0)
+	err := r.Walk("", time.Time{}, time.Time{}, func(m *model.Measurement) error {
+		if skip > 0 {
+			skip--
+			return nil
+		}
+		if len(ms) >= pagesize {
+			return model.ErrStopWalk
+		}
+		ms = append(ms, m)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(ms) == 0 {
+		return nil, ErrNoElements
+	}
+	return ms, nil
+}
+
+// Get returns the first or the last measurement for model.First or
+// model.Last, other keys are ErrBadArguments.
+func (r *Repo) Get(key []byte) (*model.Measurement, error) {
+	switch string(key) {
+	case model.First:
+		ms, err := r.GetRange("", time.Time{}, time.Time{}, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(ms) == 0 {
+			return nil, ErrNoElements
+		}
+		return ms[0], nil
+	case model.Last:
+		segments, err := r.view()
+		if err != nil {
+			return nil, err
+		}
+		for i := len(segments) - 1; i >= 0; i-- {
+			recs, err := r.load(&segments[i], "", 0, 0, false, false)
+			if err != nil {
+				return nil, err
+			}
+			if len(recs) > 0 {
+				return recs[len(recs)-1].measurement()
+			}
+		}
+		return nil, ErrNoElements
+	}
+	return nil, ErrBadArguments
+}
+
+// Compact removes the segments that ended before the retention and returns
+// the number of records removed. With the outbox, a segment with
+// measurements that wait for upload is kept. Compact implements service.Compactable, there are no
+// rollups.
+func (r *Repo) Compact(now time.Time) (rollups, deleted int, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return 0, 0, ErrClosed
+	}
+	if r.options.Retention == 0 {
+		return 0, 0, nil
+	}
+	limit := now.Add(-r.options.Retention)
+	kept := r.segments[:0]
+	for _, s := range r.segments {
+		if s.start.Add(r.period).After(limit) || r.outbox.holds(s) {
+			kept = append(kept, s)
+			continue
+		}
+		if r.ws == s {
+			r.w.Close()
+			r.w, r.ws = nil, nil
+		}
+		if rerr := os.Remove(s.path); rerr != nil {
+			kept = append(kept, s)
+			if err == nil {
+				err = rerr
+			}
+			continue
+		}
+		deleted += s.count
+	}
+	for i := len(kept); i < len(r.segments); i++ {
+		r.segments[i] = nil
+	}
+	r.segments = kept
+	return 0, deleted, err
+}
@@ -0,0 +1,273 @@
+package segment
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+var t0 = time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)
+
+func newMeasurement(meter string, t time.Time, v string) *model.Measurement {
+	return &model.Measurement{
+		Time:           t,
+		ManufacturerID: "ISK",
+		Identification: meter,
+		Readings:       []model.DataSet{{Address: "1.8.1", Value: v, Unit: "kWh"}},
+	}
+}
+
+func openTest(t *testing.T, dir string, o *Options) *Repo {
+	r, err := OpenWithOptions(dir, o)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func put(t *testing.T, r *Repo, mm ...*model.Measurement) {
+	for _, m := range mm {
+		if err := r.Put(m); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+}
+
+func TestPutGet(t *testing.T) {
+	dir := t.TempDir()
+	r := openTest(t, dir, &Options{IndexInterval: 2})
+	// Two days, out of order in the second.
+	for i := 0; i < 10; i++ {
+		put(t, r, newMeasurement("m1", t0.Add(time.Duration(i)*time.Hour), "1"))
+	}
+	put(t, r, newMeasurement("m2", t0.Add(30*time.Minute), "2"))
+	put(t, r, newMeasurement("m1", t0.Add(24*time.Hour+30*time.Minute), "3"))
+	put(t, r, newMeasurement("m1", t0.Add(24*time.Hour), "4"))
+
+	check := func(r *Repo) {
+		all, err := r.GetAll()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if len(all) != 13 {
+			t.Fatalf("expected %v, received %v", 13, len(all))
+		}
+		for i := 1; i < len(all); i++ {
+			if all[i].Time.Before(all[i-1].Time) {
+				t.Errorf("expected %v, received %v", "time order", all)
+			}
+		}
+		ms, err := r.GetRange("ISK/m1", t0.Add(3*time.Hour), t0.Add(24*time.Hour+time.Minute), 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if len(ms) != 8 || !ms[0].Time.Equal(t0.Add(3*time.Hour)) || ms[7].Readings[0].Value != "4" {
+			t.Errorf("expected %v, received %v", "8 measurements", ms)
+		}
+		first, err := r.Get([]byte(model.First))
+		if err != nil || !first.Time.Equal(t0) {
+			t.Errorf("expected %v, received %v, %v", t0, first, err)
+		}
+		last, err := r.Get([]byte(model.Last))
+		if err != nil || last.Readings[0].Value != "3" {
+			t.Errorf("expected %v, received %v, %v", "3", last, err)
+		}
+		page, err := r.GetPage(1, 1)
+		if err != nil || len(page) != 1 || page[0].Identification != "m2" {
+			t.Errorf("expected %v, received %v, %v", "m2 first on page 1", page, err)
+		}
+		if _, err := r.GetPage(13, 1); err != ErrNoElements {
+			t.Errorf("expected %v, received %v", ErrNoElements, err)
+		}
+	}
+	check(r)
+	r.Close()
+	check(openTest(t, dir, nil))
+}
+
+func TestReplaceDelete(t *testing.T) {
+	r := openTest(t, t.TempDir(), nil)
+	put(t, r, newMeasurement("m1", t0, "1"), newMeasurement("m1", t0.Add(time.Minute), "2"))
+	put(t, r, newMeasurement("m1", t0, "3"))
+	if err := r.Delete(newMeasurement("m1", t0.Add(time.Minute), "")); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	all, err := r.GetAll()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(all) != 1 || all[0].Readings[0].Value != "3" {
+		t.Errorf("expected %v, received %v", "the replaced measurement", all)
+	}
+	if _, err := r.Get([]byte("key")); err != ErrBadArguments {
+		t.Errorf("expected %v, received %v", ErrBadArguments, err)
+	}
+}
+
+func TestTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	r := openTest(t, dir, nil)
+	put(t, r, newMeasurement("m1", t0, "1"), newMeasurement("m1", t0.Add(time.Minute), "2"))
+	r.Close()
+	names, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if len(names) != 1 {
+		t.Fatalf("expected %v, received %v", 1, names)
+	}
+	fi, _ := os.Stat(names[0])
+	// Cut the last record in half.
+	if err := os.Truncate(names[0], fi.Size()-10); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	r = openTest(t, dir, nil)
+	all, err := r.GetAll()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(all) != 1 {
+		t.Errorf("expected %v, received %v", 1, len(all))
+	}
+	// The next record follows the valid ones.
+	put(t, r, newMeasurement("m1", t0.Add(2*time.Minute), "3"))
+	r.Close()
+	r = openTest(t, dir, nil)
+	if all, _ := r.GetAll(); len(all) != 2 {
+		t.Errorf("expected %v, received %v", 2, len(all))
+	}
+}
+
+func TestSegmentDuration(t *testing.T) {
+	dir := t.TempDir()
+	openTest(t, dir, &Options{SegmentDuration: time.Hour}).Close()
+	if _, err := OpenWithOptions(dir, &Options{SegmentDuration: 2 * time.Hour}); !errors.Is(err, ErrBadArguments) {
+		t.Errorf("expected %v, received %v", ErrBadArguments, err)
+	}
+	if _, err := OpenWithOptions(t.TempDir(), &Options{SegmentDuration: 7 * time.Hour}); !errors.Is(err, ErrBadArguments) {
+		t.Errorf("expected %v, received %v", ErrBadArguments, err)
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	dir := t.TempDir()
+	r := openTest(t, dir, &Options{Outbox: true})
+	put(t, r, newMeasurement("m1", t0.Add(time.Hour), "1"), newMeasurement("m1", t0, "2"), newMeasurement("m1", t0.Add(2*time.Hour), "3"))
+	put(t, r, newMeasurement("m1", t0.Add(2*time.Hour), "4"))
+	keys, ms, err := r.Pending(2)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	// In the order they were stored.
+	if len(ms) != 2 || ms[0].Readings[0].Value != "1" || ms[1].Readings[0].Value != "2" {
+		t.Errorf("expected %v, received %v", "1 and 2", ms)
+	}
+	if err := r.Ack(keys); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	r.Close()
+	r = openTest(t, dir, &Options{Outbox: true})
+	if n, _ := r.Backlog(); n != 1 {
+		t.Errorf("expected %v, received %v", 1, n)
+	}
+	_, ms, _ = r.Pending(10)
+	if len(ms) != 1 || ms[0].Readings[0].Value != "4" {
+		t.Errorf("expected %v, received %v", "the replaced measurement", ms)
+	}
+}
+
+// TestDamagedMark opens a repo with an empty mark file, as left by a power
+// loss, everything is pending again.
+func TestDamagedMark(t *testing.T) {
+	dir := t.TempDir()
+	r := openTest(t, dir, &Options{Outbox: true})
+	put(t, r, newMeasurement("m1", t0, "1"), newMeasurement("m1", t0.Add(time.Hour), "2"))
+	keys, _, _ := r.Pending(1)
+	if err := r.Ack(keys); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	r.Close()
+	if err := os.WriteFile(filepath.Join(dir, markFile), nil, 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	r = openTest(t, dir, &Options{Outbox: true})
+	if n, _ := r.Backlog(); n != 2 {
+		t.Errorf("expected %v, received %v", 2, n)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	dir := t.TempDir()
+	r := openTest(t, dir, &Options{Retention: 48 * time.Hour, Outbox: true})
+	for i := 0; i < 5; i++ {
+		put(t, r, newMeasurement("m1", t0.Add(time.Duration(i)*24*time.Hour), "1"))
+	}
+	now := t0.Add(4 * 24 * time.Hour)
+	// Nothing has been uploaded.
+	if _, deleted, err := r.Compact(now); err != nil || deleted != 0 {
+		t.Errorf("expected %v, received %v, %v", 0, deleted, err)
+	}
+	keys, _, _ := r.Pending(10)
+	r.Ack(keys)
+	if _, deleted, err := r.Compact(now); err != nil || deleted != 2 {
+		t.Errorf("expected %v, received %v, %v", 2, deleted, err)
+	}
+	if all, _ := r.GetAll(); len(all) != 3 {
+		t.Errorf("expected %v, received %v", 3, len(all))
+	}
+	// New records after the removed ones are not taken as uploaded.
+	r.Close()
+	for _, name := range []string{"20200104T000000Z", "20200105T000000Z", "20200106T000000Z"} {
+		os.Remove(filepath.Join(dir, name+segmentExt))
+	}
+	r = openTest(t, dir, &Options{Outbox: true})
+	put(t, r, newMeasurement("m1", t0, "2"))
+	if n, _ := r.Backlog(); n != 1 {
+		t.Errorf("expected %v, received %v", 1, n)
+	}
+}
+
+// TestCompactNoOutbox removes the expired segments without an upload.
+func TestCompactNoOutbox(t *testing.T) {
+	r := openTest(t, t.TempDir(), &Options{Retention: 48 * time.Hour})
+	for i := 0; i < 5; i++ {
+		put(t, r, newMeasurement("m1", t0.Add(time.Duration(i)*24*time.Hour), "1"))
+	}
+	if n, _ := r.Backlog(); n != 0 {
+		t.Errorf("expected %v, received %v", 0, n)
+	}
+	if _, deleted, err := r.Compact(t0.Add(4 * 24 * time.Hour)); err != nil || deleted != 2 {
+		t.Errorf("expected %v, received %v, %v", 2, deleted, err)
+	}
+	if all, _ := r.GetAll(); len(all) != 3 {
+		t.Errorf("expected %v, received %v", 3, len(all))
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	r := openTest(t, t.TempDir(), &Options{NoSync: true})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if err := r.Put(newMeasurement("m1", t0.Add(time.Duration(i)*time.Hour), "1")); err != nil {
+				t.Errorf("unexpected error: %s", err.Error())
+				return
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		if _, err := r.GetRange("ISK/m1", t0, time.Time{}, 0); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if _, _, err := r.Pending(10); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	<-done
+	if all, _ := r.GetAll(); len(all) != 100 {
+		t.Errorf("expected %v, received %v", 100, len(all))
+	}
+}
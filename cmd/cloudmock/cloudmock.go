@@ -1,5 +1,152 @@
+// Command cloudmock is a local stand-in for the cloud service of the
+// cloudrepo package. It stores the posted measurements in a cache and serves
+// them with the same /measurements API, so the uploader of emlog can be
+// tested without network access.
+//
+// The credentials are read from the same CLOUDREPO_* environment variables
+// as emlog uses, see cloudrepo.CredentialsFromEnv. A token or HMAC key that
+// is set must be presented by every request. With --client-ca the mock
+// requires a client certificate signed by the CA.
+//
+// Outages, slow responses and rejections are simulated with the flags, or
+// changed while running with a PUT on /mock/faults, e.g.
+//
+//	curl -X PUT -d '{"outage":true,"retryAfter":5}' localhost:8090/mock/faults
 package main
 
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/adapters/cache"
+	"github.com/peterzandbergen/iec62056/adapters/cloudrepo"
+
+	"github.com/spf13/pflag"
+)
+
+// Options for the program.
+type options struct {
+	LocalCache string
+	ListenPort int
+	TLSCert    string
+	TLSKey     string
+	ClientCA   string
+	Outage     bool
+	FailRate   float64
+	RejectRate float64
+	Delay      time.Duration
+	RetryAfter int
+}
+
+func (o *options) Parse() {
+	if flag.Parsed() {
+		return
+	}
+	pflag.StringVarP(&o.LocalCache, "local-cache-path", "l", "/tmp/cloudmock-cache", "Location of the cache with the received measurements.")
+	pflag.IntVarP(&o.ListenPort, "port", "p", 8090, "Port to listen on.")
+	pflag.StringVar(&o.TLSCert, "tls-cert", "", "Certificate file of the server, enables HTTPS.")
+	pflag.StringVar(&o.TLSKey, "tls-key", "", "Key file of the server certificate.")
+	pflag.StringVar(&o.ClientCA, "client-ca", "", "CA file for the client certificates, requires mutual TLS.")
+	pflag.BoolVar(&o.Outage, "outage", false, "Fail all requests with 503.")
+	pflag.Float64Var(&o.FailRate, "fail-rate", 0, "Fraction of the requests that fail with 503.")
+	pflag.Float64Var(&o.RejectRate, "reject-rate", 0, "Fraction of the requests that are rejected with 400.")
+	pflag.DurationVar(&o.Delay, "delay", 0, "Delay of every response.")
+	pflag.IntVar(&o.RetryAfter, "retry-after", 0, "Retry-After seconds sent with the 503 responses.")
+	pflag.Parse()
+}
+
+func (o *options) faults() faults {
+	return faults{
+		Outage:     o.Outage,
+		FailRate:   o.FailRate,
+		RejectRate: o.RejectRate,
+		Delay:      duration(o.Delay),
+		RetryAfter: o.RetryAfter,
+	}
+}
+
+// tlsConfig returns the config that requires client certificates signed by
+// the CA in the file.
+func tlsConfig(caFile string) (*tls.Config, error) {
+	b, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, errors.New("no certificates found in " + caFile)
+	}
+	return &tls.Config{
+		ClientCAs:  pool,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	}, nil
+}
+
 func main() {
-	
-}
\ No newline at end of file
+	log.Println("Starting cloudmock")
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+
+	o := &options{}
+	o.Parse()
+
+	repo, err := cache.Open(o.LocalCache)
+	if err != nil {
+		log.Printf("error opening the cache: %s", err.Error())
+		os.Exit(1)
+	}
+	defer repo.Close()
+
+	creds, err := cloudrepo.CredentialsFromEnv()
+	if err != nil {
+		log.Printf("error reading the credentials: %s", err.Error())
+		os.Exit(1)
+	}
+	verifier := cloudrepo.NewVerifier(creds)
+	verifier.RequireClientCert = len(o.ClientCA) > 0
+
+	srv := &http.Server{
+		Addr:    ":" + strconv.Itoa(o.ListenPort),
+		Handler: newServer(repo, verifier, o.faults()).handler(),
+	}
+	if verifier.RequireClientCert {
+		if len(o.TLSCert) == 0 {
+			log.Print("--client-ca requires --tls-cert and --tls-key")
+			os.Exit(1)
+		}
+		if srv.TLSConfig, err = tlsConfig(o.ClientCA); err != nil {
+			log.Printf("error reading the client CA: %s", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	go func() {
+		var err error
+		log.Printf("Listening on %s", srv.Addr)
+		if len(o.TLSCert) > 0 {
+			err = srv.ListenAndServeTLS(o.TLSCert, o.TLSKey)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			log.Printf("error serving: %s", err.Error())
+			os.Exit(1)
+		}
+	}()
+
+	sig := <-c
+	log.Printf("Received signal: %s\n", sig.String())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.Shutdown(ctx)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/adapters/cache"
+	"github.com/peterzandbergen/iec62056/adapters/cloudrepo"
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+// Paths of the mock.
+const (
+	measurementsPath = "/measurements"
+	faultsPath       = "/mock/faults"
+)
+
+// maxBody is the maximum size of a posted body.
+const maxBody = 16 << 20
+
+var errBadMeter = errors.New("meter parameter must be manufacturer/identification")
+
+// faults to simulate. They can be changed while the mock runs with a PUT of
+// the JSON on /mock/faults.
+type faults struct {
+	// Outage fails all requests with 503 Service Unavailable.
+	Outage bool `json:"outage"`
+	// FailRate is the fraction of requests that fail with 503.
+	FailRate float64 `json:"failRate"`
+	// RejectRate is the fraction of requests that are rejected with 400.
+	RejectRate float64 `json:"rejectRate"`
+	// Delay is added to every request, e.g. "2s".
+	Delay duration `json:"delay"`
+	// RetryAfter in seconds is sent with the 503 responses when not 0.
+	RetryAfter int `json:"retryAfter"`
+}
+
+// duration marshals as a Go duration string.
+type duration time.Duration
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// server implements the measurements API of the cloud service on a cache.
+type server struct {
+	repo     *cache.Cache
+	verifier *cloudrepo.Verifier
+
+	lock   sync.Mutex
+	faults faults
+	rand   *rand.Rand
+	// received counts the stored measurements, including duplicates.
+	received int
+}
+
+func newServer(repo *cache.Cache, verifier *cloudrepo.Verifier, f faults) *server {
+	return &server{
+		repo:     repo,
+		verifier: verifier,
+		faults:   f,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// handler returns the routes of the mock.
+func (s *server) handler() http.Handler {
+	sm := http.NewServeMux()
+	var api http.Handler = http.HandlerFunc(s.serveMeasurements)
+	if s.verifier != nil {
+		api = s.verifier.Handler(api)
+	}
+	sm.Handle(measurementsPath, s.inject(api))
+	sm.Handle(measurementsPath+"/", s.inject(api))
+	sm.HandleFunc(faultsPath, s.serveFaults)
+	return sm
+}
+
+// inject applies the faults before the request reaches h.
+func (s *server) inject(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		f := s.faults
+		fail := f.Outage || s.rand.Float64() < f.FailRate
+		reject := !fail && s.rand.Float64() < f.RejectRate
+		s.lock.Unlock()
+
+		if f.Delay > 0 {
+			select {
+			case <-time.After(time.Duration(f.Delay)):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		switch {
+		case fail:
+			if f.RetryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(f.RetryAfter))
+			}
+			http.Error(w, "simulated outage", http.StatusServiceUnavailable)
+		case reject:
+			http.Error(w, "simulated rejection", http.StatusBadRequest)
+		default:
+			h.ServeHTTP(w, r)
+		}
+	})
+}
+
+func (s *server) serveFaults(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		f := faults{}
+		if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.lock.Lock()
+		s.faults = f
+		s.lock.Unlock()
+		log.Printf("faults changed: %+v", f)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.lock.Lock()
+	f := s.faults
+	s.lock.Unlock()
+	writeJSON(w, http.StatusOK, &f)
+}
+
+func (s *server) serveMeasurements(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case r.Method == http.MethodPost && p == measurementsPath:
+		s.post(w, r)
+	case r.Method == http.MethodGet && p == measurementsPath:
+		s.getPage(w, r)
+	case r.Method == http.MethodGet && p == measurementsPath+"/first":
+		s.getFirstLast(w, model.First)
+	case r.Method == http.MethodGet && p == measurementsPath+"/last":
+		s.getFirstLast(w, model.Last)
+	case r.Method == http.MethodDelete && p == measurementsPath:
+		s.delete(w, r)
+	case p == measurementsPath || p == measurementsPath+"/first" || p == measurementsPath+"/last":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// post stores the measurements. Storing a measurement again overwrites it,
+// so repeated uploads are harmless.
+func (s *server) post(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(io.LimitReader(r.Body, maxBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mm, err := cloudrepo.DecodeMeasurements(b)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, m := range mm {
+		if err := s.repo.Put(m.Model()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	s.lock.Lock()
+	s.received += len(mm)
+	s.lock.Unlock()
+	log.Printf("stored %d measurements", len(mm))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *server) getPage(w http.ResponseWriter, r *http.Request) {
+	var mm []*model.Measurement
+	var err error
+	size, _ := strconv.Atoi(r.FormValue("size"))
+	meter, from, to := r.FormValue("meter"), r.FormValue("from"), r.FormValue("to")
+	if len(meter) > 0 || len(from) > 0 || len(to) > 0 {
+		var tf, tt time.Time
+		if len(from) > 0 {
+			if tf, err = time.Parse(time.RFC3339Nano, from); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if len(to) > 0 {
+			if tt, err = time.Parse(time.RFC3339Nano, to); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		mm, err = s.repo.GetRange(meter, tf, tt, size)
+		if err == cache.ErrBadArguments {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if size > 0 {
+		page, _ := strconv.Atoi(r.FormValue("page"))
+		mm, err = s.repo.GetPage(page, size)
+		if err == cache.ErrNoElements {
+			mm, err = nil, nil
+		}
+	} else {
+		mm, err = s.repo.GetAll()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res := &cloudrepo.MeasurementsResource{Data: make([]*cloudrepo.Measurement, 0, len(mm))}
+	for _, m := range mm {
+		res.Data = append(res.Data, cloudrepo.NewMeasurement(m))
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *server) getFirstLast(w http.ResponseWriter, key string) {
+	var res struct {
+		Data *cloudrepo.Measurement `json:"data"`
+	}
+	// An empty cache has no first or last.
+	if _, err := s.repo.GetPage(0, 1); err == nil {
+		m, err := s.repo.Get([]byte(key))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.Data = cloudrepo.NewMeasurement(m)
+	}
+	writeJSON(w, http.StatusOK, &res)
+}
+
+func (s *server) delete(w http.ResponseWriter, r *http.Request) {
+	meter := strings.SplitN(r.FormValue("meter"), "/", 2)
+	if len(meter) != 2 {
+		http.Error(w, errBadMeter.Error(), http.StatusBadRequest)
+		return
+	}
+	t, err := time.Parse(time.RFC3339Nano, r.FormValue("time"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m := &model.Measurement{Time: t.UTC(), ManufacturerID: meter[0], Identification: meter[1]}
+	if err := s.repo.Delete(m); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("internal error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(b)
+}
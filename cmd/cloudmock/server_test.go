@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/adapters/cache"
+	"github.com/peterzandbergen/iec62056/adapters/cloudrepo"
+	"github.com/peterzandbergen/iec62056/model"
+	"github.com/peterzandbergen/iec62056/model/repotest"
+)
+
+func newTestServer(t *testing.T, creds *cloudrepo.Credentials) (*server, *cloudrepo.CloudRepo) {
+	repo, err := cache.Open(filepath.Join(t.TempDir(), "cache"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	t.Cleanup(func() { repo.Close() })
+	s := newServer(repo, cloudrepo.NewVerifier(creds), faults{})
+	ts := httptest.NewServer(s.handler())
+	t.Cleanup(ts.Close)
+	c, err := cloudrepo.New(ts.URL + "/measurements")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	c.Credentials = creds
+	c.MaxRetries = -1
+	return s, c
+}
+
+func testMeasurements() []*model.Measurement {
+	t0 := time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)
+	var mm []*model.Measurement
+	for i := 0; i < 3; i++ {
+		mm = append(mm, &model.Measurement{
+			Time:           t0.Add(time.Duration(i) * time.Minute),
+			ManufacturerID: "ISK",
+			Identification: "meter1",
+			Readings:       []model.DataSet{{Address: "1.8.1", Value: "000051.394", Unit: "kWh"}},
+		})
+	}
+	return mm
+}
+
+// TestConformance runs the conformance tests on the cloud repo, with the
+// mock as the service.
+func TestConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) model.MeasurementRepo {
+		_, c := newTestServer(t, &cloudrepo.Credentials{Token: "token"})
+		return c
+	})
+}
+
+func TestRoundTrip(t *testing.T) {
+	creds := &cloudrepo.Credentials{Token: "token", HMACKey: []byte("key")}
+	_, c := newTestServer(t, creds)
+	mm := testMeasurements()
+	if err := c.PutBatch(context.Background(), mm); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	// Idempotent.
+	if err := c.PutBatch(context.Background(), mm); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	all, err := c.GetAll()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(all) != len(mm) {
+		t.Errorf("expected %v, received %v", len(mm), len(all))
+	}
+	page, err := c.GetPage(1, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(page) != 1 {
+		t.Errorf("expected %v, received %v", 1, len(page))
+	}
+	last, err := c.Get([]byte(model.Last))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if !last.Time.Equal(mm[2].Time) {
+		t.Errorf("expected %v, received %v", mm[2].Time, last.Time)
+	}
+	rng, err := c.GetRange("ISK/meter1", mm[1].Time, mm[2].Time, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(rng) != 1 || !rng[0].Time.Equal(mm[1].Time) {
+		t.Errorf("expected %v, received %v", mm[1].Time, rng)
+	}
+	if err := c.Delete(mm[0]); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if all, _ = c.GetAll(); len(all) != 2 {
+		t.Errorf("expected %v, received %v", 2, len(all))
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	_, c := newTestServer(t, &cloudrepo.Credentials{})
+	if _, err := c.Get([]byte(model.First)); err != cloudrepo.ErrNoElements {
+		t.Errorf("expected %v, received %v", cloudrepo.ErrNoElements, err)
+	}
+	if _, err := c.GetPage(0, 10); err != cloudrepo.ErrNoElements {
+		t.Errorf("expected %v, received %v", cloudrepo.ErrNoElements, err)
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	_, c := newTestServer(t, &cloudrepo.Credentials{Token: "token", HMACKey: []byte("key")})
+	c.Credentials = &cloudrepo.Credentials{Token: "token", HMACKey: []byte("wrong")}
+	err := c.Put(testMeasurements()[0])
+	var se *cloudrepo.StatusError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected %v, received %v", http.StatusUnauthorized, err)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	s, c := newTestServer(t, &cloudrepo.Credentials{})
+
+	s.faults = faults{Outage: true, RetryAfter: 1}
+	if err := c.Put(testMeasurements()[0]); !errors.Is(err, model.ErrUnavailable) {
+		t.Errorf("expected %v, received %v", model.ErrUnavailable, err)
+	}
+	s.faults = faults{RejectRate: 1}
+	if err := c.Put(testMeasurements()[0]); !errors.Is(err, cloudrepo.ErrRejected) {
+		t.Errorf("expected %v, received %v", cloudrepo.ErrRejected, err)
+	}
+	s.faults = faults{Delay: duration(50 * time.Millisecond)}
+	start := time.Now()
+	if err := c.Put(testMeasurements()[0]); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("expected a delay of %v, received %v", 50*time.Millisecond, d)
+	}
+}
+
+func TestPutFaults(t *testing.T) {
+	s, _ := newTestServer(t, &cloudrepo.Credentials{})
+	r := httptest.NewRequest(http.MethodPut, faultsPath, strings.NewReader(`{"outage":true,"delay":"2s"}`))
+	w := httptest.NewRecorder()
+	s.handler().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %v, received %v", http.StatusOK, w.Code)
+	}
+	if !s.faults.Outage || time.Duration(s.faults.Delay) != 2*time.Second {
+		t.Errorf("expected %v, received %+v", "outage with delay 2s", s.faults)
+	}
+}
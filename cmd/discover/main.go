@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/adapters/meter"
+	"github.com/peterzandbergen/iec62056/iec"
+	"github.com/peterzandbergen/iec62056/iec/portmgr"
+
+	"github.com/spf13/pflag"
+)
+
+// Options for the program.
+type options struct {
+	Probe      bool
+	USBOnly    bool
+	Candidates []string
+	Timeout    int
+	Save       string
+}
+
+func (o *options) Parse() {
+	if flag.Parsed() {
+		return
+	}
+	pflag.BoolVarP(&o.Probe, "probe", "p", true, "Probe each port for a meter.")
+	pflag.BoolVarP(&o.USBOnly, "usb-only", "u", true, "Only list USB serial adapters.")
+	pflag.StringSliceVarP(&o.Candidates, "candidate", "c", nil, "Candidate to try as protocol:baudrate:framing, e.g. p1:115200:8N1. Defaults to the common meter configurations.")
+	pflag.IntVarP(&o.Timeout, "timeout", "t", 15, "Time in seconds to wait for a response per candidate.")
+	pflag.StringVarP(&o.Save, "save", "o", "", "Save the inventory to this file.")
+	pflag.Parse()
+}
+
+func discover(o *options, candidates []iec.ProbeCandidate) (*meter.Inventory, error) {
+	ports, err := iec.ListPorts()
+	if err != nil {
+		return nil, err
+	}
+	inv := &meter.Inventory{}
+	// Skips the ports owned by another process, e.g. emlog.
+	pm := portmgr.New()
+	defer pm.Close()
+	for _, pi := range ports {
+		if o.USBOnly && !pi.IsUSB {
+			continue
+		}
+		e := meter.NewInventoryEntry(pi)
+		inv.Entries = append(inv.Entries, e)
+		if !o.Probe {
+			continue
+		}
+		log.Printf("probing %s", e.Port)
+		ps := iec.NewDefaultSettings()
+		ps.PortName = pi.Name
+		var settings *iec.PortSettings
+		var results []*iec.ProbeResult
+		err := pm.Do(context.Background(), pi.Name, portmgr.ClassProbe, func(ctx context.Context) error {
+			var err error
+			settings, results, err = iec.Probe(ps, candidates, time.Duration(o.Timeout)*time.Second)
+			return err
+		})
+		if err != nil {
+			log.Printf("%s: %s", e.Port, err.Error())
+			continue
+		}
+		r := results[len(results)-1]
+		e.ManufacturerID = r.ManufacturerID
+		e.MeterID = r.MeterID
+		e.Settings = settings
+	}
+	return inv, nil
+}
+
+func printInventory(inv *meter.Inventory) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PORT\tDEVICE\tVID:PID\tSERIAL\tMETER\tPROTOCOL")
+	for _, e := range inv.Entries {
+		m, p := "-", "-"
+		if e.HasMeter() {
+			m = e.ManufacturerID + " " + e.MeterID
+			p = iec.ProbeCandidate{
+				Protocol: e.Settings.Protocol,
+				BaudRate: e.Settings.InitialBaudRateModeABC,
+				Framing:  e.Settings.Framing,
+			}.String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s:%s\t%s\t%s\t%s\n", e.Port, e.Device, e.VID, e.PID, e.SerialNumber, m, p)
+	}
+	w.Flush()
+}
+
+func main() {
+	o := &options{}
+	o.Parse()
+
+	var candidates []iec.ProbeCandidate
+	for _, s := range o.Candidates {
+		c, err := iec.ParseProbeCandidate(s)
+		if err != nil {
+			log.Fatal(err)
+		}
+		candidates = append(candidates, c)
+	}
+
+	inv, err := discover(o, candidates)
+	if err != nil {
+		log.Fatalf("cannot list the serial ports: %s", err.Error())
+	}
+	printInventory(inv)
+	if len(o.Save) > 0 {
+		if err := meter.SaveInventory(o.Save, inv); err != nil {
+			log.Fatalf("cannot save the inventory: %s", err.Error())
+		}
+		log.Printf("saved inventory to %s", o.Save)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -73,17 +74,55 @@ var (
 	ErrGetFailed = errors.New("http get failed, status code was other than 200")
 )
 
-func getPage(page int) ([]byte, error) {
-	u := getBaseUrl() + "&page=" + strconv.Itoa(page)
-	resp, err := http.Get(u)
+// pageResponse holds the paging fields of the MeasurementsResponse.
+type pageResponse struct {
+	Data []json.RawMessage
+	Next string
+}
+
+// getPage returns the content of the page at u and the URL of the next
+// page, nil at the end. Without a next link, e.g. from a repo without
+// cursors, a full page is followed by the next page number.
+func getPage(u *url.URL) ([]byte, *url.URL, error) {
+	resp, err := http.Get(u.String())
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return nil, ErrGetFailed
+		return nil, nil, ErrGetFailed
 	}
-	defer resp.Body.Close()
-	return ioutil.ReadAll(resp.Body)
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	var pr pageResponse
+	if err := json.Unmarshal(b, &pr); err != nil {
+		return nil, nil, err
+	}
+	if len(pr.Next) > 0 {
+		next, err := u.Parse(pr.Next)
+		if err != nil {
+			return nil, nil, err
+		}
+		return b, next, nil
+	}
+	return b, nextPage(u, len(pr.Data)), nil
+}
+
+// nextPage returns the URL of the page after u if u returned a full page
+// of n measurements, nil otherwise.
+func nextPage(u *url.URL, n int) *url.URL {
+	q := u.Query()
+	size, _ := strconv.Atoi(q.Get("size"))
+	if size <= 0 || n < size || len(q.Get("cursor")) > 0 {
+		return nil
+	}
+	page, _ := strconv.Atoi(q.Get("page"))
+	q.Set("page", strconv.Itoa(page+1))
+	next := *u
+	next.RawQuery = q.Encode()
+	return &next
 }
 
 func writePage(page int, content []byte) error {
@@ -91,13 +130,17 @@ func writePage(page int, content []byte) error {
 	return ioutil.WriteFile(fn, content, os.ModePerm)
 }
 
+// export follows the next links of the pages, or the page numbers, until
+// the last page.
 func export() error {
-	for page := 0; ; page++ {
-		c, err := getPage(page)
+	u, err := url.Parse(getBaseUrl())
+	if err != nil {
+		return err
+	}
+	for page := 0; u != nil; page++ {
+		var c []byte
+		c, u, err = getPage(u)
 		if err != nil {
-			if err == EOF {
-				return nil
-			}
 			return err
 		}
 		log.Printf("getPage: page: %d, %d bytes", page, len(c))
@@ -106,6 +149,7 @@ func export() error {
 			return err
 		}
 	}
+	return nil
 }
 
 func main() {
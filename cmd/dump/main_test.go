@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestNextPage(t *testing.T) {
+	tests := []struct {
+		url      string
+		n        int
+		expected string
+	}{
+		{"http://h/measurements/?size=2", 2, "http://h/measurements/?page=1&size=2"},
+		{"http://h/measurements/?page=1&size=2", 2, "http://h/measurements/?page=2&size=2"},
+		{"http://h/measurements/?page=2&size=2", 1, ""},
+		{"http://h/measurements/?cursor=x&size=2", 2, ""},
+	}
+	for _, tc := range tests {
+		u, _ := url.Parse(tc.url)
+		next := nextPage(u, tc.n)
+		received := ""
+		if next != nil {
+			received = next.String()
+		}
+		if received != tc.expected {
+			t.Errorf("%s: expected %v, received %v", tc.url, tc.expected, received)
+		}
+	}
+}
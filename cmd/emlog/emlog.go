@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
@@ -12,7 +15,10 @@ import (
 	"github.com/peterzandbergen/iec62056/adapters/meter"
 	"github.com/peterzandbergen/iec62056/actors"
 	"github.com/peterzandbergen/iec62056/adapters/cache"
+	"github.com/peterzandbergen/iec62056/adapters/cloudrepo"
 	"github.com/peterzandbergen/iec62056/iec"
+	"github.com/peterzandbergen/iec62056/iec/portmgr"
+	"github.com/peterzandbergen/iec62056/adapters/segment"
 	"github.com/peterzandbergen/iec62056/service"
 	
 	"github.com/spf13/pflag"
@@ -23,9 +29,18 @@ type options struct {
 	DumpCache        bool
 	Baudrate         int
 	Portname         string
+	PortSettings     string
+	Inventory        string
+	MeterID          string
+	Quirks           string
 	LocalCache       string
+	Storage          string
+	CacheEncoding    string
+	Retention        string
 	RemoteStorageURI string
+	UploadInterval   int
 	Interval         int
+	SnapshotURL      string
 }
 
 func (o *options) Parse() {
@@ -35,30 +50,74 @@ func (o *options) Parse() {
 	pflag.BoolVarP(&o.DumpCache, "show-cache", "D", false, "Dump the content of the cache.")
 	pflag.IntVarP(&o.Baudrate, "baudrate", "b", 300, "Baudrate of the serial port connected to the energy meter.")
 	pflag.StringVarP(&o.Portname, "serial-port", "s", "/dev/ttyUSB0", "Device name of the serial port.")
+	pflag.StringVarP(&o.PortSettings, "port-settings", "P", "", "Port settings file, e.g. saved by the probe command. Overrides baudrate.")
+	pflag.StringVarP(&o.Inventory, "inventory", "i", "", "Inventory file saved by the discover command, used with meter-id.")
+	pflag.StringVarP(&o.MeterID, "meter-id", "m", "", "Read the meter with this ID from the inventory, using its stable port name and settings.")
+	pflag.StringVarP(&o.Quirks, "quirks", "q", "", `Manufacturer quirks file, a JSON object keyed by manufacturer ID, e.g. {"ABC": {"WakeUp": "0000000000"}}. There are no built-in quirks.`)
 	pflag.StringVarP(&o.LocalCache, "local-cache-path", "l", "/tmp/emlog-cache", "Location of the local cache.")
-	pflag.StringVarP(&o.RemoteStorageURI, "remote-storage-uri", "R", "http://localhost:304725/emeterlog", "Remote Storage Service URI.")
+	pflag.StringVar(&o.Storage, "storage", "cache", "Storage of the local cache: cache for LevelDB or segments for append-only files that survive power loss.")
+	pflag.StringVar(&o.CacheEncoding, "cache-encoding", "compact", "Encoding of the cached measurements: compact, snappy or json. Older records are converted in the background.")
+	pflag.StringVar(&o.Retention, "retention", "", "Retention per resolution of the cached measurements, e.g. raw=30d,15m=2y,1d=forever. Nothing is deleted when empty.")
+	pflag.StringVarP(&o.RemoteStorageURI, "remote-storage-uri", "R", "", "Remote Storage Service URI, the measurements are uploaded when set. The credentials are read from the CLOUDREPO_* environment variables.")
+	pflag.IntVar(&o.UploadInterval, "upload-interval", 60, "Interval in seconds between uploads to the remote storage.")
 	pflag.IntVarP(&o.Interval, "interval", "I", 300, "Interval for each measurement in seconds.")
+	pflag.StringVar(&o.SnapshotURL, "snapshot-url", "http://localhost:8080/snapshot", "Snapshot endpoint of the running emlog, used by the snapshot command when the cache is in use.")
 
 	pflag.Parse()
 }
 
-func buildTimerHandler(meterRepo, localRepo model.MeasurementRepo) service.TimerHandler {
+// buildTimerHandler returns the handler that stores a measurement from the
+// meter and then calls stored, if set.
+func buildTimerHandler(meterRepo, localRepo model.MeasurementRepo, stored func()) service.TimerHandler {
 	return service.TimerHandleFunc(func(t time.Time) {
 		a := actors.IecMessageHandler{
 			LocalRepo: localRepo,
 			MeterRepo: meterRepo,
 		}
-		a.Do()
+		if err := a.Do(); err == nil && stored != nil {
+			stored()
+		}
 	})
 }
 
 func buildMeterRepo(options *options) *meter.Meter {
+	var serialNumber string
+	if len(options.Quirks) > 0 {
+		if err := iec.DefaultQuirks.Load(options.Quirks); err != nil {
+			log.Printf("cannot read the quirks: %s", err.Error())
+			return nil
+		}
+	}
 	ps := iec.NewDefaultSettings()
-	ps.PortName = options.Portname
 	ps.InitialBaudRateModeABC = options.Baudrate
+	if len(options.MeterID) > 0 {
+		inv, err := meter.LoadInventory(options.Inventory)
+		if err != nil {
+			log.Printf("cannot read the inventory: %s", err.Error())
+			return nil
+		}
+		e, err := inv.Find(options.MeterID)
+		if err != nil {
+			log.Printf("%s: %s", options.MeterID, err.Error())
+			return nil
+		}
+		ps = e.PortSettings()
+		serialNumber = e.SerialNumber
+	} else if len(options.PortSettings) > 0 {
+		var err error
+		if ps, err = iec.LoadSettings(options.PortSettings); err != nil {
+			log.Printf("cannot read the port settings: %s", err.Error())
+			return nil
+		}
+	}
+	// The port from the settings file wins over the default port.
+	if len(ps.PortName) == 0 || pflag.CommandLine.Changed("serial-port") {
+		ps.PortName = options.Portname
+	}
 	mr := &meter.Meter{
-		PortName:     options.Portname,
+		PortName:     ps.PortName,
 		PortSettings: ps,
+		SerialNumber: serialNumber,
 	}
 	return mr
 }
@@ -74,11 +133,41 @@ func main() {
 	o := &options{}
 	o.Parse()
 
+	// The credentials are never passed as flags, see cloudrepo.CredentialsFromEnv.
+	creds, err := cloudrepo.CredentialsFromEnv()
+	if err != nil {
+		log.Printf("error reading the credentials: %s", err.Error())
+		os.Exit(1)
+	}
+
+	switch pflag.Arg(0) {
+	case "":
+	case "snapshot":
+		// emlog snapshot [file]
+		if err := snapshot(o, creds, pflag.Arg(1)); err != nil {
+			log.Printf("snapshot failed: %s", err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "reindex":
+		// emlog reindex
+		if err := reindex(o); err != nil {
+			log.Printf("reindex failed: %s", err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	default:
+		log.Printf("unknown command %s, expected snapshot or reindex", pflag.Arg(0))
+		os.Exit(1)
+	}
+
 	// Create the repositories.
 	// Local cache.
-	localRepo, err := cache.Open(o.LocalCache)
+	// The outbox keeps the measurements until the upload is acknowledged.
+	localRepo, err := openLocalRepo(o)
 	if err != nil {
 		// Log and exit.
+		log.Printf("cannot open the local cache: %s", err.Error())
 		os.Exit(1)
 	}
 	defer localRepo.Close()
@@ -89,17 +178,77 @@ func main() {
 		log.Println("cannot open the meter repo")
 		os.Exit(1)
 	}
+	// The port manager serialises the timer and on-demand reads.
+	portManager := portmgr.New()
+	defer portManager.Close()
+	meterRepo.Manager = portManager
 
 	// Create the services.
 
-	// The measurement service.
-	timerSvc := service.NewTimer(time.Duration(o.Interval)*time.Second, buildTimerHandler(meterRepo, localRepo))
-	_ = timerSvc
+	// The save to cloud service.
+	var uploader *service.Uploader
+	if len(o.RemoteStorageURI) > 0 {
+		remote, err := cloudrepo.New(o.RemoteStorageURI)
+		if err != nil {
+			log.Printf("bad remote storage uri: %s", err.Error())
+			os.Exit(1)
+		}
+		remote.Credentials = creds
+		remote.Client = creds.HTTPClient()
+		uploader = &service.Uploader{
+			Outbox:   localRepo,
+			Sink:     remote,
+			Interval: time.Duration(o.UploadInterval) * time.Second,
+		}
+	}
 
-	// TODO: The status REST service.
-	localRestSvc := service.NewHttpLocalService("0.0.0.0:8080", localRepo)
+	// The measurement service, a new measurement is uploaded right away.
+	var stored func()
+	if uploader != nil {
+		stored = uploader.Notify
+	}
+	timerSvc := service.NewTimer(time.Duration(o.Interval)*time.Second, buildTimerHandler(meterRepo, localRepo, stored))
 
-	// TODO: The save to cloud service.
+	// TODO: The status REST service.
+	routes := []service.Route{
+		service.Route{
+			Pattern: "/meter/read",
+			Handler: &service.MeterReadHandler{
+				Read: func(ctx context.Context) (*model.Measurement, error) {
+					return meterRepo.Read(ctx, portmgr.ClassOnDemand)
+				},
+				Repo: localRepo,
+			},
+		},
+		service.Route{
+			Pattern: "/meter/status",
+			Handler: &service.StatusHandler{
+				Status: func() interface{} { return meterRepo.Status() },
+			},
+		},
+	}
+	if snapshotter, ok := localRepo.(service.Snapshotter); !ok {
+		log.Printf("snapshots disabled, the %s storage does not support them", o.Storage)
+	} else if len(creds.Token) > 0 || len(creds.HMACKey) > 0 {
+		routes = append(routes, service.Route{
+			Pattern: "GET /snapshot",
+			Handler: cloudrepo.NewVerifier(creds).Handler(&service.SnapshotHandler{Repo: snapshotter}),
+		})
+	} else {
+		log.Printf("snapshots disabled, %s or %s is not set", cloudrepo.EnvToken, cloudrepo.EnvHMACKey)
+	}
+	if series, ok := localRepo.(model.SeriesWalker); ok {
+		routes = append(routes, service.Route{Pattern: "GET /series", Handler: &service.SeriesHandler{Repo: series}})
+	}
+	if uploader != nil {
+		routes = append(routes, service.Route{
+			Pattern: "/uploader/status",
+			Handler: &service.StatusHandler{
+				Status: func() interface{} { return uploader.Status() },
+			},
+		})
+	}
+	localRestSvc := service.NewHttpLocalService("0.0.0.0:8080", localRepo, routes...)
 
 	if o.DumpCache {
 		// Create and start the CacheDumper.
@@ -112,7 +261,14 @@ func main() {
 	}
 
 	// Create services list.
-	services := service.NewServicesList(timerSvc, localRestSvc)
+	svcs := []service.Service{timerSvc, localRestSvc, &service.Compactor{Repo: localRepo}}
+	if r, ok := localRepo.(service.Reencodable); ok {
+		svcs = append(svcs, &service.Reencoder{Repo: r})
+	}
+	if uploader != nil {
+		svcs = append(svcs, uploader)
+	}
+	services := service.NewServicesList(svcs...)
 	// services := service.NewServicesList(localRestSvc)
 
 	if err := services.Start(context.Background()); err != nil {
@@ -131,3 +287,148 @@ func main() {
 
 	log.Println("Services stopped")
 }
+
+// localStore is the local cache, a cache.Cache or a segment.Repo.
+type localStore interface {
+	model.MeasurementRepo
+	service.Outbox
+	service.Compactable
+	Close() error
+}
+
+// openLocalRepo opens the local cache in the storage of the options.
+func openLocalRepo(o *options) (localStore, error) {
+	policies, err := cache.ParsePolicies(o.Retention)
+	if err != nil {
+		return nil, err
+	}
+	switch o.Storage {
+	case "cache":
+		return openCache(o)
+	case "segments":
+		// The segments only expire, they have no rollups.
+		so := &segment.Options{Outbox: len(o.RemoteStorageURI) > 0}
+		for _, p := range policies {
+			if p.Resolution > 0 {
+				return nil, errors.New("the segments storage has no rollups, only a raw retention")
+			}
+			so.Retention = p.Retention
+		}
+		return segment.OpenWithOptions(o.LocalCache, so)
+	}
+	return nil, fmt.Errorf("unknown storage %s, expected cache or segments", o.Storage)
+}
+
+// openCache opens the local cache with the options of the logger.
+func openCache(o *options) (*cache.Cache, error) {
+	co, err := cacheOptions(o)
+	if err != nil {
+		return nil, err
+	}
+	return cache.OpenWithOptions(o.LocalCache, co)
+}
+
+// maintenanceOptions returns the options of the local cache for the
+// commands. They fail on a missing cache and leave the upload queue as the
+// logger left it, with or without --remote-storage-uri.
+func maintenanceOptions(o *options) (*cache.Options, error) {
+	co, err := cacheOptions(o)
+	if err != nil {
+		return nil, err
+	}
+	co.KeepOutboxState = true
+	co.ErrorIfMissing = true
+	return co, nil
+}
+
+// cacheOptions returns the options of the local cache.
+func cacheOptions(o *options) (*cache.Options, error) {
+	policies, err := cache.ParsePolicies(o.Retention)
+	if err != nil {
+		return nil, err
+	}
+	encoding, err := cache.ParseEncoding(o.CacheEncoding)
+	if err != nil {
+		return nil, fmt.Errorf("bad cache encoding %s, expected compact, snappy or json", o.CacheEncoding)
+	}
+	return &cache.Options{
+		Outbox:   len(o.RemoteStorageURI) > 0,
+		Encoding: encoding,
+		Policies: policies,
+	}, nil
+}
+
+// snapshot writes a snapshot of the local cache to the file, stdout if
+// empty or "-". The cache is opened directly when emlog is not running,
+// otherwise the snapshot is downloaded from the running emlog. A file is
+// written under a temporary name and renamed when the snapshot is complete.
+func snapshot(o *options, creds *cloudrepo.Credentials, filename string) error {
+	var w io.Writer = os.Stdout
+	var f *os.File
+	if len(filename) > 0 && filename != "-" {
+		var err error
+		if f, err = os.Create(filename + ".tmp"); err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		w = f
+	}
+	if o.Storage != "cache" {
+		return fmt.Errorf("the %s storage has no snapshots, its files are only appended and can be copied", o.Storage)
+	}
+	co, err := maintenanceOptions(o)
+	if err != nil {
+		return err
+	}
+	if repo, err := cache.OpenWithOptions(o.LocalCache, co); err == nil {
+		n, err := repo.Snapshot(w)
+		repo.Close()
+		if err != nil {
+			return err
+		}
+		log.Printf("wrote %d records", n)
+	} else {
+		log.Printf("cannot open the local cache (%s), downloading from %s", err.Error(), o.SnapshotURL)
+		resp, err := creds.Get(context.Background(), o.SnapshotURL)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if _, err := io.Copy(w, resp.Body); err != nil {
+			return err
+		}
+	}
+	if f == nil {
+		return nil
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
+
+// reindex rebuilds the series indexes of the local cache. The cache must
+// not be in use by a running emlog.
+func reindex(o *options) error {
+	if o.Storage != "cache" {
+		return fmt.Errorf("the %s storage has no series indexes", o.Storage)
+	}
+	co, err := maintenanceOptions(o)
+	if err != nil {
+		return err
+	}
+	// The indexes are built once, by RebuildSeries.
+	co.NoSeriesBuild = true
+	repo, err := cache.OpenWithOptions(o.LocalCache, co)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+	n, err := repo.RebuildSeries()
+	if err != nil {
+		return err
+	}
+	log.Printf("indexed %d measurements", n)
+	return nil
+}
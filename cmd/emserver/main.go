@@ -2,15 +2,21 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/peterzandbergen/iec62056/actors"
 	"github.com/peterzandbergen/iec62056/adapters/cache"
+	"github.com/peterzandbergen/iec62056/adapters/cloudrepo"
+	"github.com/peterzandbergen/iec62056/adapters/segment"
 	"github.com/peterzandbergen/iec62056/model"
 	"github.com/peterzandbergen/iec62056/service"
 
@@ -19,8 +25,12 @@ import (
 
 // Options for the program.
 type options struct {
-	LocalCache string
-	ListenPort int
+	LocalCache    string
+	Storage       string
+	CacheEncoding string
+	Retention     string
+	ListenPort    int
+	Ingest        bool
 }
 
 func (o *options) Parse() {
@@ -28,7 +38,11 @@ func (o *options) Parse() {
 		return
 	}
 	pflag.StringVarP(&o.LocalCache, "local-cache-path", "l", "/tmp/emlog-cache", "Location of the local cache.")
+	pflag.StringVar(&o.Storage, "storage", "cache", "Storage of the measurements: cache for LevelDB or segments for append-only files.")
+	pflag.StringVar(&o.CacheEncoding, "cache-encoding", "compact", "Encoding of the stored measurements: compact, snappy or json. Older records are converted in the background.")
+	pflag.StringVar(&o.Retention, "retention", "", "Retention per resolution of the stored measurements, e.g. raw=30d,15m=2y,1d=forever. Nothing is deleted when empty.")
 	pflag.IntVarP(&o.ListenPort, "port", "p", 8080, "Port to listen on, can also be set using the PORT env var.")
+	pflag.BoolVar(&o.Ingest, "ingest", false, "Accept measurements posted by emlog, authenticated with the CLOUDREPO_* environment variables.")
 	pflag.Parse()
 
 	port := os.Getenv("PORT")
@@ -61,11 +75,39 @@ func main() {
 	o := &options{}
 	o.Parse()
 
+	creds, err := cloudrepo.CredentialsFromEnv()
+	if err != nil {
+		log.Printf("error reading the credentials: %s", err.Error())
+		os.Exit(1)
+	}
+
+	switch pflag.Arg(0) {
+	case "":
+	case "restore":
+		// emserver restore file|url
+		if err := restore(o, creds, pflag.Arg(1)); err != nil {
+			log.Printf("restore failed: %s", err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "reindex":
+		// emserver reindex
+		if err := reindex(o); err != nil {
+			log.Printf("reindex failed: %s", err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	default:
+		log.Printf("unknown command %s, expected restore or reindex", pflag.Arg(0))
+		os.Exit(1)
+	}
+
 	// Create the repositories.
 	// Local cache.
-	localRepo, err := cache.Open(o.LocalCache)
+	localRepo, err := openLocalRepo(o)
 	if err != nil {
 		// Log and exit.
+		log.Printf("cannot open the local cache: %s", err.Error())
 		os.Exit(1)
 	}
 	defer localRepo.Close()
@@ -75,10 +117,36 @@ func main() {
 	// TODO: The status REST service.
 	la := ":" + strconv.Itoa(o.ListenPort)
 	log.Printf("Listening on %s", la)
-	localRestSvc := service.NewHttpLocalService(la, localRepo)
+	var routes []service.Route
+	if o.Ingest {
+		if len(creds.Token) == 0 && len(creds.HMACKey) == 0 {
+			log.Printf("--ingest requires %s or %s", cloudrepo.EnvToken, cloudrepo.EnvHMACKey)
+			os.Exit(1)
+		}
+		ingest := cloudrepo.NewVerifier(creds).Handler(&service.IngestHandler{Repo: localRepo})
+		routes = append(routes,
+			service.Route{Pattern: "POST /measurements", Handler: ingest},
+			service.Route{Pattern: "POST /measurements/", Handler: ingest},
+		)
+		log.Print("Accepting measurements on POST /measurements")
+	}
+	if snapshotter, ok := localRepo.(service.Snapshotter); ok && (len(creds.Token) > 0 || len(creds.HMACKey) > 0) {
+		routes = append(routes, service.Route{
+			Pattern: "GET /snapshot",
+			Handler: cloudrepo.NewVerifier(creds).Handler(&service.SnapshotHandler{Repo: snapshotter}),
+		})
+	}
+	if series, ok := localRepo.(model.SeriesWalker); ok {
+		routes = append(routes, service.Route{Pattern: "GET /series", Handler: &service.SeriesHandler{Repo: series}})
+	}
+	localRestSvc := service.NewHttpLocalService(la, localRepo, routes...)
 
 	// Create services list.
-	services := service.NewServicesList(localRestSvc)
+	svcs := []service.Service{localRestSvc, &service.Compactor{Repo: localRepo}}
+	if r, ok := localRepo.(service.Reencodable); ok {
+		svcs = append(svcs, &service.Reencoder{Repo: r})
+	}
+	services := service.NewServicesList(svcs...)
 	// services := service.NewServicesList(localRestSvc)
 
 	if err := services.Start(context.Background()); err != nil {
@@ -97,3 +165,93 @@ func main() {
 
 	log.Println("Services stopped")
 }
+
+// localStore is the local repository, a cache.Cache or a segment.Repo.
+type localStore interface {
+	model.MeasurementRepo
+	service.Compactable
+	Close() error
+}
+
+// openLocalRepo opens the local repository in the storage of the options.
+func openLocalRepo(o *options) (localStore, error) {
+	policies, err := cache.ParsePolicies(o.Retention)
+	if err != nil {
+		return nil, err
+	}
+	switch o.Storage {
+	case "cache":
+		encoding, err := cache.ParseEncoding(o.CacheEncoding)
+		if err != nil {
+			return nil, fmt.Errorf("bad cache encoding %s, expected compact, snappy or json", o.CacheEncoding)
+		}
+		return cache.OpenWithOptions(o.LocalCache, &cache.Options{Encoding: encoding, Policies: policies})
+	case "segments":
+		// The segments only expire, they have no rollups.
+		so := &segment.Options{}
+		for _, p := range policies {
+			if p.Resolution > 0 {
+				return nil, errors.New("the segments storage has no rollups, only a raw retention")
+			}
+			so.Retention = p.Retention
+		}
+		return segment.OpenWithOptions(o.LocalCache, so)
+	}
+	return nil, fmt.Errorf("unknown storage %s, expected cache or segments", o.Storage)
+}
+
+// restore creates the local cache from the snapshot in the file, stdin for
+// "-", or downloaded from an http(s) URL, e.g. the snapshot endpoint of a
+// running emlog.
+func restore(o *options, creds *cloudrepo.Credentials, src string) error {
+	if o.Storage != "cache" {
+		return fmt.Errorf("the %s storage has no snapshots", o.Storage)
+	}
+	var r io.Reader
+	switch {
+	case len(src) == 0:
+		return errors.New("restore needs a file or URL")
+	case src == "-":
+		r = os.Stdin
+	case strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://"):
+		resp, err := creds.Get(context.Background(), src)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		r = resp.Body
+	default:
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	n, err := cache.Restore(o.LocalCache, r)
+	if err != nil {
+		return err
+	}
+	log.Printf("restored %d records to %s", n, o.LocalCache)
+	return nil
+}
+
+// reindex rebuilds the series indexes of the local cache. The cache must
+// not be in use by a running emserver.
+func reindex(o *options) error {
+	if o.Storage != "cache" {
+		return fmt.Errorf("the %s storage has no series indexes", o.Storage)
+	}
+	// The indexes are built once, by RebuildSeries.
+	repo, err := cache.OpenWithOptions(o.LocalCache, &cache.Options{NoSeriesBuild: true, ErrorIfMissing: true})
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+	n, err := repo.RebuildSeries()
+	if err != nil {
+		return err
+	}
+	log.Printf("indexed %d measurements", n)
+	return nil
+}
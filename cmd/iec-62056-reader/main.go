@@ -1,11 +1,250 @@
+// Command iec-62056-reader reads a meter once and prints the identification
+// and the datasets. The exit code tells why a read failed:
+//
+//	0 success
+//	1 bad arguments or another error
+//	2 the port could not be opened
+//	3 timeout, the meter did not answer in time
+//	4 checksum error in the message
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/iec"
+	"github.com/peterzandbergen/iec62056/iec/capture"
+	"github.com/peterzandbergen/iec62056/iec/telegram"
+	"github.com/peterzandbergen/iec62056/model"
+
+	"github.com/spf13/pflag"
+	"go.bug.st/serial.v1"
+)
+
+// Exit codes.
+const (
+	exitOK = iota
+	exitError
+	exitOpenFailed
+	exitTimeout
+	exitChecksum
 )
 
-var serialPort = flag.String("port", "COM3", "Serial port to use.")
+// Options for the program.
+type options struct {
+	Portname            string
+	PortSettings        string
+	Protocol            string
+	Baudrate            int
+	BaudrateModeD       int
+	BaudRateChangeDelay int
+	Framing             string
+	Timeout             int
+	ReadTimeout         int
+	ManufacturerID      string
+	CheckBcc            bool
+	DTR                 string
+	RTS                 string
+	RTSOnTransmit       bool
+	RTSTurnOnDelay      int
+	RTSTurnOffDelay     int
+	Quirks              string
+	Capture             string
+	Output              string
+	Verbose             bool
+}
+
+func (o *options) Parse() {
+	if flag.Parsed() {
+		return
+	}
+	d := iec.NewDefaultSettings()
+	pflag.StringVarP(&o.Portname, "serial-port", "s", "/dev/ttyUSB0", "Device name of the serial port.")
+	pflag.StringVarP(&o.PortSettings, "port-settings", "P", "", "Port settings file, e.g. saved by the probe command. Flags override the file.")
+	pflag.StringVarP(&o.Protocol, "protocol", "p", string(iec.ProtocolModeC), "Protocol of the meter: mode-c, p1 or sml.")
+	pflag.IntVarP(&o.Baudrate, "baudrate", "b", d.InitialBaudRateModeABC, "Initial baudrate for mode A, B and C, the line speed for P1 and SML.")
+	pflag.IntVar(&o.BaudrateModeD, "baudrate-mode-d", d.InitialBaudRateModeD, "Baudrate for mode D.")
+	pflag.IntVar(&o.BaudRateChangeDelay, "baudrate-change-delay", d.BaudRateChangeDelay, "Delay in ms before changing the baudrate.")
+	pflag.StringVarP(&o.Framing, "framing", "f", iec.DefaultFraming, "Data bits, parity and stop bits, e.g. 7E1 or 8N1.")
+	pflag.IntVarP(&o.Timeout, "timeout", "t", d.Timeout, "Timeout in ms of the port settings, the readout is limited by --read-timeout.")
+	pflag.IntVar(&o.ReadTimeout, "read-timeout", 60, "Time in seconds for the whole readout, a mode C readout at 300 baud takes tens of seconds.")
+	pflag.StringVarP(&o.ManufacturerID, "manufacturer", "m", "", "Manufacturer ID of the meter, selects the quirks before the meter identifies itself.")
+	pflag.BoolVar(&o.CheckBcc, "check-bcc", false, "Verify the block check character of mode C data messages.")
+	pflag.StringVar(&o.DTR, "dtr", "unchanged", "Level of DTR: on, off or unchanged.")
+	pflag.StringVar(&o.RTS, "rts", "unchanged", "Level of RTS: on, off or unchanged.")
+	pflag.BoolVar(&o.RTSOnTransmit, "rts-on-transmit", false, "Raise RTS while sending, for RS-485 converters.")
+	pflag.IntVar(&o.RTSTurnOnDelay, "rts-turn-on-delay", 0, "Time in ms between raising RTS and sending.")
+	pflag.IntVar(&o.RTSTurnOffDelay, "rts-turn-off-delay", 0, "Time in ms between sending and dropping RTS.")
+	pflag.StringVarP(&o.Quirks, "quirks", "q", "", `Manufacturer quirks file, a JSON object keyed by manufacturer ID, e.g. {"ABC": {"WakeUp": "0000000000"}}. There are no built-in quirks.`)
+	pflag.StringVarP(&o.Capture, "capture", "c", "", "Save the raw bytes to this capture file.")
+	pflag.StringVarP(&o.Output, "output", "o", "table", "Output format: table, json, csv or obis.")
+	pflag.BoolVarP(&o.Verbose, "verbose", "v", false, "Verbose logging.")
+	pflag.Parse()
+}
+
+// settings returns the port settings from the file, overridden by the flags
+// that were set.
+func (o *options) settings() (*iec.PortSettings, error) {
+	ps := iec.NewDefaultSettings()
+	if len(o.PortSettings) > 0 {
+		var err error
+		if ps, err = iec.LoadSettings(o.PortSettings); err != nil {
+			return nil, err
+		}
+	}
+	set := func(name string) bool {
+		return len(o.PortSettings) == 0 || pflag.CommandLine.Changed(name)
+	}
+	if set("serial-port") || len(ps.PortName) == 0 {
+		ps.PortName = o.Portname
+	}
+	if set("protocol") {
+		ps.Protocol = iec.Protocol(o.Protocol)
+	}
+	if set("baudrate") {
+		ps.InitialBaudRateModeABC = o.Baudrate
+	}
+	if set("baudrate-mode-d") {
+		ps.InitialBaudRateModeD = o.BaudrateModeD
+	}
+	if set("baudrate-change-delay") {
+		ps.BaudRateChangeDelay = o.BaudRateChangeDelay
+	}
+	if set("framing") {
+		ps.Framing = o.Framing
+	}
+	if set("timeout") {
+		ps.Timeout = o.Timeout
+	}
+	if set("manufacturer") {
+		ps.ManufacturerID = o.ManufacturerID
+	}
+	if set("check-bcc") {
+		ps.CheckBcc = o.CheckBcc
+	}
+	if set("dtr") {
+		l, err := iec.ParseLineState(o.DTR)
+		if err != nil {
+			return nil, err
+		}
+		ps.DTR = l
+	}
+	if set("rts") {
+		l, err := iec.ParseLineState(o.RTS)
+		if err != nil {
+			return nil, err
+		}
+		ps.RTS = l
+	}
+	if set("rts-on-transmit") {
+		ps.RTSOnTransmit = o.RTSOnTransmit
+	}
+	if set("rts-turn-on-delay") {
+		ps.RTSTurnOnDelay = o.RTSTurnOnDelay
+	}
+	if set("rts-turn-off-delay") {
+		ps.RTSTurnOffDelay = o.RTSTurnOffDelay
+	}
+	if set("verbose") {
+		ps.Verbose = o.Verbose
+	}
+	return ps, nil
+}
+
+// exitCode returns the exit code for the error of a read.
+// openExitCode returns the exit code for an error of Open. Settings that
+// the port does not support are bad arguments.
+func openExitCode(err error) int {
+	var pe *serial.PortError
+	if errors.As(err, &pe) {
+		switch pe.Code() {
+		case serial.InvalidSpeed, serial.InvalidDataBits, serial.InvalidParity, serial.InvalidStopBits:
+			return exitError
+		}
+	}
+	return exitOpenFailed
+}
+
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, iec.ErrReadTimeout):
+		return exitTimeout
+	case errors.Is(err, telegram.ErrBadChecksum):
+		return exitChecksum
+	}
+	return exitError
+}
 
 func main() {
+	o := &options{}
+	o.Parse()
+	os.Exit(run(o))
+}
+
+func run(o *options) int {
+	format, ok := formats[o.Output]
+	if !ok {
+		log.Printf("unknown output format %s, expected table, json, csv or obis", o.Output)
+		return exitError
+	}
+	ps, err := o.settings()
+	if err != nil {
+		log.Printf("bad port settings: %s", err.Error())
+		return exitError
+	}
+	if len(o.Quirks) > 0 {
+		if err := iec.DefaultQuirks.Load(o.Quirks); err != nil {
+			log.Printf("cannot read the quirks: %s", err.Error())
+			return exitError
+		}
+	}
+
+	p := iec.New(ps)
+	if len(o.Capture) > 0 {
+		f, err := os.Create(o.Capture)
+		if err != nil {
+			log.Printf("cannot create the capture: %s", err.Error())
+			return exitError
+		}
+		defer f.Close()
+		p.Capture = capture.NewWriter(f)
+	}
+	if _, err := iec.ParseFraming(ps.Framing); err != nil {
+		log.Printf("bad port settings: %s", err.Error())
+		return exitError
+	}
+	if err := p.Open(ps.PortName); err != nil {
+		log.Printf("cannot open %s: %s", ps.PortName, err.Error())
+		return openExitCode(err)
+	}
+	defer p.Close()
 
+	timeout := time.Duration(o.ReadTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	dm, err := p.ReadTimeout(timeout)
+	if err != nil {
+		log.Printf("error reading the meter: %s", err.Error())
+		return exitCode(err)
+	}
+	m := &model.Measurement{
+		Time:           time.Now(),
+		ManufacturerID: dm.ManufacturerID,
+		Identification: dm.MeterID,
+	}
+	for _, ds := range dm.DataSets {
+		m.Readings = append(m.Readings, model.DataSet{Address: ds.Address, Value: ds.Value, Unit: ds.Unit})
+	}
+	if err := format(os.Stdout, m); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	return exitOK
 }
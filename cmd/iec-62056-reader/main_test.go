@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/iec"
+	"github.com/peterzandbergen/iec62056/iec/telegram"
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+func TestExitCode(t *testing.T) {
+	for err, expected := range map[error]int{
+		nil:                     exitOK,
+		iec.ErrReadTimeout:      exitTimeout,
+		telegram.ErrBadChecksum: exitChecksum,
+		fmt.Errorf("wrapped: %w", telegram.ErrBadChecksum): exitChecksum,
+		errors.New("other"): exitError,
+	} {
+		if c := exitCode(err); c != expected {
+			t.Errorf("%v: expected %v, received %v", err, expected, c)
+		}
+	}
+}
+
+func TestOpenExitCode(t *testing.T) {
+	if c := openExitCode(errors.New("no such device")); c != exitOpenFailed {
+		t.Errorf("expected %v, received %v", exitOpenFailed, c)
+	}
+}
+
+func testMeasurement() *model.Measurement {
+	return &model.Measurement{
+		Time:           time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC),
+		ManufacturerID: "ISK",
+		Identification: "meter1",
+		Readings: []model.DataSet{
+			{Address: "1.8.1", Value: "000051.394", Unit: "kWh"},
+		},
+	}
+}
+
+func TestFormats(t *testing.T) {
+	for name, expected := range map[string]string{
+		"table": "1.8.1    000051.394  kWh",
+		"json":  `"Address": "1.8.1"`,
+		"csv":   "2020-01-02T15:04:05Z,ISK,meter1,1.8.1,000051.394,kWh",
+		"obis":  "1.8.1(000051.394*kWh)  active energy import, total tariff 1",
+	} {
+		var b bytes.Buffer
+		if err := formats[name](&b, testMeasurement()); err != nil {
+			t.Fatalf("%s: unexpected error: %s", name, err.Error())
+		}
+		if !strings.Contains(b.String(), expected) {
+			t.Errorf("%s: expected %q in %q", name, expected, b.String())
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/iec/obis"
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+// formats writes the measurement in the output format.
+var formats = map[string]func(io.Writer, *model.Measurement) error{
+	"table": writeTable,
+	"json":  writeJSON,
+	"csv":   writeCSV,
+	"obis":  writeOBIS,
+}
+
+func writeTable(w io.Writer, m *model.Measurement) error {
+	fmt.Fprintf(w, "Manufacturer: %s\nMeter:        %s\nTime:         %s\n\n", m.ManufacturerID, m.Identification, m.Time.Format(time.RFC3339))
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ADDRESS\tVALUE\tUNIT")
+	for _, ds := range m.Readings {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", ds.Address, ds.Value, ds.Unit)
+	}
+	return tw.Flush()
+}
+
+func writeJSON(w io.Writer, m *model.Measurement) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+// writeCSV writes a row per dataset, with the meter on every row so the
+// output of several reads can be concatenated.
+func writeCSV(w io.Writer, m *model.Measurement) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"time", "manufacturer", "meter", "address", "value", "unit"})
+	for _, ds := range m.Readings {
+		cw.Write([]string{m.Time.Format(time.RFC3339), m.ManufacturerID, m.Identification, ds.Address, ds.Value, ds.Unit})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeOBIS writes the datasets as in the telegram, followed by the meaning
+// of the address.
+func writeOBIS(w io.Writer, m *model.Measurement) error {
+	fmt.Fprintf(w, "/%s %s\n", m.ManufacturerID, m.Identification)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, ds := range m.Readings {
+		v := ds.Value
+		if len(ds.Unit) > 0 {
+			v += "*" + ds.Unit
+		}
+		fmt.Fprintf(tw, "%s(%s)\t%s\n", ds.Address, v, obis.Describe(ds.Address))
+	}
+	return tw.Flush()
+}
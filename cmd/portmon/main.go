@@ -1,148 +1,224 @@
+// Command portmon shows the traffic on the serial port of a meter. It can
+// send a request or an acknowledge, shows the bytes raw, as hex, as frames
+// or as parsed datasets and saves the session to a capture file.
 package main
 
 import (
 	"bufio"
+	"bytes"
+	"flag"
 	"fmt"
 	"io"
+	"log"
 	"os"
-	"sync"
 	"time"
-	"bytes"
 
-	"github.com/augustoroman/hexdump"
 	"go.bug.st/serial.v1"
 
+	"github.com/peterzandbergen/iec62056/iec"
+	"github.com/peterzandbergen/iec62056/iec/capture"
 	"github.com/peterzandbergen/iec62056/iec/telegram"
+
+	"github.com/spf13/pflag"
 )
 
-func readCommands(wg *sync.WaitGroup, in io.Reader, p io.Writer) {
+// Options for the program.
+type options struct {
+	Portname string
+	Baudrate int
+	Framing  string
+	Protocol string
+	View     string
+	Gap      int
+	Save     string
+	Replay   string
+	Speed    float64
+	DTR      string
+	RTS      string
+	NoColor  bool
+}
+
+func (o *options) Parse() {
+	if flag.Parsed() {
+		return
+	}
+	pflag.StringVarP(&o.Portname, "serial-port", "s", "/dev/ttyUSB0", "Device name of the serial port.")
+	pflag.IntVarP(&o.Baudrate, "baudrate", "b", 115200, "Baudrate of the serial port.")
+	pflag.StringVarP(&o.Framing, "framing", "f", "8N1", "Data bits, parity and stop bits, e.g. 7E1 or 8N1.")
+	pflag.StringVarP(&o.Protocol, "protocol", "p", string(iec.ProtocolP1), "Protocol of the meter: mode-c, p1 or sml.")
+	pflag.StringVarP(&o.View, "view", "V", "frames", "Output: raw, hex, frames or datasets.")
+	pflag.IntVarP(&o.Gap, "gap", "g", 500, "Report silences longer than this number of ms.")
+	pflag.StringVarP(&o.Save, "save", "o", "", "Save the session to this capture file.")
+	pflag.StringVarP(&o.Replay, "replay", "r", "", "Show a capture file instead of the serial port.")
+	pflag.Float64Var(&o.Speed, "speed", 0, "Replay speed, 1 is the original timing, 0 is without delay.")
+	pflag.StringVar(&o.DTR, "dtr", "unchanged", "Level of DTR: on, off or unchanged.")
+	pflag.StringVar(&o.RTS, "rts", "unchanged", "Level of RTS: on, off or unchanged.")
+	pflag.BoolVar(&o.NoColor, "no-color", false, "Do not highlight errors and gaps.")
+	pflag.Parse()
+}
+
+// readCommands sends the request or the acknowledge to the meter.
+func readCommands(in io.Reader, p io.Writer, records chan<- *capture.Record, quit chan<- struct{}) {
 	// connect buffered reader and read bytes.
 	b := bytes.Buffer{}
 	telegram.SerializeRequestMessage(&b, telegram.RequestMessage{})
 	reqMsg := b.Bytes()
+	// Data readout at the initial baud rate.
+	ack := []byte{telegram.AckChar, '0', '0', '0', telegram.CR, telegram.LF}
 	var cr = bufio.NewReader(in)
-	time.Sleep(time.Second)
-	// telegram.SerializeRequestMessage(p, telegram.RequestMessage{})
 	for {
 		b, err := cr.ReadByte()
 		if err != nil {
-			fmt.Printf("error reading command: %s\n", err.Error())
+			// Keep monitoring without commands.
+			return
 		}
+		var msg []byte
 		switch rune(b) {
 		case 'r', 'R':
-			// Send request.
-			fmt.Println("sending request")
-			// n, err := telegram.SerializeRequestMessage(p, reqMsg)
-			n, err := p.Write(reqMsg)
-			if err != nil {
-				fmt.Printf("error: %s\n", err)
-				break
-			} 
-			fmt.Printf("sent request bytes: %d\n", n)
+			msg = reqMsg
 		case 'a', 'A':
-			// Send request.
-			fmt.Println("sending ACK")
-			ack := "\x06\x00\x00\x00\r\n"
-			n, err := p.Write([]byte(ack))
-			if err != nil {
-				fmt.Printf("error: %s\n", err)
-				break
-			} 
-			fmt.Printf("sent ack bytes: %d\n", n)
+			msg = ack
 		case 'q', 'Q':
-			wg.Done()
+			close(quit)
 			return
-		}
-	}
-}
-
-func writeResponses(wg *sync.WaitGroup, in *bufio.Reader, out io.Writer) {
-	defer wg.Done()
-	for {
-		idm, err := telegram.ParseIdentificationMessage(in)
-		if err != nil {
-			fmt.Fprintf(out, "Error receiving ID message: %s\n", err.Error())
+		default:
 			continue
 		}
-		fmt.Fprintf(out, "%+v\n\n", *idm)
-		dm, err := telegram.ParseDataMessage(in)
+		n, err := p.Write(msg)
 		if err != nil {
-			fmt.Fprintf(out, "Error receiving data message: %s\n", err.Error())
+			fmt.Printf("error: %s\n", err)
 			continue
 		}
-		fmt.Fprint(out, dm.String())
+		records <- &capture.Record{Time: time.Now(), Direction: capture.TX, Data: msg[:n]}
 	}
 }
 
-func writeHexResponses(wg *sync.WaitGroup, in *bufio.Reader, out io.Writer) {
-	defer wg.Done()
+// readPort sends the bytes from the port as records.
+func readPort(p io.Reader, records chan<- *capture.Record) {
+	defer close(records)
+	b := make([]byte, 4096)
 	for {
-		b, err := in.ReadByte()
+		n, err := p.Read(b)
+		if n > 0 {
+			records <- &capture.Record{Time: time.Now(), Direction: capture.RX, Data: append([]byte(nil), b[:n]...)}
+		}
 		if err != nil {
 			return
 		}
-		fmt.Fprintf(out, "[%x]", b)
-		if b == 0x0D {
-			b = ' '
-		}
-		fmt.Fprint(out, string([]byte{b}))
 	}
 }
 
-func writeHexLineResponses(wg *sync.WaitGroup, in *bufio.Reader, out io.Writer) {
-	defer wg.Done()
-	cfg := hexdump.Config{
-		Width: 32,
-	}
-	b := make([]byte, cfg.Width)
-	for {
-		n, err := io.ReadAtLeast(in, b, len(b))
-		if err != nil {
-			return
+// replay sends the records of the capture with the timing scaled by speed.
+func replay(rr []*capture.Record, speed float64, records chan<- *capture.Record) {
+	defer close(records)
+	for i, r := range rr {
+		if i > 0 && speed > 0 {
+			time.Sleep(time.Duration(float64(r.Time.Sub(rr[i-1].Time)) / speed))
 		}
-		out.Write([]byte(cfg.Dump(b[:n])))
+		records <- r
 	}
 }
 
-func writeLineResponses(wg *sync.WaitGroup, in *bufio.Reader, out io.Writer) {
-	defer wg.Done()
-	for {
-		b, err := in.ReadByte()
-		if err != nil {
-			return
-		}
-		fmt.Fprint(out, string([]byte{b}))
+func openPort(o *options) (serial.Port, error) {
+	mode, err := iec.ParseFraming(o.Framing)
+	if err != nil {
+		return nil, err
 	}
+	mode.BaudRate = o.Baudrate
+	dtr, err := iec.ParseLineState(o.DTR)
+	if err != nil {
+		return nil, err
+	}
+	rts, err := iec.ParseLineState(o.RTS)
+	if err != nil {
+		return nil, err
+	}
+	p, err := serial.Open(o.Portname, mode)
+	if err != nil {
+		return nil, err
+	}
+	if dtr != iec.LineUnchanged {
+		err = p.SetDTR(dtr == iec.LineOn)
+	}
+	if rts != iec.LineUnchanged && err == nil {
+		err = p.SetRTS(rts == iec.LineOn)
+	}
+	if err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
 }
 
 func main() {
-	var wg = &sync.WaitGroup{}
+	o := &options{}
+	o.Parse()
 
-	p, err := serial.Open("/dev/ttyUSB0",
-		&serial.Mode{
-			BaudRate: 115200,
-			DataBits: 8,
-			Parity:   serial.NoParity,
-			StopBits: serial.OneStopBit,
-		})
+	v, err := newView(o.View, os.Stdout, iec.Protocol(o.Protocol), !o.NoColor)
 	if err != nil {
-		fmt.Printf("error opening port: %s\n", err.Error())
-		return
+		log.Fatal(err)
 	}
-	defer p.Close()
 
-	br := bufio.NewReader(p)
+	var save *capture.Writer
+	if len(o.Save) > 0 {
+		f, err := os.Create(o.Save)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		save = capture.NewWriter(f)
+		save.Comment(fmt.Sprintf("port %s %d %s %s", o.Portname, o.Baudrate, o.Framing, o.Protocol))
+	}
 
-	// Start the reader and the writer.
-	fmt.Println("starting loop")
-	wg.Add(2)
-	// p.SetRTS(true)
-	go readCommands(wg, os.Stdin, p)
-	// go writeResponses(wg, br, os.Stdout)
-	// go writeHexResponses(wg, br, os.Stdout)
-	go writeLineResponses(wg, br, os.Stdout)
-	// go writeHexLineResponses(wg, br, os.Stdout)
-	p.SetRTS(false)
-	// p.SetDTR(true)
-	wg.Wait()
+	records := make(chan *capture.Record)
+	// Sent requests, separate from records which is closed by the reader.
+	sent := make(chan *capture.Record)
+	quit := make(chan struct{})
+	if len(o.Replay) > 0 {
+		rr, err := capture.Load(o.Replay)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go replay(rr, o.Speed, records)
+	} else {
+		p, err := openPort(o)
+		if err != nil {
+			log.Fatalf("error opening port %s: %s", o.Portname, err.Error())
+		}
+		defer p.Close()
+		fmt.Fprintln(os.Stderr, "r: send request, a: send acknowledge, q: quit")
+		go readPort(p, records)
+		go readCommands(os.Stdin, p, sent, quit)
+	}
+
+	gap := time.Duration(o.Gap) * time.Millisecond
+	var last time.Time
+	show := func(r *capture.Record) {
+		if save != nil {
+			if err := save.Write(r); err != nil {
+				log.Printf("error saving the session: %s", err.Error())
+			}
+		}
+		var silence time.Duration
+		if !last.IsZero() && r.Time.Sub(last) > gap {
+			silence = r.Time.Sub(last)
+		}
+		last = r.Time
+		v.Record(r, silence)
+	}
+	for {
+		select {
+		case r, ok := <-records:
+			if !ok {
+				v.Flush()
+				return
+			}
+			show(r)
+		case r := <-sent:
+			show(r)
+		case <-quit:
+			v.Flush()
+			return
+		}
+	}
 }
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/augustoroman/hexdump"
+
+	"github.com/peterzandbergen/iec62056/iec"
+	"github.com/peterzandbergen/iec62056/iec/capture"
+	"github.com/peterzandbergen/iec62056/iec/telegram"
+)
+
+// ErrUnknownView is returned for an unknown view flag.
+var ErrUnknownView = errors.New("unknown view, expected raw, hex, frames or datasets")
+
+// view shows the records of a session.
+type view interface {
+	// Record shows the bytes, gap is the silence before them if it was too long.
+	Record(r *capture.Record, gap time.Duration)
+	// Flush shows the bytes of an incomplete frame.
+	Flush()
+}
+
+const (
+	red    = "\x1b[31m"
+	yellow = "\x1b[33m"
+	reset  = "\x1b[0m"
+)
+
+// highlighter colors errors and gaps.
+type highlighter struct {
+	out   io.Writer
+	color bool
+}
+
+func (h highlighter) printf(color, format string, args ...interface{}) {
+	if h.color {
+		fmt.Fprint(h.out, color)
+		defer fmt.Fprint(h.out, reset)
+	}
+	fmt.Fprintf(h.out, format, args...)
+}
+
+func (h highlighter) gap(gap time.Duration) {
+	if gap > 0 {
+		h.printf(yellow, "--- gap %s ---\n", gap.Round(time.Millisecond))
+	}
+}
+
+func newView(name string, out io.Writer, protocol iec.Protocol, color bool) (view, error) {
+	h := highlighter{out: out, color: color}
+	switch name {
+	case "raw":
+		return &rawView{highlighter: h}, nil
+	case "hex":
+		return &hexView{highlighter: h}, nil
+	case "frames", "datasets":
+		return &frameView{
+			highlighter: h,
+			datasets:    name == "datasets",
+			splitters: map[capture.Direction]*telegram.Splitter{
+				capture.TX: {},
+				capture.RX: {P1: protocol == iec.ProtocolP1},
+			},
+			gaps: map[capture.Direction]time.Duration{},
+		}, nil
+	}
+	return nil, ErrUnknownView
+}
+
+// controlNames are shown instead of the control characters.
+var controlNames = map[byte]string{
+	0x00:             "<NUL>",
+	telegram.StxChar: "<STX>",
+	telegram.EtxChar: "<ETX>",
+	telegram.AckChar: "<ACK>",
+	telegram.NakChar: "<NAK>",
+	telegram.CR:      "",
+	telegram.LF:      "\n",
+	0x1b:             "<ESC>",
+}
+
+// printable returns b with the control characters replaced.
+func printable(b []byte) string {
+	var s strings.Builder
+	for _, c := range b {
+		if n, ok := controlNames[c]; ok {
+			s.WriteString(n)
+			continue
+		}
+		if c < 0x20 || c > 0x7e {
+			fmt.Fprintf(&s, "<%02x>", c)
+			continue
+		}
+		s.WriteByte(c)
+	}
+	return s.String()
+}
+
+// rawView shows the bytes as text, marking the direction changes.
+type rawView struct {
+	highlighter
+	dir    capture.Direction
+	active bool
+}
+
+func (v *rawView) Record(r *capture.Record, gap time.Duration) {
+	if gap > 0 {
+		fmt.Fprintln(v.out)
+		v.gap(gap)
+	}
+	if !v.active || r.Direction != v.dir {
+		fmt.Fprintf(v.out, "\n[%s %s]\n", r.Time.Format("15:04:05.000"), r.Direction)
+		v.dir, v.active = r.Direction, true
+	}
+	fmt.Fprint(v.out, printable(r.Data))
+}
+
+func (v *rawView) Flush() {
+	fmt.Fprintln(v.out)
+}
+
+// hexView shows a hex dump of every chunk.
+type hexView struct {
+	highlighter
+}
+
+func (v *hexView) Record(r *capture.Record, gap time.Duration) {
+	v.gap(gap)
+	fmt.Fprintf(v.out, "%s %s %d bytes\n", r.Time.Format("15:04:05.000"), r.Direction, len(r.Data))
+	if !v.color {
+		fmt.Fprint(v.out, hex.Dump(r.Data))
+		return
+	}
+	cfg := hexdump.Config{Width: 16}
+	fmt.Fprint(v.out, cfg.Dump(r.Data))
+}
+
+func (v *hexView) Flush() {}
+
+// frameView splits the bytes into frames and shows them with the checksum
+// status, or the parsed datasets.
+type frameView struct {
+	highlighter
+	datasets  bool
+	splitters map[capture.Direction]*telegram.Splitter
+	// gaps is the longest silence inside the incomplete frame.
+	gaps map[capture.Direction]time.Duration
+}
+
+func (v *frameView) Record(r *capture.Record, gap time.Duration) {
+	s := v.splitters[r.Direction]
+	if s.Buffered() == 0 {
+		v.gap(gap)
+	} else if gap > v.gaps[r.Direction] {
+		v.gaps[r.Direction] = gap
+	}
+	for _, f := range s.Write(r.Data) {
+		v.frame(r, f, v.gaps[r.Direction])
+		v.gaps[r.Direction] = 0
+	}
+}
+
+func (v *frameView) Flush() {
+	for _, d := range []capture.Direction{capture.TX, capture.RX} {
+		if f := v.splitters[d].Flush(); f != nil {
+			v.printf(red, "%s incomplete frame %d bytes\n", d, len(f.Data))
+			fmt.Fprintln(v.out, printable(f.Data))
+		}
+	}
+}
+
+// frame shows one frame. r is the record that completed the frame.
+func (v *frameView) frame(r *capture.Record, f *telegram.Frame, gap time.Duration) {
+	fmt.Fprintf(v.out, "%s %s %s %d bytes", r.Time.Format("15:04:05.000"), r.Direction, f.Kind, len(f.Data))
+	if gap > 0 {
+		v.printf(yellow, " gap %s inside frame", gap.Round(time.Millisecond))
+	}
+	fc, err := f.Decode()
+	switch {
+	case f.Kind == telegram.FrameNoise:
+	case err == telegram.ErrBadChecksum:
+		v.printf(red, " checksum error")
+	case err != nil:
+		v.printf(red, " error: %s", err.Error())
+	default:
+		fmt.Fprint(v.out, " ok")
+	}
+	fmt.Fprintln(v.out)
+	if !v.datasets || fc == nil {
+		fmt.Fprintln(v.out, strings.TrimRight(printable(f.Data), "\n"))
+		return
+	}
+	if len(fc.ManID) > 0 || len(fc.Identification) > 0 {
+		fmt.Fprintf(v.out, "  meter %s %s\n", fc.ManID, fc.Identification)
+	}
+	for _, ds := range fc.DataSets {
+		fmt.Fprintf(v.out, "  %-16s %s %s\n", ds.Address, ds.Value, ds.Unit)
+	}
+}
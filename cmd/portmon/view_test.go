@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/iec"
+	"github.com/peterzandbergen/iec62056/iec/capture"
+)
+
+func TestFrameView(t *testing.T) {
+	var b bytes.Buffer
+	v, err := newView("frames", &b, iec.ProtocolP1, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	now := time.Now()
+	v.Record(&capture.Record{Time: now, Direction: capture.RX, Data: []byte("/CTA5ZIV-METER\r\n\r\n")}, 0)
+	v.Record(&capture.Record{Time: now, Direction: capture.RX, Data: []byte("1-0:1.8.1(000051.394*kWh)\r\n!0000\r\n")}, 2*time.Second)
+	out := b.String()
+	for _, s := range []string{"checksum error", "gap 2s inside frame"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("expected %q in %q", s, out)
+		}
+	}
+}
+
+func TestUnknownView(t *testing.T) {
+	if _, err := newView("bogus", &bytes.Buffer{}, iec.ProtocolP1, false); err != ErrUnknownView {
+		t.Errorf("expected %v, received %v", ErrUnknownView, err)
+	}
+}
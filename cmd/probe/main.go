@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/iec"
+	"github.com/peterzandbergen/iec62056/iec/portmgr"
+
+	"github.com/spf13/pflag"
+)
+
+// Options for the program.
+type options struct {
+	Portname   string
+	Candidates []string
+	Timeout    int
+	Save       string
+	Verbose    bool
+}
+
+func (o *options) Parse() {
+	if flag.Parsed() {
+		return
+	}
+	pflag.StringVarP(&o.Portname, "serial-port", "s", "/dev/ttyUSB0", "Device name of the serial port.")
+	pflag.StringSliceVarP(&o.Candidates, "candidate", "c", nil, "Candidate to try as protocol:baudrate:framing, e.g. p1:115200:8N1. Defaults to the common meter configurations.")
+	pflag.IntVarP(&o.Timeout, "timeout", "t", 15, "Time in seconds to wait for a response per candidate.")
+	pflag.StringVarP(&o.Save, "save", "o", "", "Save the detected port settings to this file.")
+	pflag.BoolVarP(&o.Verbose, "verbose", "v", false, "Log every failed candidate.")
+	pflag.Parse()
+}
+
+func main() {
+	o := &options{}
+	o.Parse()
+
+	var candidates []iec.ProbeCandidate
+	for _, s := range o.Candidates {
+		c, err := iec.ParseProbeCandidate(s)
+		if err != nil {
+			log.Fatal(err)
+		}
+		candidates = append(candidates, c)
+	}
+
+	ps := iec.NewDefaultSettings()
+	ps.PortName = o.Portname
+	ps.Verbose = o.Verbose
+	// Fails when another process, e.g. emlog, owns the port.
+	pm := portmgr.New()
+	defer pm.Close()
+	var settings *iec.PortSettings
+	var results []*iec.ProbeResult
+	err := pm.Do(context.Background(), o.Portname, portmgr.ClassProbe, func(ctx context.Context) error {
+		var err error
+		settings, results, err = iec.Probe(ps, candidates, time.Duration(o.Timeout)*time.Second)
+		return err
+	})
+	for _, r := range results {
+		if r.Ok() {
+			fmt.Printf("%-20s ok     %s %s\n", r.Candidate, r.ManufacturerID, r.MeterID)
+		} else {
+			fmt.Printf("%-20s failed %s\n", r.Candidate, r.Err.Error())
+		}
+	}
+	if err != nil {
+		fmt.Printf("%s: %s\n", o.Portname, err.Error())
+		pm.Close()
+		os.Exit(1)
+	}
+
+	fmt.Println()
+	iec.WriteSettings(os.Stdout, settings)
+	if len(o.Save) > 0 {
+		if err := iec.SaveSettings(o.Save, settings); err != nil {
+			log.Fatalf("cannot save the port settings: %s", err.Error())
+		}
+		log.Printf("saved port settings to %s", o.Save)
+	}
+}
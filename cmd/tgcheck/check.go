@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/iec/capture"
+	"github.com/peterzandbergen/iec62056/iec/telegram"
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+// result of the check of one frame.
+type result struct {
+	// Index of the frame in the file, starting at 1.
+	Index int
+	// Time the last byte of the frame was received.
+	Time  time.Time
+	Frame *telegram.Frame
+	Err   error
+}
+
+func (r *result) Ok() bool {
+	return r.Err == nil
+}
+
+// checker splits the received bytes of captures into frames, validates
+// them and converts the valid frames to measurements.
+type checker struct {
+	p1           bool
+	results      []*result
+	measurements []*model.Measurement
+	// identification of the last mode C identification message, the
+	// following data message belongs to it.
+	ident *telegram.FrameContent
+}
+
+// check processes the records of one capture.
+func (c *checker) check(records []*capture.Record) {
+	s := telegram.Splitter{P1: c.p1}
+	var last time.Time
+	for _, r := range records {
+		if r.Direction != capture.RX {
+			continue
+		}
+		last = r.Time
+		for _, f := range s.Write(r.Data) {
+			c.frame(r.Time, f)
+		}
+	}
+	if f := s.Flush(); f != nil {
+		c.results = append(c.results, &result{
+			Index: len(c.results) + 1,
+			Time:  last,
+			Frame: f,
+			Err:   telegram.ErrUnexpectedEOF,
+		})
+	}
+	c.ident = nil
+}
+
+func (c *checker) frame(t time.Time, f *telegram.Frame) {
+	res := &result{Index: len(c.results) + 1, Time: t, Frame: f}
+	c.results = append(c.results, res)
+	if f.Kind == telegram.FrameNoise {
+		res.Err = telegram.ErrFormatError
+		return
+	}
+	fc, err := f.Decode()
+	res.Err = err
+	if err != nil {
+		return
+	}
+	switch f.Kind {
+	case telegram.FrameIdentification:
+		c.ident = fc
+		return
+	case telegram.FrameData:
+		if c.ident == nil {
+			return
+		}
+		fc.ManID, fc.Identification = c.ident.ManID, c.ident.Identification
+		c.ident = nil
+	case telegram.FrameAck:
+		return
+	}
+	m := &model.Measurement{
+		Time:           t,
+		ManufacturerID: fc.ManID,
+		Identification: fc.Identification,
+	}
+	for _, ds := range fc.DataSets {
+		m.Readings = append(m.Readings, model.DataSet{Address: ds.Address, Value: ds.Value, Unit: ds.Unit})
+	}
+	c.measurements = append(c.measurements, m)
+}
+
+// invalid returns the number of frames with errors.
+func (c *checker) invalid() int {
+	n := 0
+	for _, r := range c.results {
+		if !r.Ok() {
+			n++
+		}
+	}
+	return n
+}
+
+// report writes a line per frame, only the frames with errors if errorsOnly is set.
+func (c *checker) report(w io.Writer, name string, errorsOnly bool) {
+	for _, r := range c.results {
+		if r.Ok() && errorsOnly {
+			continue
+		}
+		status := "ok"
+		if !r.Ok() {
+			status = r.Err.Error()
+		}
+		fmt.Fprintf(w, "%s: frame %d %s %s %d bytes: %s\n", name, r.Index, r.Time.Format(time.RFC3339Nano), r.Frame.Kind, len(r.Frame.Data), status)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/adapters/cache"
+	"github.com/peterzandbergen/iec62056/adapters/memory"
+	"github.com/peterzandbergen/iec62056/iec/capture"
+	"github.com/peterzandbergen/iec62056/iec/telegram"
+)
+
+const p1Telegram = "/CTA5ZIV-METER\r\n\r\n1-0:1.8.1(000051.394*kWh)\r\n!B450\r\n"
+
+func TestCheckP1(t *testing.T) {
+	t0 := time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)
+	c := &checker{p1: true}
+	c.check([]*capture.Record{
+		{Time: t0, Direction: capture.RX, Data: []byte(p1Telegram[:20])},
+		{Time: t0.Add(time.Second), Direction: capture.RX, Data: []byte(p1Telegram[20:])},
+		{Time: t0.Add(10 * time.Second), Direction: capture.RX, Data: []byte(p1Telegram[:len(p1Telegram)-6] + "0000\r\n")},
+	})
+	if len(c.results) != 2 {
+		t.Fatalf("expected %v, received %v", 2, len(c.results))
+	}
+	if !c.results[0].Ok() || c.results[1].Err != telegram.ErrBadChecksum {
+		t.Errorf("unexpected results: %v %v", c.results[0].Err, c.results[1].Err)
+	}
+	if len(c.measurements) != 1 {
+		t.Fatalf("expected %v, received %v", 1, len(c.measurements))
+	}
+	m := c.measurements[0]
+	if !m.Time.Equal(t0.Add(time.Second)) || m.Identification != "ZIV-METER" || len(m.Readings) != 1 {
+		t.Errorf("unexpected measurement: %+v", m)
+	}
+}
+
+func TestCheckModeC(t *testing.T) {
+	body := "1.8.0(001234.5*kWh)\r\n!\r\n" + string(telegram.EtxChar)
+	var bcc telegram.Bcc
+	bcc.Digest([]byte(body)...)
+	data := "/MAN5identification\r\n" + string(telegram.StxChar) + body + string(byte(bcc))
+	c := &checker{}
+	c.check([]*capture.Record{
+		{Time: time.Now(), Direction: capture.TX, Data: []byte("/?!\r\n")},
+		{Time: time.Now(), Direction: capture.RX, Data: []byte(data)},
+	})
+	if c.invalid() != 0 {
+		t.Errorf("expected %v, received %v", 0, c.invalid())
+	}
+	if len(c.measurements) != 1 || c.measurements[0].ManufacturerID != "MAN" {
+		t.Errorf("unexpected measurements: %+v", c.measurements)
+	}
+}
+
+// TestImport imports the json and ndjson output into a cache.
+func TestImport(t *testing.T) {
+	t0 := time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)
+	c := &checker{p1: true}
+	c.check([]*capture.Record{
+		{Time: t0, Direction: capture.RX, Data: []byte(p1Telegram)},
+		{Time: t0.Add(10 * time.Second), Direction: capture.RX, Data: []byte(p1Telegram)},
+	})
+	for _, format := range []string{"json", "ndjson"} {
+		var b bytes.Buffer
+		if err := writeMeasurements(&b, format, c.measurements); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		repo := memory.New()
+		n, err := cache.Import(repo, &b)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", format, err.Error())
+		}
+		if n != 2 {
+			t.Errorf("%s: expected %v, received %v", format, 2, n)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "cache")
+	if err := importMeasurements(path, c.measurements); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	repo, err := cache.Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	defer repo.Close()
+	mm, err := repo.GetAll()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(mm) != 2 || mm[0].Identification != "ZIV-METER" {
+		t.Errorf("expected %v, received %v", "2 measurements of ZIV-METER", mm)
+	}
+}
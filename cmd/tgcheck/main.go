@@ -0,0 +1,154 @@
+// Command tgcheck validates the telegrams in capture files. It splits the
+// received bytes into frames, checks the syntax and the BCC or CRC of every
+// frame and converts the valid frames into measurements that can be
+// imported into the cache.
+//
+// Files that are not captures are read as the raw bytes of the meter, with
+// the modification time of the file as the time of the telegrams.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/peterzandbergen/iec62056/adapters/cache"
+	"github.com/peterzandbergen/iec62056/iec"
+	"github.com/peterzandbergen/iec62056/iec/capture"
+	"github.com/peterzandbergen/iec62056/model"
+
+	"github.com/spf13/pflag"
+)
+
+// Options for the program.
+type options struct {
+	Protocol   string
+	Format     string
+	Output     string
+	Import     string
+	ErrorsOnly bool
+}
+
+func (o *options) Parse() {
+	if flag.Parsed() {
+		return
+	}
+	pflag.StringVarP(&o.Protocol, "protocol", "p", string(iec.ProtocolP1), "Protocol of the meter: mode-c, p1 or sml.")
+	pflag.StringVarP(&o.Format, "format", "f", "none", "Write the measurements as json, ndjson or none.")
+	pflag.StringVarP(&o.Output, "output", "o", "", "File for the measurements, default stdout.")
+	pflag.StringVarP(&o.Import, "import", "i", "", "Put the measurements in the cache at this location.")
+	pflag.BoolVarP(&o.ErrorsOnly, "errors-only", "e", false, "Only report the frames with errors.")
+	pflag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] file...\n", os.Args[0])
+		pflag.PrintDefaults()
+	}
+	pflag.Parse()
+}
+
+// readRecords reads a capture, or the raw bytes of a file that is not a capture.
+func readRecords(filename string) ([]*capture.Record, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	records, err := capture.Read(bytes.NewReader(b))
+	if err == nil && len(records) > 0 {
+		return records, nil
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	return []*capture.Record{{Time: fi.ModTime(), Direction: capture.RX, Data: b}}, nil
+}
+
+func writeMeasurements(w io.Writer, format string, mm []*model.Measurement) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if mm == nil {
+			mm = []*model.Measurement{}
+		}
+		return enc.Encode(mm)
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		for _, m := range mm {
+			if err := enc.Encode(m); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// importMeasurements puts the measurements in the cache.
+func importMeasurements(path string, mm []*model.Measurement) error {
+	c, err := cache.Open(path)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	for _, m := range mm {
+		if err := c.Put(m); err != nil {
+			return err
+		}
+	}
+	log.Printf("imported %d measurements into %s", len(mm), path)
+	return nil
+}
+
+func main() {
+	o := &options{}
+	o.Parse()
+	if pflag.NArg() == 0 {
+		pflag.Usage()
+		os.Exit(2)
+	}
+	switch o.Format {
+	case "json", "ndjson", "none":
+	default:
+		log.Fatalf("unknown format %s, expected json, ndjson or none", o.Format)
+	}
+
+	var all []*model.Measurement
+	invalid := 0
+	for _, name := range pflag.Args() {
+		records, err := readRecords(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c := &checker{p1: iec.Protocol(o.Protocol) == iec.ProtocolP1}
+		c.check(records)
+		c.report(os.Stderr, name, o.ErrorsOnly)
+		invalid += c.invalid()
+		all = append(all, c.measurements...)
+	}
+	fmt.Fprintf(os.Stderr, "%d measurements, %d frames with errors\n", len(all), invalid)
+
+	out := os.Stdout
+	if len(o.Output) > 0 {
+		f, err := os.Create(o.Output)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := writeMeasurements(out, o.Format, all); err != nil {
+		log.Fatal(err)
+	}
+	if len(o.Import) > 0 {
+		if err := importMeasurements(o.Import, all); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if invalid > 0 {
+		out.Close()
+		os.Exit(1)
+	}
+}
@@ -4,6 +4,7 @@ go 1.22.5
 
 require (
 	github.com/augustoroman/hexdump v0.0.0-20231204223853-3694912baadb
+	github.com/golang/snappy v0.0.0-20180518054509-2e65f85255db
 	github.com/spf13/pflag v1.0.5
 	github.com/syndtr/goleveldb v1.0.0
 	go.bug.st/serial.v1 v0.0.0-20191202182710-24a6610f0541
@@ -11,6 +12,5 @@ require (
 
 require (
 	github.com/creack/goselect v0.1.2 // indirect
-	github.com/golang/snappy v0.0.0-20180518054509-2e65f85255db // indirect
 	golang.org/x/sys v0.22.0 // indirect
 )
@@ -0,0 +1,175 @@
+// Package capture records the raw bytes exchanged with a meter and replays
+// them. A capture is a text file with one record per line:
+//
+//	2020-01-02T15:04:05.123456789Z TX 2f3f210d0a
+//	2020-01-02T15:04:05.412345678Z RX 2f49534b35
+//
+// Lines starting with # are comments. The data is hex encoded, so captures
+// can be edited and attached to issues.
+package capture
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Direction of the bytes, seen from the computer.
+type Direction int
+
+const (
+	// TX bytes were sent to the meter.
+	TX Direction = iota
+	// RX bytes were received from the meter.
+	RX
+)
+
+func (d Direction) String() string {
+	if d == TX {
+		return "TX"
+	}
+	return "RX"
+}
+
+// ErrBadRecord is returned for a line that is not a valid record.
+var ErrBadRecord = errors.New("bad capture record")
+
+// Header is written at the start of every capture.
+const Header = "# iec62056 capture v1"
+
+// Record is a chunk of bytes as read from or written to the port.
+type Record struct {
+	Time      time.Time
+	Direction Direction
+	Data      []byte
+}
+
+func (r Record) String() string {
+	return fmt.Sprintf("%s %s %s", r.Time.UTC().Format(time.RFC3339Nano), r.Direction, hex.EncodeToString(r.Data))
+}
+
+// ParseRecord parses one line of a capture.
+func ParseRecord(line string) (*Record, error) {
+	f := strings.Fields(line)
+	if len(f) != 3 {
+		return nil, ErrBadRecord
+	}
+	t, err := time.Parse(time.RFC3339Nano, f[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrBadRecord, err.Error())
+	}
+	r := &Record{Time: t}
+	switch f[1] {
+	case "TX":
+		r.Direction = TX
+	case "RX":
+		r.Direction = RX
+	default:
+		return nil, ErrBadRecord
+	}
+	if r.Data, err = hex.DecodeString(f[2]); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrBadRecord, err.Error())
+	}
+	return r, nil
+}
+
+// Writer writes records to a capture, safe for concurrent use.
+type Writer struct {
+	lock   sync.Mutex
+	w      io.Writer
+	header bool
+	// now is replaced in tests.
+	now func() time.Time
+}
+
+// NewWriter creates a writer that writes the capture to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, now: time.Now}
+}
+
+// Record writes the bytes with the current time.
+func (w *Writer) Record(d Direction, b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	return w.Write(&Record{Time: w.now(), Direction: d, Data: b})
+}
+
+// Write writes the record, the capture starts with the header.
+func (w *Writer) Write(r *Record) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(w.w, r.String())
+	return err
+}
+
+func (w *Writer) writeHeader() error {
+	if w.header {
+		return nil
+	}
+	if _, err := fmt.Fprintln(w.w, Header); err != nil {
+		return err
+	}
+	w.header = true
+	return nil
+}
+
+// Comment writes a comment line, e.g. to mark the port settings.
+func (w *Writer) Comment(s string) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w.w, "# %s\n", s)
+	return err
+}
+
+// Read reads all records from a capture.
+func Read(r io.Reader) ([]*Record, error) {
+	var res []*Record
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rec, err := ParseRecord(line)
+		if err != nil {
+			return res, fmt.Errorf("line %d: %w", n, err)
+		}
+		res = append(res, rec)
+	}
+	return res, s.Err()
+}
+
+// Load reads all records from a capture file.
+func Load(filename string) ([]*Record, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Received returns the concatenated RX bytes of the records.
+func Received(records []*Record) []byte {
+	var b []byte
+	for _, r := range records {
+		if r.Direction == RX {
+			b = append(b, r.Data...)
+		}
+	}
+	return b
+}
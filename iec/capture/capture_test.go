@@ -0,0 +1,112 @@
+package capture
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)
+
+func testRecords() []*Record {
+	return []*Record{
+		{Time: t0, Direction: TX, Data: []byte("/?!\r\n")},
+		{Time: t0.Add(200 * time.Millisecond), Direction: RX, Data: []byte("/MAN")},
+		{Time: t0.Add(300 * time.Millisecond), Direction: RX, Data: []byte("5\r\n")},
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	for _, r := range testRecords() {
+		if err := w.Write(r); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	rr, err := Read(&b)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(rr) != 3 {
+		t.Fatalf("expected %v, received %v", 3, len(rr))
+	}
+	for i, r := range testRecords() {
+		if !r.Time.Equal(rr[i].Time) || r.Direction != rr[i].Direction || !bytes.Equal(r.Data, rr[i].Data) {
+			t.Errorf("expected %v, received %v", r, rr[i])
+		}
+	}
+	if s := string(Received(rr)); s != "/MAN5\r\n" {
+		t.Errorf("expected %q, received %q", "/MAN5\r\n", s)
+	}
+}
+
+func TestParseRecordBad(t *testing.T) {
+	for _, l := range []string{
+		"",
+		"2020-01-02T15:04:05Z XX 00",
+		"yesterday RX 00",
+		"2020-01-02T15:04:05Z RX 0g",
+	} {
+		if _, err := ParseRecord(l); err == nil {
+			t.Errorf("expected an error for %q", l)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	r := NewReplay(testRecords(), 0)
+	r.Write([]byte("/?!\r\n"))
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if string(b) != "/MAN5\r\n" {
+		t.Errorf("expected %q, received %q", "/MAN5\r\n", b)
+	}
+	if string(r.Written()) != "/?!\r\n" {
+		t.Errorf("expected %q, received %q", "/?!\r\n", r.Written())
+	}
+}
+
+func TestReplayTiming(t *testing.T) {
+	r := NewReplay(testRecords(), 2)
+	start := time.Now()
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	// The last record is at 300 ms, replayed twice as fast.
+	if d := time.Since(start); d < 150*time.Millisecond || d > time.Second {
+		t.Errorf("expected about 150ms, received %v", d)
+	}
+}
+
+func TestReplayClose(t *testing.T) {
+	r := NewReplay(testRecords(), 0.001)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		r.Close()
+	}()
+	if _, err := r.Read(make([]byte, 10)); err != io.EOF {
+		t.Errorf("expected %v, received %v", io.EOF, err)
+	}
+}
+
+func TestTap(t *testing.T) {
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	p := Tap(NewReplay(testRecords(), 0), w)
+	p.Write([]byte("/?!\r\n"))
+	if _, err := ioutil.ReadAll(p); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	rr, err := Read(&b)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(rr) != 3 || rr[0].Direction != TX || string(Received(rr)) != "/MAN5\r\n" {
+		t.Errorf("unexpected records: %v", rr)
+	}
+}
@@ -0,0 +1,174 @@
+package capture
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"go.bug.st/serial.v1"
+)
+
+// tap records the bytes read from and written to a port.
+type tap struct {
+	serial.Port
+	w *Writer
+}
+
+// Tap returns a port that records all reads and writes on port to w.
+func Tap(port serial.Port, w *Writer) serial.Port {
+	return &tap{Port: port, w: w}
+}
+
+func (t *tap) SetMode(mode *serial.Mode) error {
+	t.w.Comment(fmt.Sprintf("mode %d", mode.BaudRate))
+	return t.Port.SetMode(mode)
+}
+
+func (t *tap) Read(p []byte) (int, error) {
+	n, err := t.Port.Read(p)
+	t.w.Record(RX, p[:n])
+	return n, err
+}
+
+func (t *tap) Write(p []byte) (int, error) {
+	n, err := t.Port.Write(p)
+	t.w.Record(TX, p[:n])
+	return n, err
+}
+
+// Replay is a serial.Port that returns the received bytes of a capture.
+// The bytes written to the port are kept and can be compared with the capture.
+type Replay struct {
+	// Speed scales the timing of the capture, 1 is the original timing,
+	// 2 is twice as fast. Zero or less returns the bytes without delay.
+	Speed float64
+
+	lock    sync.Mutex
+	records []*Record
+	// next record to return and the unread part of the current record.
+	next    int
+	pending []byte
+	// start of the replay, set by the first read or write.
+	start   time.Time
+	written bytes.Buffer
+	closed  chan struct{}
+}
+
+var _ serial.Port = &Replay{}
+
+// NewReplay creates a replay of the records with the speed.
+func NewReplay(records []*Record, speed float64) *Replay {
+	return &Replay{
+		Speed:   speed,
+		records: records,
+		closed:  make(chan struct{}),
+	}
+}
+
+// Written returns the bytes written to the replay.
+func (r *Replay) Written() []byte {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]byte(nil), r.written.Bytes()...)
+}
+
+func (r *Replay) begin() {
+	if r.start.IsZero() {
+		r.start = time.Now()
+	}
+}
+
+// due returns the time the record must be returned.
+func (r *Replay) due(rec *Record) time.Time {
+	if r.Speed <= 0 || len(r.records) == 0 {
+		return time.Time{}
+	}
+	offset := rec.Time.Sub(r.records[0].Time)
+	return r.start.Add(time.Duration(float64(offset) / r.Speed))
+}
+
+// Read returns the received bytes of the capture. It blocks until the time of
+// the record and returns io.EOF at the end of the capture or after Close.
+func (r *Replay) Read(p []byte) (int, error) {
+	r.lock.Lock()
+	r.begin()
+	if len(r.pending) == 0 {
+		for r.next < len(r.records) && r.records[r.next].Direction != RX {
+			r.next++
+		}
+		if r.next == len(r.records) {
+			r.lock.Unlock()
+			return 0, io.EOF
+		}
+		rec := r.records[r.next]
+		r.next++
+		r.pending = rec.Data
+		due := r.due(rec)
+		r.lock.Unlock()
+		if d := time.Until(due); d > 0 {
+			t := time.NewTimer(d)
+			defer t.Stop()
+			select {
+			case <-t.C:
+			case <-r.closed:
+				return 0, io.EOF
+			}
+		}
+		r.lock.Lock()
+	}
+	defer r.lock.Unlock()
+	select {
+	case <-r.closed:
+		return 0, io.EOF
+	default:
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// Write keeps the bytes, see Written.
+func (r *Replay) Write(p []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.begin()
+	return r.written.Write(p)
+}
+
+func (r *Replay) SetMode(mode *serial.Mode) error {
+	return nil
+}
+
+func (r *Replay) ResetInputBuffer() error {
+	return nil
+}
+
+func (r *Replay) ResetOutputBuffer() error {
+	return nil
+}
+
+func (r *Replay) SetDTR(dtr bool) error {
+	return nil
+}
+
+func (r *Replay) SetRTS(rts bool) error {
+	return nil
+}
+
+func (r *Replay) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	return &serial.ModemStatusBits{}, nil
+}
+
+// Close ends the replay, blocked reads return io.EOF.
+func (r *Replay) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	select {
+	case <-r.closed:
+	default:
+		close(r.closed)
+	}
+	return nil
+}
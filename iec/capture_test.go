@@ -0,0 +1,42 @@
+package iec
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/iec/capture"
+)
+
+func TestReadCaptureReplay(t *testing.T) {
+	var b bytes.Buffer
+	p := New(nil)
+	p.Capture = capture.NewWriter(&b)
+	t0 := time.Now()
+	records := []*capture.Record{
+		{Time: t0, Direction: capture.TX, Data: []byte("/?!\r\n")},
+		{Time: t0, Direction: capture.RX, Data: []byte(immediateResponse)},
+	}
+	if err := p.Attach(capture.NewReplay(records, 0)); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	defer p.Close()
+	dm, err := p.Read()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if dm.ManufacturerID != "MAN" || len(dm.DataSets) != 2 {
+		t.Errorf("unexpected message: %+v", dm)
+	}
+	// The capture of the replay contains the request and the response.
+	rr, err := capture.Read(&b)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(rr) < 2 || rr[0].Direction != capture.TX || string(rr[0].Data) != "/?!\r\n" {
+		t.Errorf("unexpected records: %v", rr)
+	}
+	if string(capture.Received(rr)) != immediateResponse {
+		t.Errorf("expected %q, received %q", immediateResponse, capture.Received(rr))
+	}
+}
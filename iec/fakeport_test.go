@@ -0,0 +1,69 @@
+package iec
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"go.bug.st/serial.v1"
+)
+
+// fakePort implements serial.Port and records the calls made on it.
+type fakePort struct {
+	in     io.Reader
+	out    bytes.Buffer
+	calls  []string
+	mode   serial.Mode
+	closed bool
+	// closes counts the calls of Close.
+	closes int
+}
+
+var _ serial.Port = &fakePort{}
+
+func newFakePort(response string) *fakePort {
+	return &fakePort{in: bytes.NewBufferString(response)}
+}
+
+func (f *fakePort) SetMode(mode *serial.Mode) error {
+	f.mode = *mode
+	f.calls = append(f.calls, fmt.Sprintf("mode %d", mode.BaudRate))
+	return nil
+}
+
+func (f *fakePort) Read(p []byte) (int, error) {
+	return f.in.Read(p)
+}
+
+func (f *fakePort) Write(p []byte) (int, error) {
+	f.calls = append(f.calls, fmt.Sprintf("write %q", p))
+	return f.out.Write(p)
+}
+
+func (f *fakePort) ResetInputBuffer() error {
+	return nil
+}
+
+func (f *fakePort) ResetOutputBuffer() error {
+	return nil
+}
+
+func (f *fakePort) SetDTR(dtr bool) error {
+	f.calls = append(f.calls, fmt.Sprintf("dtr %t", dtr))
+	return nil
+}
+
+func (f *fakePort) SetRTS(rts bool) error {
+	f.calls = append(f.calls, fmt.Sprintf("rts %t", rts))
+	return nil
+}
+
+func (f *fakePort) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	return &serial.ModemStatusBits{}, nil
+}
+
+func (f *fakePort) Close() error {
+	f.closed = true
+	f.closes++
+	return nil
+}
@@ -2,9 +2,12 @@ package iec
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"log"
+	"time"
 
+	"github.com/peterzandbergen/iec62056/iec/capture"
 	"github.com/peterzandbergen/iec62056/iec/telegram"
 	"go.bug.st/serial.v1"
 )
@@ -14,6 +17,9 @@ var (
 	ErrPortOpenFailed = errors.New("Could not open the serial port")
 )
 
+// openPort opens the serial device, replaced in tests.
+var openPort = serial.Open
+
 // PortSettings contains the settings for opening a new port.
 type PortSettings struct {
 	BaudRateChangeDelay    int
@@ -23,6 +29,30 @@ type PortSettings struct {
 	Verbose                bool
 	// Name of the port.
 	PortName string
+	// Protocol spoken by the meter, defaults to ProtocolModeC.
+	Protocol Protocol
+	// Framing of the characters, for example 7E1 or 8N1. Defaults to 7E1.
+	// P1 and SML meters push at a fixed speed, InitialBaudRateModeABC
+	// is used as the line speed for these protocols.
+	Framing string
+	// CheckBcc verifies the block check character of mode C data messages.
+	// Off by default, not all meters send a correct one.
+	CheckBcc bool
+	// ManufacturerID of the expected meter, optional. Selects the quirks
+	// before the meter has identified itself, e.g. the wake up sequence.
+	ManufacturerID string
+	// DTR and RTS levels applied when the port is opened.
+	// Some optical probes are powered from DTR.
+	DTR LineState
+	RTS LineState
+	// RTSOnTransmit raises RTS for the duration of every write, as needed by
+	// RS-485 converters without automatic direction control.
+	RTSOnTransmit bool
+	// RTSTurnOnDelay is the time in ms between raising RTS and the first byte.
+	RTSTurnOnDelay int
+	// RTSTurnOffDelay is the time in ms between the last byte leaving the port
+	// and dropping RTS.
+	RTSTurnOffDelay int
 }
 
 // Port that can send and receive messages to the energy meter.
@@ -33,9 +63,26 @@ type Port struct {
 	InitialBaudRateModeD   int
 	Timeout                int
 	Verbose                bool
+	Protocol               Protocol
+	Framing                string
+	ManufacturerID         string
+	CheckBcc               bool
+	// Quirks by manufacturer, DefaultQuirks if nil.
+	Quirks *QuirksRegistry
+	// Capture records the raw bytes of the port when set before Open.
+	Capture *capture.Writer
+	// Line control.
+	DTR             LineState
+	RTS             LineState
+	RTSOnTransmit   bool
+	RTSTurnOnDelay  int
+	RTSTurnOffDelay int
 
 	// Serial port
 	port serial.Port
+	// aborted is set when Abort closed the port, Close does not close it
+	// again.
+	aborted bool
 	// Current mode.
 	mode *serial.Mode
 	// Buffered
@@ -64,22 +111,59 @@ func New(settings *PortSettings) *Port {
 		InitialBaudRateModeD:   settings.InitialBaudRateModeD,
 		Timeout:                settings.Timeout,
 		Verbose:                settings.Verbose,
+		Protocol:               settings.Protocol,
+		Framing:                settings.Framing,
+		ManufacturerID:         settings.ManufacturerID,
+		CheckBcc:               settings.CheckBcc,
+		DTR:                    settings.DTR,
+		RTS:                    settings.RTS,
+		RTSOnTransmit:          settings.RTSOnTransmit,
+		RTSTurnOnDelay:         settings.RTSTurnOnDelay,
+		RTSTurnOffDelay:        settings.RTSTurnOffDelay,
 	}
 }
 
 // Open the serial port using the settings.
 // Each character consists of one start bit ( binary = 0 ), 7 data bits, normally one even parity bit and one stop bit ( binary = 1 )
 func (p *Port) Open(portName string) error {
-	p.mode = &serial.Mode{
-		BaudRate: p.InitialBaudRateModeABC,
-		DataBits: 7,
-		Parity:   serial.EvenParity,
-		StopBits: serial.OneStopBit,
-	}
 	var err error
-	p.port, err = serial.Open(portName, p.mode)
+	p.mode, err = ParseFraming(p.Framing)
+	if err != nil {
+		return err
+	}
+	p.mode.BaudRate = p.InitialBaudRateModeABC
+	port, err := openPort(portName, p.mode)
 	if err != nil {
 		log.Printf("cannot open serial port: %s", portName)
+		return err
+	}
+	if err = p.attach(port); err != nil {
+		log.Printf("cannot set the modem control lines: %s", portName)
+		return err
+	}
+	return nil
+}
+
+// Attach uses an open serial port or another transport, e.g. a capture.Replay,
+// instead of opening a device.
+func (p *Port) Attach(port serial.Port) error {
+	var err error
+	p.mode, err = ParseFraming(p.Framing)
+	if err != nil {
+		return err
+	}
+	p.mode.BaudRate = p.InitialBaudRateModeABC
+	return p.attach(port)
+}
+
+func (p *Port) attach(port serial.Port) error {
+	if p.Capture != nil {
+		port = capture.Tap(port, p.Capture)
+	}
+	p.port = port
+	p.aborted = false
+	if err := p.applyLineState(); err != nil {
+		p.port.Close()
 		p.port = nil
 		return err
 	}
@@ -88,13 +172,26 @@ func (p *Port) Open(portName string) error {
 	return nil
 }
 
+// Abort closes the serial port, which makes a blocked Read return with an
+// error. Call Close after the Read has returned to release the port, it
+// does not close the port again.
+func (p *Port) Abort() {
+	if p.port != nil {
+		p.port.Close()
+		p.aborted = true
+	}
+}
+
 func (p *Port) Close() {
 	if p.port == nil {
 		return
 	}
-	p.port.Close()
+	if !p.aborted {
+		p.port.Close()
+	}
 	p.port = nil
 	p.r = nil
+	p.aborted = false
 }
 
 func readAckResponse(r *bufio.Reader) (*DataMessage, error) {
@@ -130,14 +227,28 @@ func readAckResponse(r *bufio.Reader) (*DataMessage, error) {
 }
 
 func readImmediateResponse(r *bufio.Reader) (*DataMessage, error) {
+	return readImmediateResponseQuirks(r, DefaultQuirks, "", false)
+}
+
+// readImmediateResponseQuirks parses the identification with the quirks of the
+// expected manufacturer and the data with the quirks of the identified manufacturer.
+// With checkBcc set a data message with a wrong BCC returns telegram.ErrBadChecksum.
+func readImmediateResponseQuirks(r *bufio.Reader, quirks *QuirksRegistry, manID string, checkBcc bool) (*DataMessage, error) {
 	// Wait for the Identification Message.
-	im, err := telegram.ParseIdentificationMessage(r)
+	im, err := quirks.Lookup(manID).Parser.ParseIdentificationMessage(r)
 	if err != nil {
 		return nil, err
 	}
 
 	// Wait for the Data.
-	dm, err := telegram.ParseDataMessage(r)
+	frame, err := telegram.ReadDataFrame(r)
+	if err != nil {
+		return nil, err
+	}
+	if checkBcc && !telegram.CheckBcc(frame) {
+		return nil, telegram.ErrBadChecksum
+	}
+	dm, err := quirks.Lookup(im.ManID).Parser.ParseDataMessage(bufio.NewReader(bytes.NewReader(frame)))
 	if err != nil {
 		return nil, err
 	}
@@ -157,15 +268,55 @@ func readImmediateResponse(r *bufio.Reader) (*DataMessage, error) {
 	return res, nil
 }
 
+// Read reads a message from the meter using the protocol from the settings.
 func (p *Port) Read() (*DataMessage, error) {
+	switch p.Protocol {
+	case ProtocolP1:
+		return readP1Telegram(p.r)
+	case ProtocolSML:
+		return readSMLFrame(p.r)
+	case ProtocolModeC, "":
+		return p.readModeC()
+	}
+	return nil, ErrUnknownProtocol
+}
+
+// ReadTimeout reads a message like Read, but returns ErrReadTimeout when it
+// takes longer than timeout. The port is closed after a timeout.
+func (p *Port) ReadTimeout(timeout time.Duration) (*DataMessage, error) {
+	return p.readTimeout(p.Read, timeout)
+}
+
+func (p *Port) readModeC() (*DataMessage, error) {
 	// Set the baudrate to 300
 	p.mode.BaudRate = p.InitialBaudRateModeABC
 	p.port.SetMode(p.mode)
 
 	// Send a request command.
-	_, err := telegram.SerializeRequestMessage(p.port, telegram.RequestMessage{})
-	if err != nil {
+	if err := p.sendRequest(); err != nil {
 		return nil, err
 	}
-	return readImmediateResponse(p.r)
+	return readImmediateResponseQuirks(p.r, p.quirks(), p.ManufacturerID, p.CheckBcc)
+}
+
+func (p *Port) quirks() *QuirksRegistry {
+	if p.Quirks == nil {
+		return DefaultQuirks
+	}
+	return p.Quirks
+}
+
+// sendRequest sends the request message, preceded by the wake up sequence
+// of the expected manufacturer.
+func (p *Port) sendRequest() error {
+	q := p.quirks().Lookup(p.ManufacturerID)
+	if len(q.WakeUp) > 0 {
+		if _, err := p.write(q.WakeUp); err != nil {
+			return err
+		}
+		sleepMillis(q.WakeUpDelay)
+	}
+	sleepMillis(q.InterFrameDelay)
+	_, err := telegram.SerializeRequestMessage(writerFunc(p.write), telegram.RequestMessage{})
+	return err
 }
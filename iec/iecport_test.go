@@ -269,3 +269,28 @@ func TestRead(t *testing.T) {
 	}
 	t.Logf("message: %+v", m)
 }
+
+func TestReadCheckBcc(t *testing.T) {
+	// The test data message has a wrong BCC.
+	r := bufio.NewReader(bytes.NewReader([]byte(immediateResponse)))
+	if _, err := readImmediateResponseQuirks(r, DefaultQuirks, "", true); err != telegram.ErrBadChecksum {
+		t.Errorf("expected %v, received %v", telegram.ErrBadChecksum, err)
+	}
+}
+
+func TestAbortClose(t *testing.T) {
+	f := newFakePort("")
+	p := New(NewDefaultSettings())
+	if err := p.Attach(f); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	p.Abort()
+	p.Close()
+	// Closing twice could close an fd that was reused meanwhile.
+	if f.closes != 1 {
+		t.Errorf("expected %v, received %v", 1, f.closes)
+	}
+	if p.port != nil || p.r != nil {
+		t.Errorf("expected %v, received %v", "a released port", p.port)
+	}
+}
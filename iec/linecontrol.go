@@ -0,0 +1,127 @@
+package iec
+
+import (
+	"errors"
+	"time"
+
+	"go.bug.st/serial.v1"
+)
+
+// LineState is the requested level of a modem control line.
+type LineState int
+
+const (
+	// LineUnchanged leaves the line at the level set by the driver.
+	LineUnchanged LineState = iota
+	// LineOn sets the line active.
+	LineOn
+	// LineOff sets the line inactive.
+	LineOff
+)
+
+func (l LineState) String() string {
+	switch l {
+	case LineOn:
+		return "on"
+	case LineOff:
+		return "off"
+	}
+	return "unchanged"
+}
+
+// ErrBadLineState is returned for a line state that cannot be parsed.
+var ErrBadLineState = errors.New("bad line state, expected on, off or unchanged")
+
+// writerFunc adapts a write function to an io.Writer.
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(b []byte) (int, error) {
+	return f(b)
+}
+
+// applyLineState sets DTR and RTS to the levels from the settings.
+func (p *Port) applyLineState() error {
+	if p.DTR != LineUnchanged {
+		if err := p.port.SetDTR(p.DTR == LineOn); err != nil {
+			return err
+		}
+	}
+	// With RTS on transmit the line is idle low between writes.
+	switch {
+	case p.RTSOnTransmit:
+		return p.port.SetRTS(false)
+	case p.RTS != LineUnchanged:
+		return p.port.SetRTS(p.RTS == LineOn)
+	}
+	return nil
+}
+
+// write sends b to the port. With RTSOnTransmit set, RTS is raised before the
+// first byte and dropped after the last byte has left the port.
+func (p *Port) write(b []byte) (int, error) {
+	if !p.RTSOnTransmit {
+		return p.port.Write(b)
+	}
+	if err := p.port.SetRTS(true); err != nil {
+		return 0, err
+	}
+	sleepMillis(p.RTSTurnOnDelay)
+	n, err := p.port.Write(b)
+	// Write returns when the bytes are queued, wait until they are sent.
+	time.Sleep(transmitTime(p.mode, n))
+	sleepMillis(p.RTSTurnOffDelay)
+	if rerr := p.port.SetRTS(false); err == nil {
+		err = rerr
+	}
+	return n, err
+}
+
+func sleepMillis(ms int) {
+	if ms > 0 {
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+	}
+}
+
+// transmitTime returns the time it takes to send n characters in mode.
+func transmitTime(mode *serial.Mode, n int) time.Duration {
+	if mode == nil || mode.BaudRate <= 0 {
+		return 0
+	}
+	// Start bit, data bits, parity bit and stop bits.
+	bits := 1 + mode.DataBits + 1
+	if mode.Parity != serial.NoParity {
+		bits++
+	}
+	if mode.StopBits != serial.OneStopBit {
+		bits++
+	}
+	return time.Duration(n*bits) * time.Second / time.Duration(mode.BaudRate)
+}
+
+// ParseLineState returns the line state for on, off or unchanged.
+func ParseLineState(s string) (LineState, error) {
+	switch s {
+	case "on", "high", "1", "true":
+		return LineOn, nil
+	case "off", "low", "0", "false":
+		return LineOff, nil
+	case "unchanged", "":
+		return LineUnchanged, nil
+	}
+	return LineUnchanged, ErrBadLineState
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (l LineState) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (l *LineState) UnmarshalText(b []byte) error {
+	v, err := ParseLineState(string(b))
+	if err != nil {
+		return err
+	}
+	*l = v
+	return nil
+}
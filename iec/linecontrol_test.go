@@ -0,0 +1,75 @@
+package iec
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.bug.st/serial.v1"
+)
+
+func TestApplyLineState(t *testing.T) {
+	fp := newFakePort("")
+	p := New(&PortSettings{DTR: LineOn, RTS: LineOff})
+	p.port = fp
+	if err := p.applyLineState(); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	expected := []string{"dtr true", "rts false"}
+	if !reflect.DeepEqual(fp.calls, expected) {
+		t.Errorf("expected %v, received %v", expected, fp.calls)
+	}
+}
+
+func TestApplyLineStateUnchanged(t *testing.T) {
+	fp := newFakePort("")
+	p := New(NewDefaultSettings())
+	p.port = fp
+	if err := p.applyLineState(); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(fp.calls) != 0 {
+		t.Errorf("expected no calls, received %v", fp.calls)
+	}
+}
+
+func TestWriteRTSOnTransmit(t *testing.T) {
+	fp := newFakePort("")
+	p := New(&PortSettings{RTS: LineOn, RTSOnTransmit: true})
+	p.port = fp
+	p.mode = &serial.Mode{BaudRate: 300, DataBits: 7, Parity: serial.EvenParity}
+	if err := p.applyLineState(); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if _, err := p.write([]byte("/?!\r\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	expected := []string{"rts false", "rts true", `write "/?!\r\n"`, "rts false"}
+	if !reflect.DeepEqual(fp.calls, expected) {
+		t.Errorf("expected %v, received %v", expected, fp.calls)
+	}
+}
+
+func TestWriteWithoutRTSOnTransmit(t *testing.T) {
+	fp := newFakePort("")
+	p := New(NewDefaultSettings())
+	p.port = fp
+	if _, err := p.write([]byte("/?!\r\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	expected := []string{`write "/?!\r\n"`}
+	if !reflect.DeepEqual(fp.calls, expected) {
+		t.Errorf("expected %v, received %v", expected, fp.calls)
+	}
+}
+
+func TestTransmitTime(t *testing.T) {
+	// 7E1 is 10 bits per character.
+	mode := &serial.Mode{BaudRate: 300, DataBits: 7, Parity: serial.EvenParity}
+	if d := transmitTime(mode, 30); d != time.Second {
+		t.Errorf("expected %s, received %s", time.Second, d)
+	}
+	if d := transmitTime(nil, 30); d != 0 {
+		t.Errorf("expected 0, received %s", d)
+	}
+}
@@ -0,0 +1,252 @@
+// Package obis parses OBIS codes and describes the common electricity,
+// gas and meter administration addresses.
+package obis
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrBadCode is returned for an address that is not an OBIS code.
+var ErrBadCode = errors.New("bad OBIS code")
+
+// Code is an OBIS code A-B:C.D.E*F. Groups that are absent are -1.
+type Code struct {
+	A, B, C, D, E, F int
+}
+
+// Parse parses the full form A-B:C.D.E*F and the short forms used by
+// meters, e.g. 1.8.0, 1-0:1.8.0 and C.1.0. The letters C and F of the
+// abstract codes are stored as 96 and 97.
+func Parse(address string) (Code, error) {
+	c := Code{A: -1, B: -1, C: -1, D: -1, E: -1, F: -1}
+	s := address
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		ab := strings.SplitN(s[:i], "-", 2)
+		var err error
+		if c.A, err = group(ab[0]); err != nil {
+			return c, err
+		}
+		if len(ab) == 2 {
+			if c.B, err = group(ab[1]); err != nil {
+				return c, err
+			}
+		}
+		s = s[i+1:]
+	}
+	if i := strings.IndexAny(s, "*&"); i >= 0 {
+		var err error
+		if c.F, err = group(s[i+1:]); err != nil {
+			return c, err
+		}
+		s = s[:i]
+	}
+	cde := strings.Split(s, ".")
+	if len(cde) < 2 || len(cde) > 4 {
+		return c, ErrBadCode
+	}
+	groups := []*int{&c.C, &c.D, &c.E, &c.F}
+	for i, g := range cde {
+		v, err := group(g)
+		if err != nil {
+			return c, err
+		}
+		*groups[i] = v
+	}
+	return c, nil
+}
+
+// group parses a value group, the abstract C and F letters included.
+func group(s string) (int, error) {
+	switch s {
+	case "C":
+		return 96, nil
+	case "F":
+		return 97, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 || v > 255 {
+		return 0, fmt.Errorf("%w: %s", ErrBadCode, s)
+	}
+	return v, nil
+}
+
+func (c Code) String() string {
+	var b strings.Builder
+	if c.A >= 0 {
+		fmt.Fprintf(&b, "%d-%d:", c.A, max(c.B, 0))
+	}
+	fmt.Fprintf(&b, "%d.%d", c.C, c.D)
+	if c.E >= 0 {
+		fmt.Fprintf(&b, ".%d", c.E)
+	}
+	if c.F >= 0 && c.F != 255 {
+		fmt.Fprintf(&b, "*%d", c.F)
+	}
+	return b.String()
+}
+
+// Matches returns true if the codes are equal in the groups that both
+// have, so the short form 1.8.0 matches 1-0:1.8.0. An F of 255 is the same
+// as an absent F.
+func (c Code) Matches(o Code) bool {
+	a := []int{c.A, c.B, c.C, c.D, c.E, c.F}
+	b := []int{o.A, o.B, o.C, o.D, o.E, o.F}
+	for i := range a {
+		if i == 5 {
+			if a[i] == 255 {
+				a[i] = -1
+			}
+			if b[i] == 255 {
+				b[i] = -1
+			}
+		}
+		if a[i] >= 0 && b[i] >= 0 && a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// special are the addresses that do not follow the quantity and
+// processing scheme, keyed by C.D.E.
+var special = map[string]string{
+	"0.0.0":   "meter address",
+	"0.0.1":   "meter address",
+	"0.2.0":   "firmware version",
+	"0.2.8":   "firmware signature",
+	"0.9.1":   "time",
+	"0.9.2":   "date",
+	"1.0.0":   "timestamp",
+	"96.1.0":  "equipment identifier",
+	"96.1.1":  "equipment identifier",
+	"96.1.4":  "version",
+	"96.3.10": "breaker state",
+	"96.7.9":  "number of long power failures",
+	"96.7.21": "number of power failures",
+	"96.13.0": "text message",
+	"96.13.1": "text message",
+	"96.14.0": "tariff indicator",
+	"96.50.1": "meter type",
+	"97.97.0": "error register",
+	"99.97.0": "power failure event log",
+	"24.1.0":  "M-Bus device type",
+	"24.2.1":  "M-Bus meter reading",
+	"24.2.3":  "M-Bus meter reading",
+	"24.4.0":  "M-Bus valve position",
+	"97.97.1": "error register",
+}
+
+// quantities by value group C for electricity.
+var quantities = map[int]string{
+	1:  "active energy import",
+	2:  "active energy export",
+	3:  "reactive energy import",
+	4:  "reactive energy export",
+	5:  "reactive energy Q1",
+	6:  "reactive energy Q2",
+	7:  "reactive energy Q3",
+	8:  "reactive energy Q4",
+	9:  "apparent energy import",
+	10: "apparent energy export",
+	13: "power factor",
+	14: "frequency",
+	15: "active energy absolute",
+	16: "active energy net",
+	21: "active energy import L1",
+	22: "active energy export L1",
+	31: "current L1",
+	32: "voltage L1",
+	41: "active energy import L2",
+	42: "active energy export L2",
+	51: "current L2",
+	52: "voltage L2",
+	61: "active energy import L3",
+	62: "active energy export L3",
+	71: "current L3",
+	72: "voltage L3",
+	91: "current neutral",
+}
+
+// powerQuantities replace the energy quantities for instantaneous values.
+var powerQuantities = map[int]string{
+	1:  "active power import",
+	2:  "active power export",
+	3:  "reactive power import",
+	4:  "reactive power export",
+	9:  "apparent power import",
+	10: "apparent power export",
+	15: "active power absolute",
+	16: "active power net",
+	21: "active power import L1",
+	22: "active power export L1",
+	41: "active power import L2",
+	42: "active power export L2",
+	61: "active power import L3",
+	62: "active power export L3",
+}
+
+// processing by value group D.
+var processing = map[int]string{
+	2:  "cumulative maximum",
+	4:  "current average",
+	5:  "last average",
+	6:  "maximum demand",
+	7:  "instantaneous",
+	8:  "total",
+	9:  "period total",
+	32: "number of voltage sags",
+	36: "number of voltage swells",
+}
+
+// Describe returns a description of the address, or an empty string if
+// the address is unknown or not an OBIS code.
+func Describe(address string) string {
+	c, err := Parse(address)
+	if err != nil {
+		return ""
+	}
+	return c.Describe()
+}
+
+// Describe returns a description of the code, or an empty string.
+func (c Code) Describe() string {
+	if c.E >= 0 {
+		if d, ok := special[fmt.Sprintf("%d.%d.%d", c.C, c.D, c.E)]; ok {
+			if c.C == 24 && c.B > 0 {
+				return fmt.Sprintf("%s, channel %d", d, c.B)
+			}
+			return d
+		}
+	}
+	// Reset counter and error register.
+	switch {
+	case c.C == 96 && c.D == 1 && c.E <= 0:
+		return "equipment identifier"
+	case c.C == 97 && c.D == 97:
+		return "error register"
+	}
+	q := quantities[c.C]
+	if c.D == 7 {
+		if p, ok := powerQuantities[c.C]; ok {
+			q = p
+		}
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	p, ok := processing[c.D]
+	if !ok {
+		return q
+	}
+	if c.D == 32 || c.D == 36 {
+		return fmt.Sprintf("%s %s", p, strings.TrimPrefix(q, "voltage "))
+	}
+	res := fmt.Sprintf("%s, %s", q, p)
+	if c.E > 0 && (c.D == 8 || c.D == 9 || c.D == 6 || c.D == 2) {
+		res += fmt.Sprintf(" tariff %d", c.E)
+	}
+	return res
+}
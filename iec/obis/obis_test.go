@@ -0,0 +1,73 @@
+package obis
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	c, err := Parse("1-0:1.8.1*255")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	expected := Code{A: 1, B: 0, C: 1, D: 8, E: 1, F: 255}
+	if c != expected {
+		t.Errorf("expected %v, received %v", expected, c)
+	}
+	if s := c.String(); s != "1-0:1.8.1" {
+		t.Errorf("expected %v, received %v", "1-0:1.8.1", s)
+	}
+	for _, a := range []string{"", "1", "x.8.0", "1-0:1.8.300", "1.2.3.4.5"} {
+		if _, err := Parse(a); err == nil {
+			t.Errorf("expected an error for %q", a)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	for _, tt := range []struct {
+		a, b     string
+		expected bool
+	}{
+		{"1.8.0", "1-0:1.8.0", true},
+		{"1-0:1.8.0*255", "1-0:1.8.0", true},
+		{"1-0:1.7.0", "1.7.0", true},
+		{"1-0:1.7.0", "1-0:2.7.0", false},
+		{"0-1:24.2.1", "0-2:24.2.1", false},
+		{"1.8.1", "1.8.1*1", true},
+		{"1.8.1*2", "1.8.1*1", false},
+	} {
+		a, err := Parse(tt.a)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		b, err := Parse(tt.b)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if m := a.Matches(b); m != tt.expected {
+			t.Errorf("%s and %s: expected %v, received %v", tt.a, tt.b, tt.expected, m)
+		}
+		if m := b.Matches(a); m != tt.expected {
+			t.Errorf("%s and %s: expected %v, received %v", tt.b, tt.a, tt.expected, m)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	for a, expected := range map[string]string{
+		"1.8.0":       "active energy import, total",
+		"1-0:1.8.2":   "active energy import, total tariff 2",
+		"1-0:2.7.0":   "active power export, instantaneous",
+		"1-0:32.7.0":  "voltage L1, instantaneous",
+		"1-0:32.32.0": "number of voltage sags L1",
+		"0-0:96.1.1":  "equipment identifier",
+		"0-1:24.2.1":  "M-Bus meter reading, channel 1",
+		"F.F":         "error register",
+		"C.1.0":       "equipment identifier",
+		"0.9.1":       "time",
+		"1-0:200.1.0": "",
+		"garbage":     "",
+	} {
+		if d := Describe(a); d != expected {
+			t.Errorf("%s: expected %q, received %q", a, expected, d)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build linux || darwin || freebsd || openbsd || netbsd
+
+package portmgr
+
+import "syscall"
+
+// clearExclusive clears the exclusive mode of the terminal.
+func clearExclusive(fd uintptr) {
+	syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCNXCL, 0)
+}
@@ -0,0 +1,6 @@
+//go:build unix && !(linux || darwin || freebsd || openbsd || netbsd)
+
+package portmgr
+
+// clearExclusive is not supported on this system.
+func clearExclusive(fd uintptr) {}
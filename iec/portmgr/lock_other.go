@@ -0,0 +1,17 @@
+//go:build !unix
+
+package portmgr
+
+// osLock is not supported on this system, the serial driver opens the
+// device exclusively.
+type osLock struct{}
+
+func acquireOSLock(dir, device string) (*osLock, error) {
+	return &osLock{}, nil
+}
+
+func (l *osLock) reset() {}
+
+func (l *osLock) release() error {
+	return nil
+}
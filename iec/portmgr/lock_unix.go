@@ -0,0 +1,63 @@
+//go:build unix
+
+package portmgr
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// osLock is an advisory lock on the device node, held as long as the
+// manager owns the device. A device that cannot be opened, e.g. one that
+// is not plugged in yet, is locked with a lock file instead.
+type osLock struct {
+	f *os.File
+	// tty is true for a terminal device.
+	tty bool
+}
+
+// lockFileName returns the name of the lock file for the device.
+func lockFileName(dir, device string) string {
+	if len(dir) == 0 {
+		dir = os.TempDir()
+	}
+	name := strings.ReplaceAll(strings.TrimPrefix(device, "/"), "/", "_")
+	return filepath.Join(dir, "iec62056-"+name+".lock")
+}
+
+func acquireOSLock(dir, device string) (*osLock, error) {
+	// Non blocking, the open does not wait for the carrier.
+	f, err := os.OpenFile(device, os.O_RDONLY|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		if f, err = os.OpenFile(lockFileName(dir, device), os.O_CREATE|os.O_RDWR, 0666); err != nil {
+			return nil, err
+		}
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, ErrDeviceLocked
+		}
+		return nil, err
+	}
+	fi, err := f.Stat()
+	tty := err == nil && fi.Mode()&os.ModeCharDevice != 0
+	return &osLock{f: f, tty: tty}, nil
+}
+
+// reset is called after every request. The serial port opens a terminal
+// in exclusive mode, which is only cleared by the last close; the lock
+// keeps the terminal open, so the next open of the port would fail.
+func (l *osLock) reset() {
+	if l.tty {
+		clearExclusive(l.f.Fd())
+	}
+}
+
+func (l *osLock) release() error {
+	l.reset()
+	syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+	return l.f.Close()
+}
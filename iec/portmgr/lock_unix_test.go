@@ -0,0 +1,46 @@
+//go:build unix
+
+package portmgr
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// TestDeviceLock locks the device node itself, so the lock directory does
+// not matter and other processes that lock the device are kept out.
+func TestDeviceLock(t *testing.T) {
+	dev := filepath.Join(t.TempDir(), "ttyTEST0")
+	if err := os.WriteFile(dev, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	m1 := New()
+	m1.LockDir = t.TempDir()
+	defer m1.Close()
+	m2 := New()
+	m2.LockDir = t.TempDir()
+	defer m2.Close()
+
+	noop := func(ctx context.Context) error { return nil }
+	if err := m1.Do(context.Background(), dev, ClassTimer, noop); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := m2.Do(context.Background(), dev, ClassTimer, noop); err != ErrDeviceLocked {
+		t.Errorf("expected %v, received %v", ErrDeviceLocked, err)
+	}
+	f, err := os.Open(dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != syscall.EWOULDBLOCK {
+		t.Errorf("expected %v, received %v", syscall.EWOULDBLOCK, err)
+	}
+	m1.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		t.Errorf("unexpected error after release: %s", err.Error())
+	}
+}
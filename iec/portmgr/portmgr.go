@@ -0,0 +1,240 @@
+// Package portmgr serialises access to serial devices. Every device is owned
+// by a single worker that runs the queued requests one at a time, so a timer
+// read, an on-demand read and a probe never use the same port at once.
+// The worker holds an OS-level lock on the device node so that a second
+// process that locks the device cannot use it either.
+package portmgr
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+)
+
+// Class of a request. The queues of the classes are served round robin,
+// so a burst of on-demand reads cannot starve the timer.
+type Class int
+
+const (
+	// ClassTimer for the periodic reads.
+	ClassTimer Class = iota
+	// ClassOnDemand for reads requested over HTTP.
+	ClassOnDemand
+	// ClassProbe for probing and port tests.
+	ClassProbe
+
+	numClasses = 3
+)
+
+func (c Class) String() string {
+	switch c {
+	case ClassTimer:
+		return "timer"
+	case ClassOnDemand:
+		return "on-demand"
+	case ClassProbe:
+		return "probe"
+	}
+	return "unknown"
+}
+
+var (
+	// ErrClosed is returned for requests to a closed manager.
+	ErrClosed = errors.New("port manager closed")
+	// ErrDeviceLocked is returned when another process holds the device.
+	ErrDeviceLocked = errors.New("device is locked by another process")
+	// ErrBadClass is returned for an unknown request class.
+	ErrBadClass = errors.New("bad request class")
+)
+
+// Manager owns the serial devices.
+type Manager struct {
+	lock    sync.Mutex
+	devices map[string]*device
+	closed  bool
+	// LockDir contains the lock files for devices that cannot be opened
+	// to lock them, defaults to the temp dir.
+	LockDir string
+}
+
+// New creates a manager.
+func New() *Manager {
+	return &Manager{
+		devices: map[string]*device{},
+	}
+}
+
+type request struct {
+	ctx  context.Context
+	fn   func(ctx context.Context) error
+	done chan error
+}
+
+// device serialises the requests for one device.
+type device struct {
+	name   string
+	lock   sync.Mutex
+	queues [numClasses][]*request
+	// next is the class that is served first.
+	next    Class
+	wake    chan struct{}
+	stop    chan struct{}
+	stopped chan struct{}
+	closed  bool
+	osLock  *osLock
+}
+
+// Do runs fn with exclusive access to the device and returns its error.
+// The request waits in the queue of its class. ctx limits both the wait
+// and the run: if ctx expires while fn runs, Do returns ctx.Err(), but the
+// device is only released when fn returns.
+func (m *Manager) Do(ctx context.Context, name string, class Class, fn func(ctx context.Context) error) error {
+	if class < 0 || class >= numClasses {
+		return ErrBadClass
+	}
+	d, err := m.device(name)
+	if err != nil {
+		return err
+	}
+	r := &request{
+		ctx:  ctx,
+		fn:   fn,
+		done: make(chan error, 1),
+	}
+	d.lock.Lock()
+	if d.closed {
+		d.lock.Unlock()
+		return ErrClosed
+	}
+	d.queues[class] = append(d.queues[class], r)
+	d.lock.Unlock()
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	select {
+	case err := <-r.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// QueueLength returns the number of waiting requests for the device.
+func (m *Manager) QueueLength(name string) int {
+	m.lock.Lock()
+	d, ok := m.devices[deviceKey(name)]
+	m.lock.Unlock()
+	if !ok {
+		return 0
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	n := 0
+	for _, q := range d.queues {
+		n += len(q)
+	}
+	return n
+}
+
+// Close stops the workers and releases the devices. Waiting requests fail with ErrClosed.
+func (m *Manager) Close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	var res error
+	for _, d := range m.devices {
+		close(d.stop)
+		<-d.stopped
+		if err := d.osLock.release(); err != nil && res == nil {
+			res = err
+		}
+	}
+	m.devices = nil
+	return res
+}
+
+// deviceKey resolves links, so a stable name and the device node share a worker.
+func deviceKey(name string) string {
+	if p, err := filepath.EvalSymlinks(name); err == nil {
+		return p
+	}
+	return name
+}
+
+// device returns the worker for the device, starting it on first use.
+func (m *Manager) device(name string) (*device, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.closed {
+		return nil, ErrClosed
+	}
+	key := deviceKey(name)
+	if d, ok := m.devices[key]; ok {
+		return d, nil
+	}
+	l, err := acquireOSLock(m.LockDir, key)
+	if err != nil {
+		return nil, err
+	}
+	d := &device{
+		name:    key,
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		osLock:  l,
+	}
+	m.devices[key] = d
+	go d.serve()
+	return d, nil
+}
+
+// pop returns the next request, serving the classes round robin.
+func (d *device) pop() *request {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for i := 0; i < numClasses; i++ {
+		c := (d.next + Class(i)) % numClasses
+		if len(d.queues[c]) == 0 {
+			continue
+		}
+		r := d.queues[c][0]
+		d.queues[c] = d.queues[c][1:]
+		d.next = (c + 1) % numClasses
+		return r
+	}
+	return nil
+}
+
+func (d *device) serve() {
+	defer close(d.stopped)
+	for {
+		for r := d.pop(); r != nil; r = d.pop() {
+			// Skip requests that expired while waiting.
+			if err := r.ctx.Err(); err != nil {
+				r.done <- err
+				continue
+			}
+			err := r.fn(r.ctx)
+			d.osLock.reset()
+			r.done <- err
+		}
+		select {
+		case <-d.wake:
+		case <-d.stop:
+			d.lock.Lock()
+			d.closed = true
+			for _, q := range d.queues {
+				for _, r := range q {
+					r.done <- ErrClosed
+				}
+			}
+			d.lock.Unlock()
+			return
+		}
+	}
+}
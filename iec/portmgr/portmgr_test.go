@@ -0,0 +1,153 @@
+package portmgr
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDoExclusive(t *testing.T) {
+	m := New()
+	m.LockDir = t.TempDir()
+	defer m.Close()
+
+	var lock sync.Mutex
+	running, max := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(c Class) {
+			defer wg.Done()
+			m.Do(context.Background(), "/dev/ttyTEST0", c, func(ctx context.Context) error {
+				lock.Lock()
+				running++
+				if running > max {
+					max = running
+				}
+				lock.Unlock()
+				time.Sleep(time.Millisecond)
+				lock.Lock()
+				running--
+				lock.Unlock()
+				return nil
+			})
+		}(Class(i % numClasses))
+	}
+	wg.Wait()
+	if max != 1 {
+		t.Errorf("expected at most 1 running request, received %d", max)
+	}
+}
+
+func TestDoRoundRobin(t *testing.T) {
+	m := New()
+	m.LockDir = t.TempDir()
+	defer m.Close()
+
+	// Block the device while the queues fill.
+	block := make(chan struct{})
+	started := make(chan struct{})
+	go m.Do(context.Background(), "/dev/ttyTEST0", ClassTimer, func(ctx context.Context) error {
+		close(started)
+		<-block
+		return nil
+	})
+	<-started
+
+	var lock sync.Mutex
+	var order []Class
+	var wg sync.WaitGroup
+	enqueue := func(c Class) {
+		wg.Add(1)
+		go m.Do(context.Background(), "/dev/ttyTEST0", c, func(ctx context.Context) error {
+			defer wg.Done()
+			lock.Lock()
+			order = append(order, c)
+			lock.Unlock()
+			return nil
+		})
+	}
+	waitQueued := func(n int) {
+		for m.QueueLength("/dev/ttyTEST0") < n {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		enqueue(ClassOnDemand)
+		waitQueued(i + 1)
+	}
+	enqueue(ClassTimer)
+	waitQueued(4)
+	close(block)
+	wg.Wait()
+
+	// The timer was served last, so on-demand is served first, then the timer.
+	expected := []Class{ClassOnDemand, ClassTimer, ClassOnDemand, ClassOnDemand}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("expected %v, received %v", expected, order)
+	}
+}
+
+func TestDoTimeout(t *testing.T) {
+	m := New()
+	m.LockDir = t.TempDir()
+	defer m.Close()
+
+	block := make(chan struct{})
+	defer close(block)
+	started := make(chan struct{})
+	go m.Do(context.Background(), "/dev/ttyTEST0", ClassTimer, func(ctx context.Context) error {
+		close(started)
+		<-block
+		return nil
+	})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	ran := false
+	err := m.Do(ctx, "/dev/ttyTEST0", ClassOnDemand, func(ctx context.Context) error {
+		ran = true
+		return nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected %v, received %v", context.DeadlineExceeded, err)
+	}
+	if ran {
+		t.Error("expired request should not run")
+	}
+}
+
+func TestOSLock(t *testing.T) {
+	dir := t.TempDir()
+	m1 := New()
+	m1.LockDir = dir
+	defer m1.Close()
+	m2 := New()
+	m2.LockDir = dir
+	defer m2.Close()
+
+	noop := func(ctx context.Context) error { return nil }
+	if err := m1.Do(context.Background(), "/dev/ttyTEST0", ClassTimer, noop); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := m2.Do(context.Background(), "/dev/ttyTEST0", ClassTimer, noop); err != ErrDeviceLocked {
+		t.Errorf("expected %v, received %v", ErrDeviceLocked, err)
+	}
+	m1.Close()
+	if err := m2.Do(context.Background(), "/dev/ttyTEST0", ClassTimer, noop); err != nil {
+		t.Errorf("unexpected error after release: %s", err.Error())
+	}
+}
+
+func TestClosed(t *testing.T) {
+	m := New()
+	m.LockDir = t.TempDir()
+	m.Close()
+	err := m.Do(context.Background(), "/dev/ttyTEST0", ClassTimer, func(ctx context.Context) error { return nil })
+	if err != ErrClosed {
+		t.Errorf("expected %v, received %v", ErrClosed, err)
+	}
+}
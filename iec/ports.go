@@ -0,0 +1,85 @@
+package iec
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"go.bug.st/serial.v1"
+	"go.bug.st/serial.v1/enumerator"
+)
+
+// PortInfo describes a serial port on this system.
+type PortInfo struct {
+	// Name of the device node, e.g. /dev/ttyUSB0.
+	Name string
+	// StableName is a path that does not change when the adapter is plugged
+	// into another USB socket, e.g. /dev/serial/by-id/usb-FTDI_..._A1B2C3-if00-port0.
+	// Empty if the system provides no such path.
+	StableName string
+	IsUSB      bool
+	// USB vendor and product ID in hex, and the serial number of the adapter.
+	VID          string
+	PID          string
+	SerialNumber string
+}
+
+// ByIDDir is the directory with the stable device links created by udev.
+var ByIDDir = "/dev/serial/by-id"
+
+// ListPorts returns the serial ports on this system, with USB details and
+// stable names where available.
+func ListPorts() ([]*PortInfo, error) {
+	names, err := serial.GetPortsList()
+	if err != nil {
+		return nil, err
+	}
+	// Details are not available on all systems.
+	details := map[string]*enumerator.PortDetails{}
+	if dl, err := enumerator.GetDetailedPortsList(); err == nil {
+		for _, d := range dl {
+			details[d.Name] = d
+		}
+	}
+	stable := stableNames(ByIDDir)
+	var res []*PortInfo
+	for _, n := range names {
+		pi := &PortInfo{
+			Name:       n,
+			StableName: stable[n],
+		}
+		if d, ok := details[n]; ok {
+			pi.IsUSB = d.IsUSB
+			pi.VID = d.VID
+			pi.PID = d.PID
+			pi.SerialNumber = d.SerialNumber
+		}
+		res = append(res, pi)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res, nil
+}
+
+// stableNames maps the device nodes to the links in dir.
+func stableNames(dir string) map[string]string {
+	res := map[string]string{}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return res
+	}
+	for _, e := range entries {
+		link := filepath.Join(dir, e.Name())
+		target, err := filepath.EvalSymlinks(link)
+		if err != nil {
+			continue
+		}
+		res[target] = link
+	}
+	return res
+}
+
+// ResolvePort returns the device node a stable name points to.
+// Names that are not links are returned unchanged.
+func ResolvePort(name string) (string, error) {
+	return filepath.EvalSymlinks(name)
+}
@@ -0,0 +1,40 @@
+package iec
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStableNames(t *testing.T) {
+	dir := t.TempDir()
+	dev := filepath.Join(dir, "ttyUSB3")
+	if err := os.WriteFile(dev, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	byID := filepath.Join(dir, "by-id")
+	if err := os.Mkdir(byID, 0700); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(byID, "usb-FTDI_FT232R_A1B2C3-if00-port0")
+	if err := os.Symlink("../ttyUSB3", link); err != nil {
+		t.Fatal(err)
+	}
+	names := stableNames(byID)
+	if names[dev] != link {
+		t.Errorf("expected %s, received %s", link, names[dev])
+	}
+	resolved, err := ResolvePort(link)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if resolved != dev {
+		t.Errorf("expected %s, received %s", dev, resolved)
+	}
+}
+
+func TestStableNamesNoDir(t *testing.T) {
+	if names := stableNames(filepath.Join(t.TempDir(), "missing")); len(names) != 0 {
+		t.Errorf("expected no names, received %v", names)
+	}
+}
@@ -0,0 +1,168 @@
+package iec
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProbeCandidate is a combination of line settings and protocol to try on a port.
+type ProbeCandidate struct {
+	Protocol Protocol
+	BaudRate int
+	Framing  string
+}
+
+func (c ProbeCandidate) String() string {
+	return fmt.Sprintf("%s %d %s", c.Protocol, c.BaudRate, c.Framing)
+}
+
+// ParseProbeCandidate parses a candidate written as protocol:baudrate:framing,
+// e.g. p1:115200:8N1.
+func ParseProbeCandidate(s string) (ProbeCandidate, error) {
+	f := strings.Split(s, ":")
+	if len(f) != 3 {
+		return ProbeCandidate{}, fmt.Errorf("bad candidate %q, expected protocol:baudrate:framing", s)
+	}
+	br, err := strconv.Atoi(f[1])
+	if err != nil {
+		return ProbeCandidate{}, fmt.Errorf("bad baudrate in candidate %q: %s", s, err.Error())
+	}
+	if _, err := ParseFraming(f[2]); err != nil {
+		return ProbeCandidate{}, fmt.Errorf("bad framing in candidate %q: %s", s, err.Error())
+	}
+	return ProbeCandidate{
+		Protocol: Protocol(f[0]),
+		BaudRate: br,
+		Framing:  strings.ToUpper(f[2]),
+	}, nil
+}
+
+// DefaultProbeCandidates are the common meter configurations, tried in this order.
+var DefaultProbeCandidates = []ProbeCandidate{
+	{Protocol: ProtocolModeC, BaudRate: 300, Framing: "7E1"},
+	{Protocol: ProtocolP1, BaudRate: 115200, Framing: "8N1"},
+	{Protocol: ProtocolP1, BaudRate: 9600, Framing: "7E1"},
+	{Protocol: ProtocolSML, BaudRate: 9600, Framing: "8N1"},
+}
+
+// ProbeResult contains the outcome of trying one candidate.
+type ProbeResult struct {
+	Candidate      ProbeCandidate
+	ManufacturerID string
+	MeterID        string
+	Err            error
+}
+
+// Ok returns true if the candidate produced a valid identification or telegram.
+func (r *ProbeResult) Ok() bool {
+	return r.Err == nil
+}
+
+var (
+	// ErrNoMeterFound is returned when none of the candidates produced a valid response.
+	ErrNoMeterFound = errors.New("no meter found")
+	// ErrReadTimeout is returned when the meter did not respond in time.
+	ErrReadTimeout = errors.New("timeout reading from meter")
+)
+
+// WithCandidate returns a copy of the settings with the line settings and
+// protocol from the candidate.
+func (s PortSettings) WithCandidate(c ProbeCandidate) *PortSettings {
+	s.Protocol = c.Protocol
+	s.InitialBaudRateModeABC = c.BaudRate
+	s.Framing = c.Framing
+	return &s
+}
+
+// Probe tries the candidates in turn on the port from the settings and returns
+// settings for the first candidate that produces a valid identification or telegram.
+// The results of all tried candidates are returned for reporting.
+// If settings is nil, it uses the default settings. If candidates is empty,
+// DefaultProbeCandidates are used.
+func Probe(settings *PortSettings, candidates []ProbeCandidate, timeout time.Duration) (*PortSettings, []*ProbeResult, error) {
+	if settings == nil {
+		settings = NewDefaultSettings()
+	}
+	if len(candidates) == 0 {
+		candidates = DefaultProbeCandidates
+	}
+	var results []*ProbeResult
+	for _, c := range candidates {
+		s := settings.WithCandidate(c)
+		res := probeCandidate(s, timeout)
+		res.Candidate = c
+		results = append(results, res)
+		if res.Ok() {
+			s.ManufacturerID = res.ManufacturerID
+			return s, results, nil
+		}
+		if settings.Verbose {
+			log.Printf("probe %s on %s: %s", c, settings.PortName, res.Err.Error())
+		}
+	}
+	return nil, results, ErrNoMeterFound
+}
+
+func probeCandidate(s *PortSettings, timeout time.Duration) *ProbeResult {
+	p := New(s)
+	if err := p.Open(s.PortName); err != nil {
+		return &ProbeResult{Err: err}
+	}
+	defer p.Close()
+	dm, err := p.readTimeout(p.identify, timeout)
+	if err != nil {
+		return &ProbeResult{Err: err}
+	}
+	return &ProbeResult{
+		ManufacturerID: dm.ManufacturerID,
+		MeterID:        dm.MeterID,
+	}
+}
+
+// identify reads the identification message in mode C, the complete data
+// message at 300 baud takes too long for probing. Push protocols are read
+// until a valid telegram has been received.
+func (p *Port) identify() (*DataMessage, error) {
+	if p.Protocol != ProtocolModeC && p.Protocol != "" {
+		return p.Read()
+	}
+	if err := p.sendRequest(); err != nil {
+		return nil, err
+	}
+	im, err := p.quirks().Lookup(p.ManufacturerID).Parser.ParseIdentificationMessage(p.r)
+	if err != nil {
+		return nil, err
+	}
+	return &DataMessage{
+		ManufacturerID: im.ManID,
+		MeterID:        im.Identification,
+	}, nil
+}
+
+// readTimeout calls read and closes the port when it takes longer than timeout,
+// which makes the blocked read return.
+func (p *Port) readTimeout(read func() (*DataMessage, error), timeout time.Duration) (*DataMessage, error) {
+	type result struct {
+		dm  *DataMessage
+		err error
+	}
+	rc := make(chan result, 1)
+	go func() {
+		dm, err := read()
+		rc <- result{dm, err}
+	}()
+	select {
+	case r := <-rc:
+		return r.dm, r.err
+	case <-time.After(timeout):
+		// Release the fields after the read has returned.
+		p.Abort()
+		<-rc
+		p.Close()
+		return nil, ErrReadTimeout
+	}
+}
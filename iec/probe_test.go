@@ -0,0 +1,111 @@
+package iec
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/iec/telegram"
+	"go.bug.st/serial.v1"
+)
+
+const p1Telegram = "/CTA5ZIV-METER\r\n\r\n1-0:1.8.1(000051.394*kWh)\r\n!"
+
+// withFakePorts replaces openPort with a function that returns a fake port
+// with the response for the baud rate.
+func withFakePorts(t *testing.T, responses map[int]string) {
+	open := openPort
+	t.Cleanup(func() { openPort = open })
+	openPort = func(name string, mode *serial.Mode) (serial.Port, error) {
+		return newFakePort(responses[mode.BaudRate]), nil
+	}
+}
+
+func p1TelegramWithCRC() string {
+	var crc telegram.Crc16
+	crc.Digest([]byte(p1Telegram)...)
+	return p1Telegram + fmt.Sprintf("%04X\r\n", uint16(crc))
+}
+
+func TestProbe(t *testing.T) {
+	withFakePorts(t, map[int]string{
+		115200: p1TelegramWithCRC(),
+	})
+	s, results, err := Probe(&PortSettings{PortName: "fake"}, nil, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if s.Protocol != ProtocolP1 || s.InitialBaudRateModeABC != 115200 || s.Framing != "8N1" {
+		t.Errorf("unexpected settings: %+v", s)
+	}
+	if s.PortName != "fake" {
+		t.Errorf("expected port name %s, received %s", "fake", s.PortName)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected %d results, received %d", 2, len(results))
+	}
+	if results[0].Ok() {
+		t.Error("expected mode C to fail")
+	}
+	if results[1].ManufacturerID != "CTA" || results[1].MeterID != "ZIV-METER" {
+		t.Errorf("unexpected result: %+v", results[1])
+	}
+}
+
+func TestProbeModeC(t *testing.T) {
+	withFakePorts(t, map[int]string{
+		300: identicationMessage,
+	})
+	s, _, err := Probe(&PortSettings{PortName: "fake"}, nil, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if s.Protocol != ProtocolModeC || s.InitialBaudRateModeABC != 300 {
+		t.Errorf("unexpected settings: %+v", s)
+	}
+}
+
+func TestProbeNoMeter(t *testing.T) {
+	withFakePorts(t, map[int]string{})
+	_, results, err := Probe(&PortSettings{PortName: "fake"}, nil, time.Second)
+	if err != ErrNoMeterFound {
+		t.Fatalf("expected %v, received %v", ErrNoMeterFound, err)
+	}
+	if len(results) != len(DefaultProbeCandidates) {
+		t.Errorf("expected %d results, received %d", len(DefaultProbeCandidates), len(results))
+	}
+}
+
+func TestParseFraming(t *testing.T) {
+	m, err := ParseFraming("8n1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if m.DataBits != 8 || m.Parity != serial.NoParity || m.StopBits != serial.OneStopBit {
+		t.Errorf("unexpected mode: %+v", m)
+	}
+	m, err = ParseFraming("")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if m.DataBits != 7 || m.Parity != serial.EvenParity {
+		t.Errorf("unexpected mode: %+v", m)
+	}
+	if _, err := ParseFraming("9X1"); err != ErrBadFraming {
+		t.Errorf("expected %v, received %v", ErrBadFraming, err)
+	}
+}
+
+func TestParseProbeCandidate(t *testing.T) {
+	c, err := ParseProbeCandidate("p1:115200:8n1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	expected := ProbeCandidate{Protocol: ProtocolP1, BaudRate: 115200, Framing: "8N1"}
+	if c != expected {
+		t.Errorf("expected %+v, received %+v", expected, c)
+	}
+	if _, err := ParseProbeCandidate("p1:fast:8N1"); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,116 @@
+package iec
+
+import (
+	"bufio"
+	"errors"
+	"strings"
+
+	"github.com/peterzandbergen/iec62056/iec/telegram"
+	"go.bug.st/serial.v1"
+)
+
+// Protocol spoken by the meter.
+type Protocol string
+
+const (
+	// ProtocolModeC is IEC 62056-21 mode C, the meter answers a request message.
+	ProtocolModeC = Protocol("mode-c")
+	// ProtocolP1 is the Dutch DSMR P1 port, the meter pushes a telegram periodically.
+	ProtocolP1 = Protocol("p1")
+	// ProtocolSML is the Smart Message Language, the meter pushes binary frames.
+	ProtocolSML = Protocol("sml")
+)
+
+var (
+	// ErrUnknownProtocol is returned for a protocol that is not supported.
+	ErrUnknownProtocol = errors.New("unknown protocol")
+	// ErrBadFraming is returned for a framing that cannot be parsed.
+	ErrBadFraming = errors.New("bad framing, expected data bits, parity and stop bits, e.g. 7E1")
+)
+
+// DefaultFraming is used when the settings contain no framing.
+const DefaultFraming = "7E1"
+
+// ParseFraming returns a serial mode without baud rate for a framing like 7E1 or 8N1.
+func ParseFraming(framing string) (*serial.Mode, error) {
+	if len(framing) == 0 {
+		framing = DefaultFraming
+	}
+	f := strings.ToUpper(framing)
+	if len(f) != 3 {
+		return nil, ErrBadFraming
+	}
+	mode := &serial.Mode{}
+	switch f[0] {
+	case '5', '6', '7', '8':
+		mode.DataBits = int(f[0] - '0')
+	default:
+		return nil, ErrBadFraming
+	}
+	switch f[1] {
+	case 'N':
+		mode.Parity = serial.NoParity
+	case 'E':
+		mode.Parity = serial.EvenParity
+	case 'O':
+		mode.Parity = serial.OddParity
+	default:
+		return nil, ErrBadFraming
+	}
+	switch f[2] {
+	case '1':
+		mode.StopBits = serial.OneStopBit
+	case '2':
+		mode.StopBits = serial.TwoStopBits
+	default:
+		return nil, ErrBadFraming
+	}
+	return mode, nil
+}
+
+func copyDataSets(ds []telegram.DataSet) []DataSet {
+	var res []DataSet
+	for _, m := range ds {
+		res = append(res, DataSet{
+			Address: m.Address,
+			Value:   m.Value,
+			Unit:    m.Unit,
+		})
+	}
+	return res
+}
+
+// readP1Telegram waits for the next P1 telegram.
+func readP1Telegram(r *bufio.Reader) (*DataMessage, error) {
+	tg, err := telegram.ParseP1Telegram(r)
+	if err != nil {
+		return nil, err
+	}
+	return &DataMessage{
+		ManufacturerID: tg.Identification.ManID,
+		MeterID:        tg.Identification.Identification,
+		DataSets:       copyDataSets(tg.DataSets),
+	}, nil
+}
+
+// readSMLFrame waits for the next SML frame with a list response.
+// SML has no manufacturer ID, the server ID is used as the meter ID.
+func readSMLFrame(r *bufio.Reader) (*DataMessage, error) {
+	for {
+		f, err := telegram.ReadSMLFrame(r)
+		if err != nil {
+			return nil, err
+		}
+		l, err := telegram.ParseSMLFrame(f)
+		if err != nil {
+			return nil, err
+		}
+		if len(l.DataSets) == 0 {
+			continue
+		}
+		return &DataMessage{
+			MeterID:  l.ServerID,
+			DataSets: copyDataSets(l.DataSets),
+		}, nil
+	}
+}
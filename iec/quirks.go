@@ -0,0 +1,103 @@
+package iec
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/peterzandbergen/iec62056/iec/telegram"
+)
+
+// HexBytes is a byte sequence that is written as a hex string in JSON.
+type HexBytes []byte
+
+// MarshalText implements encoding.TextMarshaler.
+func (h HexBytes) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(h)), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (h *HexBytes) UnmarshalText(b []byte) error {
+	v, err := hex.DecodeString(string(b))
+	if err != nil {
+		return err
+	}
+	*h = v
+	return nil
+}
+
+// Quirks contains the deviations from the standard of a meter make.
+type Quirks struct {
+	// WakeUp is sent before the request message, e.g. NUL bytes for battery
+	// powered meters.
+	WakeUp HexBytes `json:",omitempty"`
+	// WakeUpDelay is the time in ms between the wake up sequence and the request.
+	WakeUpDelay int `json:",omitempty"`
+	// InterFrameDelay is the time in ms to wait before sending a message to
+	// the meter, for meters that miss messages sent too soon.
+	InterFrameDelay int `json:",omitempty"`
+	// Parser contains the field limits and leniency for the messages.
+	Parser telegram.Parser
+}
+
+// QuirksRegistry holds the quirks by three-letter manufacturer ID.
+type QuirksRegistry struct {
+	lock   sync.RWMutex
+	quirks map[string]*Quirks
+}
+
+// DefaultQuirks is used by ports without their own registry.
+var DefaultQuirks = NewQuirksRegistry()
+
+// NewQuirksRegistry creates an empty registry.
+func NewQuirksRegistry() *QuirksRegistry {
+	return &QuirksRegistry{
+		quirks: map[string]*Quirks{},
+	}
+}
+
+// noQuirks is returned for unknown manufacturers.
+var noQuirks = &Quirks{}
+
+// Register sets the quirks for the manufacturer, replacing earlier quirks.
+func (r *QuirksRegistry) Register(manID string, q *Quirks) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.quirks[manID] = q
+}
+
+// Lookup returns the quirks for the manufacturer. Returns empty quirks for
+// unknown manufacturers, never nil.
+func (r *QuirksRegistry) Lookup(manID string) *Quirks {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if q, ok := r.quirks[manID]; ok {
+		return q
+	}
+	return noQuirks
+}
+
+// Read registers the quirks from a JSON object keyed by manufacturer ID, e.g.
+// {"ABC": {"WakeUp": "0000000000", "Parser": {"MaxValueLength": 64}}}
+func (r *QuirksRegistry) Read(rd io.Reader) error {
+	var m map[string]*Quirks
+	if err := json.NewDecoder(rd).Decode(&m); err != nil {
+		return err
+	}
+	for id, q := range m {
+		r.Register(id, q)
+	}
+	return nil
+}
+
+// Load registers the quirks from a JSON file.
+func (r *QuirksRegistry) Load(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return r.Read(f)
+}
@@ -0,0 +1,74 @@
+package iec
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/peterzandbergen/iec62056/iec/telegram"
+	"go.bug.st/serial.v1"
+)
+
+const quirksJSON = `{"MAN": {"WakeUp": "0000", "Parser": {"MaxValueLength": 64}}}`
+
+func TestQuirksRegistryRead(t *testing.T) {
+	r := NewQuirksRegistry()
+	if err := r.Read(strings.NewReader(quirksJSON)); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	q := r.Lookup("MAN")
+	if string(q.WakeUp) != "\x00\x00" {
+		t.Errorf("expected %q, received %q", "\x00\x00", q.WakeUp)
+	}
+	if q.Parser.MaxValueLength != 64 {
+		t.Errorf("expected %v, received %v", 64, q.Parser.MaxValueLength)
+	}
+	if q := r.Lookup("XYZ"); q == nil || len(q.WakeUp) != 0 {
+		t.Errorf("expected empty quirks, received %+v", q)
+	}
+}
+
+// longValueResponse has a value that exceeds the standard limit of 32 characters.
+const longValueResponse = identicationMessage + string(telegram.StxChar) +
+	"1.8.0(" + "0123456789012345678901234567890123456789" + "*kWh)\r\n" +
+	string(telegram.EndChar) + "\r\n" + string(telegram.EtxChar) + string(telegram.Bcc(0))
+
+func TestReadImmediateResponseQuirks(t *testing.T) {
+	_, err := readImmediateResponse(bufio.NewReader(strings.NewReader(longValueResponse)))
+	if err == nil {
+		t.Fatal("expected an error for the long value")
+	}
+	r := NewQuirksRegistry()
+	r.Register("MAN", &Quirks{Parser: telegram.Parser{MaxValueLength: 64}})
+	dm, err := readImmediateResponseQuirks(bufio.NewReader(strings.NewReader(longValueResponse)), r, "", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(dm.DataSets) != 1 || len(dm.DataSets[0].Value) != 40 {
+		t.Errorf("unexpected data sets: %+v", dm.DataSets)
+	}
+}
+
+func TestReadWakeUp(t *testing.T) {
+	fp := newFakePort(immediateResponse)
+	open := openPort
+	t.Cleanup(func() { openPort = open })
+	openPort = func(name string, mode *serial.Mode) (serial.Port, error) {
+		return fp, nil
+	}
+	r := NewQuirksRegistry()
+	r.Register("MAN", &Quirks{WakeUp: HexBytes{0, 0, 0}})
+	p := New(&PortSettings{ManufacturerID: "MAN"})
+	p.Quirks = r
+	if err := p.Open("fake"); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	defer p.Close()
+	if _, err := p.Read(); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	expected := "\x00\x00\x00/?!\r\n"
+	if fp.out.String() != expected {
+		t.Errorf("expected %q, received %q", expected, fp.out.String())
+	}
+}
@@ -0,0 +1,47 @@
+package iec
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// ReadSettings reads JSON port settings from r. Fields missing from the
+// JSON keep their default value.
+func ReadSettings(r io.Reader) (*PortSettings, error) {
+	s := NewDefaultSettings()
+	if err := json.NewDecoder(r).Decode(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// WriteSettings writes the port settings to w as JSON.
+func WriteSettings(w io.Writer, s *PortSettings) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(s)
+}
+
+// LoadSettings reads the port settings from a JSON file.
+func LoadSettings(filename string) (*PortSettings, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadSettings(f)
+}
+
+// SaveSettings writes the port settings to a JSON file.
+func SaveSettings(filename string, s *PortSettings) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := WriteSettings(f, s); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,279 @@
+package telegram
+
+import (
+	"bufio"
+	"bytes"
+)
+
+// FrameKind is the kind of a frame found in a byte stream.
+type FrameKind int
+
+const (
+	// FrameNoise contains bytes outside a frame.
+	FrameNoise FrameKind = iota
+	// FrameIdentification is a mode C request or identification message.
+	FrameIdentification
+	// FrameData is a mode C data message, STX ... ETX BCC.
+	FrameData
+	// FrameAck is an acknowledgement or option select message.
+	FrameAck
+	// FrameP1 is a DSMR P1 telegram.
+	FrameP1
+	// FrameSML is an SML transport frame.
+	FrameSML
+)
+
+func (k FrameKind) String() string {
+	switch k {
+	case FrameIdentification:
+		return "identification"
+	case FrameData:
+		return "data"
+	case FrameAck:
+		return "ack"
+	case FrameP1:
+		return "p1"
+	case FrameSML:
+		return "sml"
+	}
+	return "noise"
+}
+
+// Control characters that do not start a message.
+const (
+	AckChar = byte(0x06)
+	NakChar = byte(0x15)
+)
+
+var smlStartSequence = append(append([]byte{}, smlEscape...), smlStart...)
+
+// Frame is a complete message as it appeared in the byte stream.
+type Frame struct {
+	Kind FrameKind
+	Data []byte
+}
+
+// FrameContent is the decoded content of a frame.
+type FrameContent struct {
+	ManID          string
+	Identification string
+	DataSets       []DataSet
+}
+
+// Check verifies the syntax and the checksum of the frame. It returns
+// ErrBadChecksum if the frame is complete but the BCC or CRC does not match.
+func (f *Frame) Check() error {
+	_, err := f.Decode()
+	return err
+}
+
+// Decode parses the frame. The content is also returned with ErrBadChecksum.
+func (f *Frame) Decode() (*FrameContent, error) {
+	r := bufio.NewReader(bytes.NewReader(f.Data))
+	switch f.Kind {
+	case FrameIdentification:
+		im, err := ParseIdentificationMessage(r)
+		if err != nil {
+			return nil, err
+		}
+		return &FrameContent{ManID: im.ManID, Identification: im.Identification}, nil
+	case FrameData:
+		dm, err := ParseDataMessage(r)
+		if err != nil {
+			return nil, err
+		}
+		res := &FrameContent{DataSets: *dm.DataSets}
+		if !CheckBcc(f.Data) {
+			return res, ErrBadChecksum
+		}
+		return res, nil
+	case FrameP1:
+		tg, err := ParseP1Telegram(r)
+		if tg == nil {
+			return nil, err
+		}
+		return &FrameContent{
+			ManID:          tg.Identification.ManID,
+			Identification: tg.Identification.Identification,
+			DataSets:       tg.DataSets,
+		}, err
+	case FrameSML:
+		sf, err := ReadSMLFrame(r)
+		if sf == nil {
+			return nil, err
+		}
+		lr, perr := ParseSMLFrame(sf)
+		if perr != nil {
+			if err == nil {
+				err = perr
+			}
+			return nil, err
+		}
+		return &FrameContent{Identification: lr.ServerID, DataSets: lr.DataSets}, err
+	case FrameAck:
+		return &FrameContent{}, nil
+	}
+	return nil, ErrFormatError
+}
+
+// CheckBcc verifies the block check character of a data message, the XOR of
+// the bytes after STX up to and including ETX.
+func CheckBcc(data []byte) bool {
+	start := bytes.IndexByte(data, StxChar)
+	if start < 0 || len(data) < start+2 {
+		return false
+	}
+	var bcc Bcc
+	bcc.Digest(data[start+1 : len(data)-1]...)
+	return byte(bcc) == data[len(data)-1]
+}
+
+// ReadDataFrame reads the bytes of a data message from STX up to and
+// including the block check character. Bytes before STX are skipped.
+func ReadDataFrame(r *bufio.Reader) ([]byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, ErrUnexpectedEOF
+		}
+		if b == StxChar {
+			break
+		}
+	}
+	b, err := r.ReadBytes(EtxChar)
+	if err != nil {
+		return nil, ErrUnexpectedEOF
+	}
+	bcc, err := r.ReadByte()
+	if err != nil {
+		return nil, ErrUnexpectedEOF
+	}
+	return append(append([]byte{StxChar}, b...), bcc), nil
+}
+
+// Splitter splits a byte stream into frames. The bytes can arrive in
+// chunks of any size.
+type Splitter struct {
+	// P1 selects P1 telegrams for messages starting with /, otherwise
+	// they are read as mode C identification messages.
+	P1 bool
+
+	buf []byte
+}
+
+// Write adds the bytes to the stream and returns the completed frames.
+func (s *Splitter) Write(b []byte) []*Frame {
+	s.buf = append(s.buf, b...)
+	var res []*Frame
+	for {
+		f, n := s.next()
+		if n == 0 {
+			return res
+		}
+		res = append(res, f)
+		s.buf = s.buf[n:]
+	}
+}
+
+// Buffered returns the number of bytes of the incomplete frame.
+func (s *Splitter) Buffered() int {
+	return len(s.buf)
+}
+
+// Flush returns the remaining bytes as noise, or nil if there are none.
+func (s *Splitter) Flush() *Frame {
+	if len(s.buf) == 0 {
+		return nil
+	}
+	f := &Frame{Kind: FrameNoise, Data: s.buf}
+	s.buf = nil
+	return f
+}
+
+// start returns the kind of the frame starting at i, or false.
+func (s *Splitter) start(i int) (FrameKind, bool) {
+	switch s.buf[i] {
+	case StartChar:
+		if s.P1 {
+			return FrameP1, true
+		}
+		return FrameIdentification, true
+	case StxChar:
+		return FrameData, true
+	case AckChar, NakChar:
+		return FrameAck, true
+	case smlEscape[0]:
+		if bytes.HasPrefix(s.buf[i:], smlStartSequence) {
+			return FrameSML, true
+		}
+		// Could be the start of a partially received sequence.
+		if bytes.HasPrefix(smlStartSequence, s.buf[i:]) {
+			return FrameSML, true
+		}
+	}
+	return FrameNoise, false
+}
+
+// next returns the first frame in the buffer and its length, 0 if incomplete.
+func (s *Splitter) next() (*Frame, int) {
+	if len(s.buf) == 0 {
+		return nil, 0
+	}
+	kind, ok := s.start(0)
+	if !ok {
+		// Noise till the next start of a frame.
+		for i := 1; i < len(s.buf); i++ {
+			if _, ok := s.start(i); ok {
+				return &Frame{Kind: FrameNoise, Data: s.buf[:i]}, i
+			}
+		}
+		return nil, 0
+	}
+	n := s.frameLength(kind)
+	if n == 0 {
+		return nil, 0
+	}
+	return &Frame{Kind: kind, Data: append([]byte(nil), s.buf[:n]...)}, n
+}
+
+// frameLength returns the length of the frame of kind at the start of the
+// buffer, 0 if it is not complete yet.
+func (s *Splitter) frameLength(kind FrameKind) int {
+	b := s.buf
+	switch kind {
+	case FrameIdentification, FrameAck:
+		if i := bytes.IndexByte(b, LF); i >= 0 {
+			return i + 1
+		}
+		// A NAK or a single ACK is not followed by CR LF.
+		if len(b) > 1 && b[0] != StartChar && (b[1] == StartChar || b[1] == StxChar || b[1] == AckChar || b[1] == NakChar) {
+			return 1
+		}
+	case FrameData:
+		if i := bytes.IndexByte(b, EtxChar); i >= 0 && i+1 < len(b) {
+			return i + 2
+		}
+	case FrameP1:
+		if i := bytes.IndexByte(b, EndChar); i >= 0 {
+			if j := bytes.IndexByte(b[i:], LF); j >= 0 {
+				return i + j + 1
+			}
+		}
+	case FrameSML:
+		if len(b) < len(smlStartSequence) {
+			return 0
+		}
+		// The end sequence is 4 byte aligned, escaped escapes are skipped.
+		for i := len(smlStartSequence); i+8 <= len(b); i += 4 {
+			if !bytes.Equal(b[i:i+4], smlEscape) {
+				continue
+			}
+			if b[i+4] == smlEnd {
+				return i + 8
+			}
+			// Skip the escaped escape sequence.
+			i += 4
+		}
+	}
+	return 0
+}
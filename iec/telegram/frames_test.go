@@ -0,0 +1,82 @@
+package telegram
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// dataMessageWithBcc returns a data message with a valid block check character.
+func dataMessageWithBcc() string {
+	body := "1.8.0(001234.5*kWh)\r\n!\r\n" + string(EtxChar)
+	var bcc Bcc
+	bcc.Digest([]byte(body)...)
+	return string(StxChar) + body + string(byte(bcc))
+}
+
+func TestSplitterModeC(t *testing.T) {
+	stream := "xx/?!\r\n/MAN5identification\r\n" + dataMessageWithBcc()
+	var s Splitter
+	var frames []*Frame
+	// Feed the stream byte by byte.
+	for i := 0; i < len(stream); i++ {
+		frames = append(frames, s.Write([]byte{stream[i]})...)
+	}
+	kinds := []FrameKind{FrameNoise, FrameIdentification, FrameIdentification, FrameData}
+	if len(frames) != len(kinds) {
+		t.Fatalf("expected %v, received %v", len(kinds), len(frames))
+	}
+	for i, k := range kinds {
+		if frames[i].Kind != k {
+			t.Errorf("expected %v, received %v", k, frames[i].Kind)
+		}
+	}
+	fc, err := frames[3].Decode()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(fc.DataSets) != 1 || fc.DataSets[0].Value != "001234.5" {
+		t.Errorf("unexpected content: %+v", fc)
+	}
+	if s.Flush() != nil {
+		t.Error("expected no remaining bytes")
+	}
+}
+
+func TestFrameBadBcc(t *testing.T) {
+	d := []byte(dataMessageWithBcc())
+	d[len(d)-1] ^= 0xff
+	f := &Frame{Kind: FrameData, Data: d}
+	if err := f.Check(); err != ErrBadChecksum {
+		t.Errorf("expected %v, received %v", ErrBadChecksum, err)
+	}
+}
+
+func TestSplitterP1(t *testing.T) {
+	s := Splitter{P1: true}
+	frames := s.Write([]byte(p1TestTelegram + p1TestTelegram[:10]))
+	if len(frames) != 1 || frames[0].Kind != FrameP1 {
+		t.Fatalf("unexpected frames: %v", frames)
+	}
+	if err := frames[0].Check(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if f := s.Flush(); f == nil || len(f.Data) != 10 {
+		t.Errorf("expected the partial telegram, received %v", f)
+	}
+}
+
+func TestSplitterSML(t *testing.T) {
+	b, _ := hex.DecodeString(smlTestFrame)
+	var s Splitter
+	frames := s.Write(append([]byte{0x00}, b...))
+	if len(frames) != 2 || frames[0].Kind != FrameNoise || frames[1].Kind != FrameSML {
+		t.Fatalf("unexpected frames: %v", frames)
+	}
+	fc, err := frames[1].Decode()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(fc.DataSets) != 2 {
+		t.Errorf("expected %v, received %v", 2, len(fc.DataSets))
+	}
+}
@@ -174,7 +174,40 @@ var (
 	ErrIdentificationTooLong = errors.New("identification field too long")
 )
 
+// Parser parses mode C messages with configurable field length limits.
+// The zero value uses the limits from the standard.
+type Parser struct {
+	MaxAddressLength        int
+	MaxValueLength          int
+	MaxUnitLength           int
+	MaxIdentificationLength int
+	// LenientIdentification accepts any enhanced identification character
+	// after the \ and skips non printable characters in the identification.
+	LenientIdentification bool
+}
+
+// Field length limits from the standard.
+const (
+	DefaultMaxAddressLength        = 16
+	DefaultMaxValueLength          = 32
+	DefaultMaxUnitLength           = 16
+	DefaultMaxIdentificationLength = 16
+)
+
+func limit(l, def int) int {
+	if l <= 0 {
+		return def
+	}
+	return l
+}
+
+// ParseDataMessage parses a data message using the standard limits.
 func ParseDataMessage(r *bufio.Reader) (*DataMessage, error) {
+	return Parser{}.ParseDataMessage(r)
+}
+
+// ParseDataMessage reads bytes from r till a complete data message has been read.
+func (p Parser) ParseDataMessage(r *bufio.Reader) (*DataMessage, error) {
 	var b byte
 	var err error
 	var res *[]DataSet
@@ -197,7 +230,7 @@ func ParseDataMessage(r *bufio.Reader) (*DataMessage, error) {
 		log.Println("Found StxChar")
 	}
 	// Get the datasets.
-	res, err = ParseDataBlock(r, &bcc)
+	res, err = p.ParseDataBlock(r, &bcc)
 	if err != nil {
 		return nil, err
 	}
@@ -285,6 +318,11 @@ func ParseDataMessageEnd(r *bufio.Reader, bcc *Bcc) (*DataMessage, error) {
 
 // ParseDataBlock parses til no valid data lines can be parsed.
 func ParseDataBlock(r *bufio.Reader, bcc *Bcc) (*[]DataSet, error) {
+	return Parser{}.ParseDataBlock(r, bcc)
+}
+
+// ParseDataBlock parses til no valid data lines can be parsed.
+func (p Parser) ParseDataBlock(r *bufio.Reader, bcc *Bcc) (*[]DataSet, error) {
 	var err error
 	var res []DataSet
 
@@ -294,7 +332,7 @@ func ParseDataBlock(r *bufio.Reader, bcc *Bcc) (*[]DataSet, error) {
 
 	for {
 		var ds []DataSet
-		ds, err = ParseDataLine(r, bcc)
+		ds, err = p.ParseDataLine(r, bcc)
 		if err != nil {
 			if len(res) <= 0 {
 				return nil, ErrEmptyDataLine
@@ -313,6 +351,11 @@ func ParseDataBlock(r *bufio.Reader, bcc *Bcc) (*[]DataSet, error) {
 // ParseDataLine parses a DataSets till a CR LF has been detected.
 // Data lines consist of one or more datasets.
 func ParseDataLine(r *bufio.Reader, bcc *Bcc) ([]DataSet, error) {
+	return Parser{}.ParseDataLine(r, bcc)
+}
+
+// ParseDataLine parses a DataSets till a CR LF has been detected.
+func (p Parser) ParseDataLine(r *bufio.Reader, bcc *Bcc) ([]DataSet, error) {
 	var b byte
 	var err error
 	var ds *DataSet
@@ -323,7 +366,7 @@ func ParseDataLine(r *bufio.Reader, bcc *Bcc) ([]DataSet, error) {
 	}
 
 	for {
-		ds, err = ParseDataSet(r, bcc)
+		ds, err = p.ParseDataSet(r, bcc)
 		if err != nil {
 			r.UnreadByte()
 			return nil, ErrFormatError
@@ -351,10 +394,15 @@ func ParseDataLine(r *bufio.Reader, bcc *Bcc) ([]DataSet, error) {
 // Data set ::= Address '(' Value(optional) ('*' unit)(optional) ')'
 // Ignores CR and LF and reads up to the first !
 func ParseDataSet(r *bufio.Reader, bcc *Bcc) (*DataSet, error) {
+	return Parser{}.ParseDataSet(r, bcc)
+}
+
+// ParseDataSet reads bytes from r till a new complete dataset has been read or an error occured.
+func (p Parser) ParseDataSet(r *bufio.Reader, bcc *Bcc) (*DataSet, error) {
 	// read chars til Front boundary.
 	var b byte
 	var err error
-	var va [100]byte
+	var va [DefaultMaxValueLength + 1]byte
 	var v = va[:0]
 	res := &DataSet{}
 
@@ -385,7 +433,7 @@ ScanAddress:
 				return nil, ErrFormatError
 			}
 			v = append(v, b)
-			if len(v) > 16 {
+			if len(v) > limit(p.MaxAddressLength, DefaultMaxAddressLength) {
 				return nil, ErrAddressTooLong
 			}
 		}
@@ -413,7 +461,7 @@ ScanValue:
 				return nil, ErrFormatError
 			}
 			v = append(v, b)
-			if len(v) > 32 {
+			if len(v) > limit(p.MaxValueLength, DefaultMaxValueLength) {
 				return nil, ErrValueTooLong
 			}
 		}
@@ -443,7 +491,7 @@ ScanUnit:
 				return nil, ErrFormatError
 			}
 			v = append(v, b)
-			if len(v) > 16 {
+			if len(v) > limit(p.MaxUnitLength, DefaultMaxUnitLength) {
 				return nil, ErrUnitTooLong
 			}
 		}
@@ -452,7 +500,14 @@ ScanUnit:
 	return res, nil
 }
 
+// ParseIdentificationMessage parses an identification message using the standard limits.
 func ParseIdentificationMessage(r *bufio.Reader) (*IdentifcationMessage, error) {
+	return Parser{}.ParseIdentificationMessage(r)
+}
+
+// ParseIdentificationMessage reads bytes from r till a complete identification message has been read.
+// / XXX Z \W Identification CR LF
+func (p Parser) ParseIdentificationMessage(r *bufio.Reader) (*IdentifcationMessage, error) {
 	var b byte
 	var err error
 	var res = &IdentifcationMessage{}
@@ -488,7 +543,7 @@ func ParseIdentificationMessage(r *bufio.Reader) (*IdentifcationMessage, error)
 	}
 	res.BaudID = b
 
-	var vt [33]byte
+	var vt [DefaultMaxIdentificationLength + 1]byte
 	var v = vt[:0]
 
 	// \W or not
@@ -499,7 +554,7 @@ func ParseIdentificationMessage(r *bufio.Reader) (*IdentifcationMessage, error)
 	if b == SeqDelChar {
 		// Read a W
 		b, err = r.ReadByte()
-		if err != nil || b != byte('W') {
+		if err != nil || (b != byte('W') && !p.LenientIdentification) {
 			return nil, ErrFormatError
 		}
 	} else {
@@ -515,9 +570,11 @@ ScanIdenfication:
 		switch {
 		case b == CR:
 			break ScanIdenfication
+		case p.LenientIdentification && (b < 0x20 || b > 0x7e):
+			// Skip.
 		default:
 			v = append(v, b)
-			if len(v) > 16 {
+			if len(v) > limit(p.MaxIdentificationLength, DefaultMaxIdentificationLength) {
 				return nil, ErrIdentificationTooLong
 			}
 		}
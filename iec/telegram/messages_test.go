@@ -386,3 +386,18 @@ func TestRequestMessage(t *testing.T) {
 		t.Fatalf("bad request message: b.String()")
 	}
 }
+
+func TestParserLenientIdentification(t *testing.T) {
+	msg := "/MAN5\\2identification\r\n"
+	if _, err := ParseIdentificationMessage(bufio.NewReader(bytes.NewBufferString(msg))); err == nil {
+		t.Error("expected an error for the enhanced identification")
+	}
+	p := Parser{LenientIdentification: true}
+	im, err := p.ParseIdentificationMessage(bufio.NewReader(bytes.NewBufferString(msg)))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if im.ManID != "MAN" || im.Identification != "identification" {
+		t.Errorf("unexpected identification: %+v", im)
+	}
+}
@@ -0,0 +1,215 @@
+package telegram
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// P1Telegram type captures a DSMR P1 telegram.
+// A P1 telegram is pushed by the meter without a request:
+// / header CR LF CR LF data lines ! CRC CR LF
+type P1Telegram struct {
+	Identification *IdentifcationMessage
+	DataSets       []DataSet
+	// HasCRC is false for DSMR 2 and 3 telegrams that end with a bare !.
+	HasCRC bool
+	CRC    uint16
+}
+
+func (t *P1Telegram) String() string {
+	return fmt.Sprintf("%s, crc: %04X %+v", t.Identification, t.CRC, t.DataSets)
+}
+
+// maxP1LineLength limits the length of a header or data line.
+const maxP1LineLength = 1024
+
+var (
+	ErrBadChecksum = errors.New("checksum error")
+	ErrLineTooLong = errors.New("line too long")
+)
+
+// Crc16 computes the CRC-16/ARC used by DSMR P1 telegrams.
+type Crc16 uint16
+
+// Digest processes the next bytes for the checksum.
+func (crc *Crc16) Digest(b ...byte) {
+	for _, i := range b {
+		*crc ^= Crc16(i)
+		for n := 0; n < 8; n++ {
+			if *crc&1 != 0 {
+				*crc = (*crc >> 1) ^ 0xA001
+			} else {
+				*crc >>= 1
+			}
+		}
+	}
+}
+
+// readP1Line reads bytes up to and including LF and adds them to the crc.
+func readP1Line(r *bufio.Reader, crc *Crc16) ([]byte, error) {
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, ErrUnexpectedEOF
+		}
+		crc.Digest(b)
+		line = append(line, b)
+		if b == LF {
+			return line, nil
+		}
+		if len(line) > maxP1LineLength {
+			return nil, ErrLineTooLong
+		}
+	}
+}
+
+// trimCRLF removes the CR LF line end.
+func trimCRLF(line []byte) ([]byte, error) {
+	if len(line) < 2 || line[len(line)-2] != CR || line[len(line)-1] != LF {
+		return nil, ErrFormatError
+	}
+	return line[:len(line)-2], nil
+}
+
+// ParseP1Header parses the identification line of a P1 telegram, without
+// the StartChar. The fifth character is a baud rate character in mode C, but
+// P1 meters put any character there. An enhanced identification \X is skipped.
+func ParseP1Header(line []byte) (*IdentifcationMessage, error) {
+	if len(line) < 4 {
+		return nil, ErrFormatError
+	}
+	res := &IdentifcationMessage{
+		ManID:  string(line[:3]),
+		BaudID: line[3],
+	}
+	id := line[4:]
+	if len(id) >= 2 && id[0] == SeqDelChar {
+		id = id[2:]
+	}
+	res.Identification = string(id)
+	return res, nil
+}
+
+// ParseP1DataLine parses one data line without the line end.
+// The first value group is returned with the address, extra groups are
+// returned as data sets without an address.
+// Data line ::= Address ( '(' Value ('*' Unit)(optional) ')' )+
+func ParseP1DataLine(line []byte) ([]DataSet, error) {
+	var res []DataSet
+	i := 0
+	// Address.
+	for i < len(line) && line[i] != FrontBoundaryChar {
+		if !ValidAddressChar(line[i]) {
+			return nil, ErrFormatError
+		}
+		i++
+	}
+	address := string(line[:i])
+	if i == len(line) {
+		return nil, ErrFormatError
+	}
+	for i < len(line) {
+		if line[i] != FrontBoundaryChar {
+			return nil, ErrFormatError
+		}
+		i++
+		start := i
+		for i < len(line) && line[i] != RearBoundaryChar {
+			i++
+		}
+		if i == len(line) {
+			return nil, ErrFormatError
+		}
+		ds := DataSet{Address: address, Value: string(line[start:i])}
+		for j := start; j < i; j++ {
+			if line[j] == UnitSeparator {
+				ds.Value, ds.Unit = string(line[start:j]), string(line[j+1:i])
+				break
+			}
+		}
+		res = append(res, ds)
+		address = ""
+		i++
+	}
+	return res, nil
+}
+
+// ParseP1Telegram reads bytes from r till a complete P1 telegram has been read.
+// Bytes before the StartChar are skipped, so reading can start halfway a telegram.
+func ParseP1Telegram(r *bufio.Reader) (*P1Telegram, error) {
+	var crc Crc16
+	// Consume all bytes till a start of message is found.
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, ErrUnexpectedEOF
+		}
+		if b == StartChar {
+			crc.Digest(b)
+			break
+		}
+	}
+	line, err := readP1Line(r, &crc)
+	if err != nil {
+		return nil, err
+	}
+	if line, err = trimCRLF(line); err != nil {
+		return nil, err
+	}
+	res := &P1Telegram{}
+	if res.Identification, err = ParseP1Header(line); err != nil {
+		return nil, err
+	}
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, ErrUnexpectedEOF
+		}
+		if b == EndChar {
+			crc.Digest(b)
+			break
+		}
+		r.UnreadByte()
+		line, err := readP1Line(r, &crc)
+		if err != nil {
+			return nil, err
+		}
+		if line, err = trimCRLF(line); err != nil {
+			return nil, err
+		}
+		// Empty line after the header.
+		if len(line) == 0 {
+			continue
+		}
+		ds, err := ParseP1DataLine(line)
+		if err != nil {
+			return nil, err
+		}
+		res.DataSets = append(res.DataSets, ds...)
+	}
+	// CRC, not part of the checksum.
+	var tail Crc16
+	line, err = readP1Line(r, &tail)
+	if err != nil {
+		return nil, err
+	}
+	if line, err = trimCRLF(line); err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return res, nil
+	}
+	v, err := strconv.ParseUint(string(line), 16, 16)
+	if err != nil || len(line) != 4 {
+		return nil, ErrFormatError
+	}
+	res.HasCRC = true
+	res.CRC = uint16(v)
+	if res.CRC != uint16(crc) {
+		return res, ErrBadChecksum
+	}
+	return res, nil
+}
@@ -0,0 +1,106 @@
+package telegram
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+// p1TestTelegram is a DSMR 5 telegram captured from a ZIV meter.
+const p1TestTelegram = "/CTA5ZIV-METER\r\n" +
+	"\r\n" +
+	"1-3:0.2.8(50)\r\n" +
+	"0-0:1.0.0(210331173917S)\r\n" +
+	"0-0:96.1.1(4530303639303030373132353230353230)\r\n" +
+	"1-0:1.8.1(000051.394*kWh)\r\n" +
+	"1-0:1.8.2(000030.884*kWh)\r\n" +
+	"1-0:2.8.1(000027.851*kWh)\r\n" +
+	"1-0:2.8.2(000102.295*kWh)\r\n" +
+	"0-0:96.14.0(0002)\r\n" +
+	"1-0:1.7.0(00.224*kW)\r\n" +
+	"1-0:2.7.0(01.827*kW)\r\n" +
+	"0-0:96.7.21(00145)\r\n" +
+	"0-0:96.7.9(00047)\r\n" +
+	"1-0:99.97.0(3)(0-0:96.7.19)(201029040354W)(0000000321*s)(201029040354W)(0000000320*s)(201029040354W)(0000000320*s)\r\n" +
+	"1-0:32.32.0(00026)\r\n" +
+	"1-0:52.32.0(00028)\r\n" +
+	"1-0:72.32.0(00015)\r\n" +
+	"1-0:32.36.0(00024)\r\n" +
+	"1-0:52.36.0(00033)\r\n" +
+	"1-0:72.36.0(00029)\r\n" +
+	"0-0:96.13.0()\r\n" +
+	"1-0:32.7.0(231.0*V)\r\n" +
+	"1-0:52.7.0(227.0*V)\r\n" +
+	"1-0:72.7.0(229.0*V)\r\n" +
+	"1-0:31.7.0(008*A)\r\n" +
+	"1-0:51.7.0(000*A)\r\n" +
+	"1-0:71.7.0(000*A)\r\n" +
+	"1-0:21.7.0(00.000*kW)\r\n" +
+	"1-0:41.7.0(00.199*kW)\r\n" +
+	"1-0:61.7.0(00.025*kW)\r\n" +
+	"1-0:22.7.0(01.827*kW)\r\n" +
+	"1-0:42.7.0(00.000*kW)\r\n" +
+	"1-0:62.7.0(00.000*kW)\r\n" +
+	"0-1:24.1.0(003)\r\n" +
+	"0-1:96.1.0(4730303732303034303031383139323230)\r\n" +
+	"0-1:24.2.1(210331173500S)(00055.416*m3)\r\n" +
+	"!40B9\r\n"
+
+func TestParseP1Telegram(t *testing.T) {
+	// Start halfway a telegram.
+	r := bufio.NewReader(bytes.NewBufferString("(00055.416*m3)\r\n!1234\r\n" + p1TestTelegram))
+	tg, err := ParseP1Telegram(r)
+	if err != nil {
+		t.Fatalf("error parsing telegram: %s", err.Error())
+	}
+	if tg.Identification.ManID != "CTA" {
+		t.Errorf("expected %s, received %s", "CTA", tg.Identification.ManID)
+	}
+	if tg.Identification.Identification != "ZIV-METER" {
+		t.Errorf("expected %s, received %s", "ZIV-METER", tg.Identification.Identification)
+	}
+	if !tg.HasCRC || tg.CRC != 0x40B9 {
+		t.Errorf("expected crc %04X, received %04X", 0x40B9, tg.CRC)
+	}
+	if len(tg.DataSets) != 43 {
+		t.Errorf("expected %d data sets, received %d", 43, len(tg.DataSets))
+	}
+	last := tg.DataSets[len(tg.DataSets)-1]
+	if last.Address != "" || last.Value != "00055.416" || last.Unit != "m3" {
+		t.Errorf("unexpected last data set: %+v", last)
+	}
+}
+
+func TestParseP1TelegramBadChecksum(t *testing.T) {
+	bad := p1TestTelegram[:len(p1TestTelegram)-6] + "40B8\r\n"
+	_, err := ParseP1Telegram(bufio.NewReader(bytes.NewBufferString(bad)))
+	if err != ErrBadChecksum {
+		t.Errorf("expected %v, received %v", ErrBadChecksum, err)
+	}
+}
+
+func TestParseP1TelegramNoCRC(t *testing.T) {
+	tg, err := ParseP1Telegram(bufio.NewReader(bytes.NewBufferString("/ISk5\\2MT382-1000\r\n\r\n1-0:1.8.1(00123.456*kWh)\r\n!\r\n")))
+	if err != nil {
+		t.Fatalf("error parsing telegram: %s", err.Error())
+	}
+	if tg.HasCRC {
+		t.Error("expected no crc")
+	}
+	if tg.Identification.Identification != "MT382-1000" {
+		t.Errorf("expected %s, received %s", "MT382-1000", tg.Identification.Identification)
+	}
+}
+
+func TestParseP1DataLine(t *testing.T) {
+	ds, err := ParseP1DataLine([]byte("0-0:96.13.0()"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(ds) != 1 || ds[0].Address != "0-0:96.13.0" || ds[0].Value != "" {
+		t.Errorf("unexpected result: %+v", ds)
+	}
+	if _, err := ParseP1DataLine([]byte("1-0:1.8.1(00123")); err != ErrFormatError {
+		t.Errorf("expected %v, received %v", ErrFormatError, err)
+	}
+}
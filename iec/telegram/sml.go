@@ -0,0 +1,318 @@
+package telegram
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// SML (Smart Message Language) is a binary protocol pushed by German
+// meters at 9600 8N1. A transport frame is delimited by escape sequences:
+// 1b1b1b1b 01010101 messages 1b1b1b1b 1a padding crc16
+
+var (
+	smlEscape = []byte{0x1b, 0x1b, 0x1b, 0x1b}
+	smlStart  = []byte{0x01, 0x01, 0x01, 0x01}
+)
+
+const smlEnd = byte(0x1a)
+
+// SML tags for the messages that are decoded.
+const (
+	smlGetListResponse = 0x0701
+)
+
+var (
+	ErrSMLFormat = errors.New("sml format error")
+)
+
+// SMLFrame type captures an SML transport frame.
+type SMLFrame struct {
+	// Payload contains the messages, without escape sequences and padding.
+	Payload []byte
+	CRC     uint16
+}
+
+// SMLCrc16 computes the CRC-16/X-25 used by SML transport frames.
+type SMLCrc16 uint16
+
+// NewSMLCrc16 returns an initialised checksum.
+func NewSMLCrc16() SMLCrc16 {
+	return SMLCrc16(0xffff)
+}
+
+// Digest processes the next bytes for the checksum.
+func (crc *SMLCrc16) Digest(b ...byte) {
+	for _, i := range b {
+		*crc ^= SMLCrc16(i)
+		for n := 0; n < 8; n++ {
+			if *crc&1 != 0 {
+				*crc = (*crc >> 1) ^ 0x8408
+			} else {
+				*crc >>= 1
+			}
+		}
+	}
+}
+
+// Sum returns the final checksum value.
+func (crc SMLCrc16) Sum() uint16 {
+	return uint16(crc ^ 0xffff)
+}
+
+func readN(r *bufio.Reader, n int) ([]byte, error) {
+	b := make([]byte, n)
+	for i := range b {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, ErrUnexpectedEOF
+		}
+		b[i] = c
+	}
+	return b, nil
+}
+
+// ReadSMLFrame reads bytes from r till a complete SML transport frame has been read.
+// Bytes before the start sequence are skipped.
+func ReadSMLFrame(r *bufio.Reader) (*SMLFrame, error) {
+	// Find the start sequence.
+	var window []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, ErrUnexpectedEOF
+		}
+		window = append(window, b)
+		if len(window) > 8 {
+			window = window[1:]
+		}
+		if len(window) == 8 && bytes.Equal(window[:4], smlEscape) && bytes.Equal(window[4:], smlStart) {
+			break
+		}
+	}
+	crc := NewSMLCrc16()
+	crc.Digest(window...)
+	var payload []byte
+	for {
+		block, err := readN(r, 4)
+		if err != nil {
+			return nil, err
+		}
+		crc.Digest(block...)
+		if !bytes.Equal(block, smlEscape) {
+			payload = append(payload, block...)
+			continue
+		}
+		// Escape sequence.
+		block, err = readN(r, 4)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case bytes.Equal(block, smlEscape):
+			// Escaped escape sequence.
+			crc.Digest(block...)
+			payload = append(payload, smlEscape...)
+		case block[0] == smlEnd:
+			// The checksum covers the padding count but not itself.
+			crc.Digest(block[:2]...)
+			padding := int(block[1])
+			if padding > 3 || padding > len(payload) {
+				return nil, ErrSMLFormat
+			}
+			res := &SMLFrame{
+				Payload: payload[:len(payload)-padding],
+				CRC:     uint16(block[2])<<8 | uint16(block[3]),
+			}
+			// The checksum is sent low byte first.
+			sum := crc.Sum()
+			if res.CRC != sum>>8|sum<<8 {
+				return res, ErrBadChecksum
+			}
+			return res, nil
+		default:
+			return nil, ErrSMLFormat
+		}
+	}
+}
+
+// smlValue is a decoded SML type-length-value element.
+type smlValue struct {
+	kind  byte
+	bytes []byte
+	list  []*smlValue
+}
+
+const (
+	smlOctets = 0x00
+	smlBool   = 0x40
+	smlInt    = 0x50
+	smlUint   = 0x60
+	smlList   = 0x70
+)
+
+// parseSMLValue decodes one element from p and returns the remaining bytes.
+func parseSMLValue(p []byte) (*smlValue, []byte, error) {
+	if len(p) == 0 {
+		return nil, nil, ErrSMLFormat
+	}
+	// End of message.
+	if p[0] == 0x00 {
+		return &smlValue{kind: smlOctets}, p[1:], nil
+	}
+	kind := p[0] & 0x70
+	length := int(p[0] & 0x0f)
+	tl := 1
+	for p[tl-1]&0x80 != 0 {
+		if tl >= len(p) {
+			return nil, nil, ErrSMLFormat
+		}
+		length = length<<4 | int(p[tl]&0x0f)
+		tl++
+	}
+	if kind == smlList {
+		res := &smlValue{kind: kind}
+		rest := p[tl:]
+		for i := 0; i < length; i++ {
+			v, r, err := parseSMLValue(rest)
+			if err != nil {
+				return nil, nil, err
+			}
+			res.list = append(res.list, v)
+			rest = r
+		}
+		return res, rest, nil
+	}
+	// The length includes the type-length bytes.
+	if length < tl || length > len(p) {
+		return nil, nil, ErrSMLFormat
+	}
+	return &smlValue{kind: kind, bytes: p[tl:length]}, p[length:], nil
+}
+
+func (v *smlValue) integer() *big.Int {
+	i := new(big.Int).SetBytes(v.bytes)
+	if v.kind == smlInt && len(v.bytes) > 0 && v.bytes[0]&0x80 != 0 {
+		i.Sub(i, new(big.Int).Lsh(big.NewInt(1), uint(8*len(v.bytes))))
+	}
+	return i
+}
+
+// smlUnits maps DLMS unit codes to unit names.
+var smlUnits = map[int64]string{
+	8:  "deg",
+	9:  "degC",
+	13: "m3",
+	27: "W",
+	28: "VA",
+	29: "var",
+	30: "Wh",
+	31: "VAh",
+	32: "varh",
+	33: "A",
+	35: "V",
+	44: "Hz",
+}
+
+// smlObisAddress formats a six byte OBIS code as A-B:C.D.E, the F group is
+// only added when it is not 255.
+func smlObisAddress(b []byte) string {
+	if len(b) != 6 {
+		return hex.EncodeToString(b)
+	}
+	s := fmt.Sprintf("%d-%d:%d.%d.%d", b[0], b[1], b[2], b[3], b[4])
+	if b[5] != 0xff {
+		s += fmt.Sprintf("*%d", b[5])
+	}
+	return s
+}
+
+// smlFormat returns value * 10^scaler as a decimal string.
+func smlFormat(value *big.Int, scaler int) string {
+	s := value.String()
+	if scaler >= 0 {
+		for i := 0; i < scaler; i++ {
+			s += "0"
+		}
+		return s
+	}
+	neg := value.Sign() < 0
+	if neg {
+		s = s[1:]
+	}
+	for len(s) <= -scaler {
+		s = "0" + s
+	}
+	s = s[:len(s)+scaler] + "." + s[len(s)+scaler:]
+	if neg {
+		s = "-" + s
+	}
+	return s
+}
+
+// SMLListResponse type contains the values of an SML GetList response.
+type SMLListResponse struct {
+	ServerID string
+	DataSets []DataSet
+}
+
+// ParseSMLFrame decodes the GetList responses in the payload of an SML frame.
+// Other messages are skipped.
+func ParseSMLFrame(f *SMLFrame) (*SMLListResponse, error) {
+	res := &SMLListResponse{}
+	rest := f.Payload
+	for len(rest) > 0 {
+		msg, r, err := parseSMLValue(rest)
+		if err != nil {
+			return nil, err
+		}
+		rest = r
+		// Padding.
+		if msg.kind != smlList {
+			continue
+		}
+		// transactionId, groupNo, abortOnError, messageBody, crc16, endOfSmlMsg
+		if len(msg.list) != 6 || len(msg.list[3].list) != 2 {
+			return nil, ErrSMLFormat
+		}
+		body := msg.list[3]
+		if body.list[0].integer().Int64() != smlGetListResponse {
+			continue
+		}
+		// clientId, serverId, listName, actSensorTime, valList, listSignature, actGatewayTime
+		gl := body.list[1]
+		if len(gl.list) != 7 {
+			return nil, ErrSMLFormat
+		}
+		res.ServerID = hex.EncodeToString(gl.list[1].bytes)
+		for _, e := range gl.list[4].list {
+			// objName, status, valTime, unit, scaler, value, valueSignature
+			if len(e.list) != 7 {
+				return nil, ErrSMLFormat
+			}
+			ds := DataSet{Address: smlObisAddress(e.list[0].bytes)}
+			if u := e.list[3]; u.kind == smlUint && len(u.bytes) > 0 {
+				if name, ok := smlUnits[u.integer().Int64()]; ok {
+					ds.Unit = name
+				}
+			}
+			scaler := 0
+			if s := e.list[4]; s.kind == smlInt && len(s.bytes) > 0 {
+				scaler = int(s.integer().Int64())
+			}
+			switch v := e.list[5]; v.kind {
+			case smlInt, smlUint:
+				ds.Value = smlFormat(v.integer(), scaler)
+			case smlBool:
+				ds.Value = fmt.Sprintf("%t", len(v.bytes) > 0 && v.bytes[0] != 0)
+			default:
+				ds.Value = hex.EncodeToString(v.bytes)
+			}
+			res.DataSets = append(res.DataSets, ds)
+		}
+	}
+	return res, nil
+}
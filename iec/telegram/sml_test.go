@@ -0,0 +1,82 @@
+package telegram
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// smlTestFrame contains one GetList response with 1-0:1.8.0 and 1-0:16.7.0.
+const smlTestFrame = "1b1b1b1b01010101760201620062007263070177010b0a01454d48000012345601017277070100010800ff0101621e52ff650001e2400177070100100700ff0101621b520053ff0601010163000000001b1b1b1b1a01b3bc"
+
+func smlTestReader(t *testing.T, s string) *bufio.Reader {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("bad test data: %s", err.Error())
+	}
+	// Prepend garbage to test the start sequence detection.
+	return bufio.NewReader(bytes.NewReader(append([]byte{0x00, 0x1b, 0x1b}, b...)))
+}
+
+func TestSMLCrc16(t *testing.T) {
+	crc := NewSMLCrc16()
+	crc.Digest([]byte("123456789")...)
+	if crc.Sum() != 0x906E {
+		t.Errorf("expected %04X, received %04X", 0x906E, crc.Sum())
+	}
+}
+
+func TestReadSMLFrame(t *testing.T) {
+	f, err := ReadSMLFrame(smlTestReader(t, smlTestFrame))
+	if err != nil {
+		t.Fatalf("error reading frame: %s", err.Error())
+	}
+	l, err := ParseSMLFrame(f)
+	if err != nil {
+		t.Fatalf("error parsing frame: %s", err.Error())
+	}
+	if l.ServerID != "0a01454d480000123456" {
+		t.Errorf("expected server id %s, received %s", "0a01454d480000123456", l.ServerID)
+	}
+	expected := []DataSet{
+		{Address: "1-0:1.8.0", Value: "12345.6", Unit: "Wh"},
+		{Address: "1-0:16.7.0", Value: "-250", Unit: "W"},
+	}
+	if len(l.DataSets) != len(expected) {
+		t.Fatalf("expected %d data sets, received %d", len(expected), len(l.DataSets))
+	}
+	for i, ds := range expected {
+		if l.DataSets[i] != ds {
+			t.Errorf("expected %+v, received %+v", ds, l.DataSets[i])
+		}
+	}
+}
+
+func TestReadSMLFrameBadChecksum(t *testing.T) {
+	bad := smlTestFrame[:len(smlTestFrame)-4] + "0000"
+	_, err := ReadSMLFrame(smlTestReader(t, bad))
+	if err != ErrBadChecksum {
+		t.Errorf("expected %v, received %v", ErrBadChecksum, err)
+	}
+}
+
+func TestSMLFormat(t *testing.T) {
+	tests := []struct {
+		value  int64
+		scaler int
+		result string
+	}{
+		{123, 0, "123"},
+		{123, 2, "12300"},
+		{123, -1, "12.3"},
+		{5, -3, "0.005"},
+		{-5, -1, "-0.5"},
+	}
+	for _, tt := range tests {
+		v := &smlValue{kind: smlInt, bytes: []byte{byte(tt.value >> 8), byte(tt.value)}}
+		if s := smlFormat(v.integer(), tt.scaler); s != tt.result {
+			t.Errorf("expected %s, received %s", tt.result, s)
+		}
+	}
+}
@@ -4,10 +4,18 @@
 package iecstream
 
 import (
+	"errors"
 	"sync"
+	"time"
 
 	"github.com/peterzandbergen/iec62056/iec"
 	"github.com/peterzandbergen/iec62056/model"
+	"go.bug.st/serial.v1"
+)
+
+var (
+	// ErrRunning is returned when the stream is started twice.
+	ErrRunning = errors.New("stream is running")
 )
 
 // IecStream type converts the messages from the serial port to Measurements.
@@ -15,18 +23,111 @@ type Stream struct {
 	m       sync.Mutex
 	running bool
 	c       chan *model.Measurement
-	p       iec.Port
+	p       *iec.Port
+	err     error
+	done    chan struct{}
+}
+
+// New creates a stream that reads the messages from the port with the settings.
+func New(settings *iec.PortSettings) *Stream {
+	return &Stream{
+		p: iec.New(settings),
+	}
 }
 
-func (i *Stream) OpenPort() error {
+// Port returns the underlying port, e.g. to set a capture before opening it.
+func (i *Stream) Port() *iec.Port {
+	return i.p
+}
+
+// OpenPort opens the serial device.
+func (i *Stream) OpenPort(name string) error {
 	i.m.Lock()
 	defer i.m.Unlock()
-	return nil
+	return i.p.Open(name)
+}
+
+// Attach uses an open transport, e.g. a capture.Replay, instead of a device.
+func (i *Stream) Attach(port serial.Port) error {
+	i.m.Lock()
+	defer i.m.Unlock()
+	return i.p.Attach(port)
+}
+
+// C returns the channel with the measurements. It is closed when the
+// stream stops, Err returns the reason.
+func (i *Stream) C() <-chan *model.Measurement {
+	i.m.Lock()
+	defer i.m.Unlock()
+	return i.c
 }
 
+// Err returns the error that stopped the stream.
+func (i *Stream) Err() error {
+	i.m.Lock()
+	defer i.m.Unlock()
+	return i.err
+}
+
+// Start reads messages from the port until a read fails.
 func (i *Stream) Start() error {
 	i.m.Lock()
 	defer i.m.Unlock()
+	if i.running {
+		return ErrRunning
+	}
+	i.running = true
+	i.err = nil
+	i.c = make(chan *model.Measurement)
+	i.done = make(chan struct{})
+	go i.run(i.c, i.done)
+	return nil
+}
 
+// Stop waits for the stream to end and closes the port.
+func (i *Stream) Stop() error {
+	i.m.Lock()
+	c, done := i.c, i.done
+	i.m.Unlock()
+	if done != nil {
+		i.p.Abort()
+		// Drain the channel so the reader can finish.
+		for range c {
+		}
+		<-done
+	}
+	i.p.Close()
 	return nil
 }
+
+func (i *Stream) run(c chan *model.Measurement, done chan struct{}) {
+	defer close(done)
+	defer close(c)
+	for {
+		dm, err := i.p.Read()
+		if err != nil {
+			i.m.Lock()
+			i.err = err
+			i.running = false
+			i.m.Unlock()
+			return
+		}
+		c <- toMeasurement(dm, time.Now())
+	}
+}
+
+func toMeasurement(dm *iec.DataMessage, t time.Time) *model.Measurement {
+	m := &model.Measurement{
+		Time:           t,
+		ManufacturerID: dm.ManufacturerID,
+		Identification: dm.MeterID,
+	}
+	for _, ds := range dm.DataSets {
+		m.Readings = append(m.Readings, model.DataSet{
+			Address: ds.Address,
+			Value:   ds.Value,
+			Unit:    ds.Unit,
+		})
+	}
+	return m
+}
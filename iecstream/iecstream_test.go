@@ -1 +1,49 @@
 package iecstream
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/iec"
+	"github.com/peterzandbergen/iec62056/iec/capture"
+	"github.com/peterzandbergen/iec62056/iec/telegram"
+)
+
+const p1Telegram = "/CTA5ZIV-METER\r\n\r\n1-0:1.8.1(000051.394*kWh)\r\n!"
+
+func p1TelegramWithCRC() []byte {
+	var crc telegram.Crc16
+	crc.Digest([]byte(p1Telegram)...)
+	return []byte(p1Telegram + fmt.Sprintf("%04X\r\n", uint16(crc)))
+}
+
+func TestStreamReplay(t *testing.T) {
+	t0 := time.Now()
+	records := []*capture.Record{
+		{Time: t0, Direction: capture.RX, Data: p1TelegramWithCRC()},
+		{Time: t0.Add(time.Second), Direction: capture.RX, Data: p1TelegramWithCRC()},
+	}
+	s := New(&iec.PortSettings{Protocol: iec.ProtocolP1, InitialBaudRateModeABC: 115200, Framing: "8N1"})
+	if err := s.Attach(capture.NewReplay(records, 0)); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	n := 0
+	for m := range s.C() {
+		if m.Identification != "ZIV-METER" || len(m.Readings) != 1 {
+			t.Errorf("unexpected measurement: %+v", m)
+		}
+		n++
+	}
+	if n != 2 {
+		t.Errorf("expected %v, received %v", 2, n)
+	}
+	// The stream ends at the end of the capture.
+	if s.Err() == nil {
+		t.Error("expected an error at the end of the capture")
+	}
+	s.Stop()
+}
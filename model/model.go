@@ -1,8 +1,18 @@
 package model
 
-import "time"
+import (
+	"errors"
+	"time"
+)
 
 var (
+	// ErrUnavailable is returned by a repository that is temporarily unavailable,
+	// e.g. a meter whose serial port has been unplugged.
+	ErrUnavailable = errors.New("repository temporarily unavailable")
+	// ErrNoElements is returned for a page, first or last without
+	// measurements. The repositories return this error, or their own error
+	// that is the same value.
+	ErrNoElements = errors.New("no elements")
 	// First can be used in Get to get the first element from a repository.
 	First = "__first__"
 	// Last can be used in Get to get the first element from a repository.
@@ -15,6 +25,11 @@ type MeasurementRepo interface {
 	Get(key []byte) (*Measurement, error)
 	GetPage(page, pagesize int) ([]*Measurement, error)
 	GetAll() ([]*Measurement, error)
+	// GetRange returns up to limit measurements of the meter with a time
+	// from from up to but not including to, in time order. An empty meterID
+	// selects all meters, a zero from or to leaves the range open at that
+	// end and a limit of 0 or less returns all measurements in the range.
+	GetRange(meterID string, from, to time.Time, limit int) ([]*Measurement, error)
 	Delete(*Measurement) error
 }
 
@@ -26,6 +41,12 @@ type Measurement struct {
 	Readings       []DataSet
 }
 
+// MeterID returns the ID of the meter of the measurement, the manufacturer
+// and the identification separated by a slash.
+func (m *Measurement) MeterID() string {
+	return m.ManufacturerID + "/" + m.Identification
+}
+
 // DataSet is a measurement of a variable. Follows the OBIS scheme for the address.
 type DataSet struct {
 	Address string
@@ -49,3 +70,76 @@ func (a Address) String() string {
 	}
 	return string(a)
 }
+
+// ErrStopWalk can be returned by a WalkFunc to end the walk early, Walk then
+// returns nil.
+var ErrStopWalk = errors.New("stop walk")
+
+// WalkFunc is called for every measurement of a walk. An error other than
+// ErrStopWalk ends the walk and is returned by Walk.
+type WalkFunc func(*Measurement) error
+
+// Walker is implemented by repositories that can pass the measurements one
+// at a time, without holding them all in memory.
+type Walker interface {
+	// Walk calls fn for the measurements of the meter in the time range, in
+	// the order and with the parameters of GetRange.
+	Walk(meterID string, from, to time.Time, fn WalkFunc) error
+}
+
+// Walk calls fn for the measurements of the meter in the time range. A repo
+// that is not a Walker is read with GetRange.
+func Walk(repo MeasurementRepo, meterID string, from, to time.Time, fn WalkFunc) error {
+	if w, ok := repo.(Walker); ok {
+		return w.Walk(meterID, from, to, fn)
+	}
+	mm, err := repo.GetRange(meterID, from, to, 0)
+	if err != nil {
+		return err
+	}
+	for _, m := range mm {
+		if err := fn(m); err != nil {
+			if err == ErrStopWalk {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// ErrBadCursor is returned for a cursor that was not returned by the repository.
+var ErrBadCursor = errors.New("bad cursor")
+
+// CursorPager is implemented by repositories that continue a listing after
+// the last returned measurement, without skipping the earlier ones.
+type CursorPager interface {
+	// GetAfter returns up to size measurements after the cursor, from the
+	// start for an empty cursor, in the order of GetPage. The returned cursor
+	// continues after the last of them, it is empty at the end.
+	GetAfter(cursor string, size int) ([]*Measurement, string, error)
+}
+
+// Sample is the value of a register of a meter at a time, a point of a
+// series.
+type Sample struct {
+	Time    time.Time
+	MeterID string
+	Address string
+	Value   string
+	Unit    string
+}
+
+// SampleFunc is called for every sample of a series walk. ErrStopWalk ends
+// the walk early, as for a WalkFunc.
+type SampleFunc func(*Sample) error
+
+// SeriesWalker is implemented by repositories that index the registers, so
+// a single register can be read without decoding the measurements.
+type SeriesWalker interface {
+	// WalkSeries calls fn for the samples of the registers that match the
+	// address in the time range, per register and meter in time order.
+	// Without a meter ID all meters are walked, from is inclusive and to is
+	// exclusive, zero times are unbounded.
+	WalkSeries(address, meterID string, from, to time.Time, fn SampleFunc) error
+}
@@ -0,0 +1,330 @@
+// Package repotest has the conformance tests for the implementations of
+// model.MeasurementRepo. A backend runs them from its tests:
+//
+//	func TestConformance(t *testing.T) {
+//		repotest.Run(t, func(t *testing.T) model.MeasurementRepo {
+//			return newRepo(t)
+//		})
+//	}
+package repotest
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+// Open returns a new and empty repo for a test. It fails the test when the
+// repo cannot be opened and closes the repo in a cleanup.
+type Open func(t *testing.T) model.MeasurementRepo
+
+// T0 is the time of the first test measurement.
+var T0 = time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)
+
+// Run runs the conformance tests, each on a new repo from open.
+func Run(t *testing.T, open Open) {
+	tests := []struct {
+		name string
+		fn   func(*testing.T, model.MeasurementRepo)
+	}{
+		{"Empty", testEmpty},
+		{"Order", testOrder},
+		{"FirstLast", testFirstLast},
+		{"Range", testRange},
+		{"Pages", testPages},
+		{"Replace", testReplace},
+		{"Delete", testDelete},
+		{"Concurrent", testConcurrent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, open(t))
+		})
+	}
+}
+
+// Measurement returns a test measurement of the meter, i minutes after T0.
+func Measurement(meter string, i int) *model.Measurement {
+	return &model.Measurement{
+		Time:           T0.Add(time.Duration(i) * time.Minute),
+		ManufacturerID: "ISK",
+		Identification: meter,
+		Readings: []model.DataSet{
+			{Address: "1.8.1", Value: fmt.Sprintf("%010.3f", float64(i)), Unit: "kWh"},
+			{Address: "0.9.1", Value: "1504", Unit: ""},
+		},
+	}
+}
+
+func put(t *testing.T, repo model.MeasurementRepo, ms ...*model.Measurement) {
+	t.Helper()
+	for _, m := range ms {
+		if err := repo.Put(m); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+}
+
+// interleaved stores n measurements of meter a and b, b one second after a,
+// and returns them in time order.
+func interleaved(t *testing.T, repo model.MeasurementRepo, n int) []*model.Measurement {
+	t.Helper()
+	var ms []*model.Measurement
+	for i := 0; i < n; i++ {
+		a, b := Measurement("a", i), Measurement("b", i)
+		b.Time = b.Time.Add(time.Second)
+		ms = append(ms, a, b)
+	}
+	// Store out of order.
+	for i := len(ms) - 1; i >= 0; i -= 2 {
+		put(t, repo, ms[i])
+	}
+	for i := 0; i < len(ms); i += 2 {
+		put(t, repo, ms[i])
+	}
+	return ms
+}
+
+// equal compares the measurements, the times with Equal.
+func equal(a, b *model.Measurement) bool {
+	return a != nil && b != nil && a.Time.Equal(b.Time) &&
+		a.ManufacturerID == b.ManufacturerID &&
+		a.Identification == b.Identification &&
+		reflect.DeepEqual(a.Readings, b.Readings)
+}
+
+func check(t *testing.T, expected, received []*model.Measurement) {
+	t.Helper()
+	if len(received) != len(expected) {
+		t.Fatalf("expected %v measurements, received %v", len(expected), len(received))
+	}
+	for i := range expected {
+		if !equal(expected[i], received[i]) {
+			t.Errorf("measurement %d: expected %v, received %v", i, expected[i], received[i])
+		}
+	}
+}
+
+func testEmpty(t *testing.T, repo model.MeasurementRepo) {
+	ms, err := repo.GetAll()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(ms) != 0 {
+		t.Errorf("expected %v, received %v", 0, len(ms))
+	}
+	if _, err := repo.GetPage(0, 10); !errors.Is(err, model.ErrNoElements) {
+		t.Errorf("expected %v, received %v", model.ErrNoElements, err)
+	}
+	for _, k := range []string{model.First, model.Last} {
+		if _, err := repo.Get([]byte(k)); !errors.Is(err, model.ErrNoElements) {
+			t.Errorf("%s: expected %v, received %v", k, model.ErrNoElements, err)
+		}
+	}
+	ms, err = repo.GetRange("ISK/a", T0, T0.Add(time.Hour), 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(ms) != 0 {
+		t.Errorf("expected %v, received %v", 0, len(ms))
+	}
+}
+
+func testOrder(t *testing.T, repo model.MeasurementRepo) {
+	expected := interleaved(t, repo, 5)
+	ms, err := repo.GetAll()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	check(t, expected, ms)
+}
+
+func testFirstLast(t *testing.T, repo model.MeasurementRepo) {
+	expected := interleaved(t, repo, 3)
+	first, err := repo.Get([]byte(model.First))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if !equal(expected[0], first) {
+		t.Errorf("expected %v, received %v", expected[0], first)
+	}
+	last, err := repo.Get([]byte(model.Last))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if !equal(expected[len(expected)-1], last) {
+		t.Errorf("expected %v, received %v", expected[len(expected)-1], last)
+	}
+}
+
+func testRange(t *testing.T, repo model.MeasurementRepo) {
+	all := interleaved(t, repo, 6)
+	var a []*model.Measurement
+	for _, m := range all {
+		if m.Identification == "a" {
+			a = append(a, m)
+		}
+	}
+	// From is inclusive, to is exclusive.
+	ms, err := repo.GetRange("ISK/a", a[1].Time, a[4].Time, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	check(t, a[1:4], ms)
+
+	ms, err = repo.GetRange("ISK/a", a[1].Time, time.Time{}, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	check(t, a[1:3], ms)
+
+	// All meters.
+	ms, err = repo.GetRange("", a[1].Time, a[2].Time, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	check(t, all[2:4], ms)
+
+	ms, err = repo.GetRange("ISK/unknown", time.Time{}, time.Time{}, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(ms) != 0 {
+		t.Errorf("expected %v, received %v", 0, len(ms))
+	}
+
+	if _, err := repo.GetRange("ISK/a", a[4].Time, a[1].Time, 0); err == nil {
+		t.Errorf("expected an error for to before from")
+	}
+}
+
+func testPages(t *testing.T, repo model.MeasurementRepo) {
+	var expected []*model.Measurement
+	for i := 0; i < 7; i++ {
+		expected = append(expected, Measurement("a", i))
+	}
+	put(t, repo, expected...)
+
+	var ms []*model.Measurement
+	for page := 0; ; page++ {
+		p, err := repo.GetPage(page, 3)
+		if errors.Is(err, model.ErrNoElements) {
+			if page != 3 {
+				t.Errorf("expected %v, received %v", 3, page)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if len(p) > 3 {
+			t.Fatalf("expected at most %v, received %v", 3, len(p))
+		}
+		ms = append(ms, p...)
+	}
+	check(t, expected, ms)
+
+	// A page that is one larger than the repo.
+	p, err := repo.GetPage(0, 8)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	check(t, expected, p)
+	if _, err := repo.GetPage(1, 7); !errors.Is(err, model.ErrNoElements) {
+		t.Errorf("expected %v, received %v", model.ErrNoElements, err)
+	}
+	if _, err := repo.GetPage(100, 7); !errors.Is(err, model.ErrNoElements) {
+		t.Errorf("expected %v, received %v", model.ErrNoElements, err)
+	}
+	if _, err := repo.GetPage(-1, 3); err == nil {
+		t.Errorf("expected an error for a negative page")
+	}
+}
+
+func testReplace(t *testing.T, repo model.MeasurementRepo) {
+	m := Measurement("a", 0)
+	put(t, repo, m)
+	n := Measurement("a", 0)
+	n.Readings[0].Value = "000099.000"
+	put(t, repo, n)
+	ms, err := repo.GetAll()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	check(t, []*model.Measurement{n}, ms)
+}
+
+func testDelete(t *testing.T, repo model.MeasurementRepo) {
+	ms := interleaved(t, repo, 2)
+	if err := repo.Delete(ms[1]); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	// Deleting a measurement that is not stored is not an error.
+	if err := repo.Delete(Measurement("c", 0)); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	all, err := repo.GetAll()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	check(t, []*model.Measurement{ms[0], ms[2], ms[3]}, all)
+	for _, m := range ms {
+		if err := repo.Delete(m); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	if _, err := repo.Get([]byte(model.Last)); !errors.Is(err, model.ErrNoElements) {
+		t.Errorf("expected %v, received %v", model.ErrNoElements, err)
+	}
+}
+
+func testConcurrent(t *testing.T, repo model.MeasurementRepo) {
+	const writers, n = 4, 10
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*writers*n)
+	for w := 0; w < writers; w++ {
+		wg.Add(2)
+		meter := fmt.Sprintf("m%d", w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				if err := repo.Put(Measurement(meter, i)); err != nil {
+					errs <- err
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				if _, err := repo.GetRange("ISK/"+meter, time.Time{}, time.Time{}, 0); err != nil {
+					errs <- err
+				}
+				if _, err := repo.Get([]byte(model.Last)); err != nil && !errors.Is(err, model.ErrNoElements) {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	ms, err := repo.GetAll()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(ms) != writers*n {
+		t.Errorf("expected %v, received %v", writers*n, len(ms))
+	}
+	for i := 1; i < len(ms); i++ {
+		if ms[i].Time.Before(ms[i-1].Time) {
+			t.Errorf("measurement %d: expected after %v, received %v", i, ms[i-1].Time, ms[i].Time)
+		}
+	}
+}
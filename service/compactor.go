@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Compactable rolls up and expires stored records, e.g. a cache.Cache.
+type Compactable interface {
+	// Compact writes the rollups of the periods completed at now and
+	// deletes the expired records.
+	Compact(now time.Time) (rollups, deleted int, err error)
+}
+
+// DefaultCompactInterval is the interval between two compactions.
+const DefaultCompactInterval = 15 * time.Minute
+
+// Compactor compacts the repo at the start and then every interval.
+type Compactor struct {
+	Repo Compactable
+	// Interval between the compactions.
+	Interval time.Duration
+
+	loop loop
+}
+
+// Start starts the compactor, until Stop is called or ctx ends.
+func (c *Compactor) Start(ctx context.Context) error {
+	return c.loop.start(ctx, c.run)
+}
+
+// Stop stops the compactor after the running compaction, or when ctx
+// expires.
+func (c *Compactor) Stop(ctx context.Context) error {
+	return c.loop.stop(ctx)
+}
+
+func (c *Compactor) run(ctx context.Context) {
+	interval := c.Interval
+	if interval <= 0 {
+		interval = DefaultCompactInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		rollups, deleted, err := c.Repo.Compact(time.Now())
+		if err != nil {
+			log.Printf("compactor: %s", err.Error())
+		} else if rollups > 0 || deleted > 0 {
+			log.Printf("compactor: wrote %d rollups, deleted %d records", rollups, deleted)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countRepo counts the compactions.
+type countRepo struct {
+	lock  sync.Mutex
+	calls int
+}
+
+func (r *countRepo) Compact(now time.Time) (int, int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.calls++
+	return 1, 0, nil
+}
+
+func (r *countRepo) count() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.calls
+}
+
+func TestCompactor(t *testing.T) {
+	repo := &countRepo{}
+	c := &Compactor{Repo: repo, Interval: time.Millisecond}
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := c.Start(context.Background()); err != ErrAlreadyRunning {
+		t.Errorf("expected %v, received %v", ErrAlreadyRunning, err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for repo.count() < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := c.Stop(context.Background()); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if n := repo.count(); n < 3 {
+		t.Errorf("expected %v, received %v", "at least 3", n)
+	}
+	if err := c.Stop(context.Background()); err != ErrNotRunning {
+		t.Errorf("expected %v, received %v", ErrNotRunning, err)
+	}
+}
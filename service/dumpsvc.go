@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -33,6 +34,11 @@ type GetAllHandler struct {
 
 type MeasurementsResponse struct {
 	Data interface{} `json:",omitempty"`
+	// Cursor continues the listing after the last measurement of Data, it
+	// is empty at the end.
+	Cursor string `json:",omitempty"`
+	// Next is the link to the next page, with the cursor.
+	Next string `json:",omitempty"`
 }
 
 type errPagination struct {
@@ -45,6 +51,7 @@ func (s *errPagination) Error() string {
 
 type pagination struct {
 	page, size int
+	cursor     string
 	err        *errPagination
 }
 
@@ -87,6 +94,13 @@ func (p *pagination) getParams(r *http.Request) {
 	if p.page > 0 && p.size == 0 {
 		fmt.Fprint(serr, "\tnon zero page parameter requires non zero limit\n")
 	}
+	p.cursor = r.FormValue("cursor")
+	if len(p.cursor) > 0 && p.size == 0 {
+		fmt.Fprint(serr, "\tcursor parameter requires non zero limit\n")
+	}
+	if len(p.cursor) > 0 && p.page > 0 {
+		fmt.Fprint(serr, "\tcursor parameter cannot be combined with page\n")
+	}
 	if serr.Len() > 0 {
 		p.err = serr
 	}
@@ -96,7 +110,53 @@ func (p *pagination) paginate() bool {
 	return p.err == nil && p.size > 0
 }
 
-func NewHttpLocalService(address string, repo model.MeasurementRepo) Service {
+// timeRange holds the meter, from and to parameters of a range query.
+type timeRange struct {
+	meter    string
+	from, to time.Time
+}
+
+// parseTime accepts RFC 3339 times and dates, a date is midnight UTC.
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+// getTimeRange returns the range parameters of the request, nil if there are none.
+func getTimeRange(r *http.Request) (*timeRange, error) {
+	meter, from, to := r.FormValue("meter"), r.FormValue("from"), r.FormValue("to")
+	if len(meter) == 0 && len(from) == 0 && len(to) == 0 {
+		return nil, nil
+	}
+	tr := &timeRange{meter: meter}
+	serr := &errPagination{}
+	var err error
+	if len(from) > 0 {
+		if tr.from, err = parseTime(from); err != nil {
+			fmt.Fprintf(serr, "\tfrom parameter error: %s\n", err.Error())
+		}
+	}
+	if len(to) > 0 {
+		if tr.to, err = parseTime(to); err != nil {
+			fmt.Fprintf(serr, "\tto parameter error: %s\n", err.Error())
+		}
+	}
+	if !tr.from.IsZero() && !tr.to.IsZero() && tr.to.Before(tr.from) {
+		fmt.Fprint(serr, "\tto parameter cannot be before from\n")
+	}
+	if len(r.FormValue("page")) > 0 || len(r.FormValue("cursor")) > 0 {
+		fmt.Fprint(serr, "\tpage and cursor parameters cannot be combined with a range, use size as the limit\n")
+	}
+	if serr.Len() > 0 {
+		return nil, serr
+	}
+	return tr, nil
+}
+
+// NewHttpLocalService creates the service for the repo, with optional extra routes.
+func NewHttpLocalService(address string, repo model.MeasurementRepo, routes ...Route) Service {
 	sm := &http.ServeMux{}
 	svc := &HTTPLocalService{
 		listenAddress: address,
@@ -111,6 +171,9 @@ func NewHttpLocalService(address string, repo model.MeasurementRepo) Service {
 	}
 	// Add handlers.
 	sm.Handle("/measurements/", gah)
+	for _, r := range routes {
+		sm.Handle(r.Pattern, r.Handler)
+	}
 	return svc
 }
 
@@ -118,6 +181,7 @@ type requestContext struct {
 	first, last bool
 	err         error
 	pag         *pagination
+	rng         *timeRange
 }
 
 const (
@@ -155,6 +219,7 @@ func getContext(r *http.Request) *requestContext {
 		return c
 	}
 	c.pag = pag
+	c.rng, c.err = getTimeRange(r)
 	return c
 }
 
@@ -184,19 +249,27 @@ func getPage(a *actors.PagerActor, pag *pagination) (*MeasurementsResponse, erro
 	}, nil
 }
 
-func getAll(a *actors.PagerActor) (*MeasurementsResponse, error) {
-	msm, err := a.GetAll()
+// getAfter returns the page after the cursor with the link to the next page.
+func getAfter(a *actors.PagerActor, pag *pagination, u *url.URL) (*MeasurementsResponse, error) {
+	msm, cursor, err := a.GetAfter(pag.cursor, pag.size)
 	if err != nil {
 		return nil, err
 	}
-
-	return &MeasurementsResponse{
-		Data: msm,
-	}, nil
-
+	mr := &MeasurementsResponse{
+		Data:   msm,
+		Cursor: cursor,
+	}
+	if len(cursor) > 0 {
+		q := url.Values{}
+		q.Set("cursor", cursor)
+		q.Set("size", strconv.Itoa(pag.size))
+		mr.Next = (&url.URL{Path: u.Path, RawQuery: q.Encode()}).String()
+	}
+	return mr, nil
 }
 
-// ServeHTTP reads all entries from the local repo and returns the JSON.
+// ServeHTTP reads the entries from the local repo and returns the JSON. All
+// entries and ranges are streamed.
 func (h *GetAllHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx := getContext(r)
 	if ctx.err != nil {
@@ -216,14 +289,33 @@ func (h *GetAllHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case ctx.last:
 		log.Print("GetAll: getLast")
 		mr, err = get(a, model.Last)
-	case ctx.pag != nil && ctx.pag.paginate():
+	case ctx.rng != nil:
+		log.Print("GetAll: getRange")
+		streamMeasurements(w, func(fn model.WalkFunc) error {
+			return a.Walk(ctx.rng.meter, ctx.rng.from, ctx.rng.to, limitWalk(ctx.pag.size, fn))
+		})
+		return
+	case ctx.pag != nil && ctx.pag.paginate() && ctx.pag.page == 0 && a.Cursors():
+		log.Print("GetAll: getAfter")
+		mr, err = getAfter(a, ctx.pag, r.URL)
+	case ctx.pag != nil && ctx.pag.paginate() && len(ctx.pag.cursor) == 0:
 		log.Print("GetAll: getPage")
 		mr, err = getPage(a, ctx.pag)
+	case ctx.pag != nil && ctx.pag.paginate():
+		http.Error(w, "bad request: the repo has no cursors, use page", http.StatusBadRequest)
+		return
 	default:
 		log.Print("GetAll: getAll")
-		mr, err = getAll(a)
+		streamMeasurements(w, func(fn model.WalkFunc) error {
+			return a.Walk("", time.Time{}, time.Time{}, fn)
+		})
+		return
 	}
 	// Get the data.
+	if errors.Is(err, model.ErrBadCursor) {
+		http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("internal error: %s", err.Error()), http.StatusInternalServerError)
 		return
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/peterzandbergen/iec62056/model"
 )
@@ -187,3 +188,35 @@ func TestMeasurementResponseMarshal(t *testing.T) {
 		// t.Error()
 	}
 }
+
+func TestContextRange(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://localhost/measurements?meter=ISK/m1&from=2020-01-02&to=2020-01-03T00:00:00Z&size=10", nil)
+	c := getContext(r)
+	if c.err != nil {
+		t.Fatalf("unexpected error: %s", c.err.Error())
+	}
+	if c.rng == nil {
+		t.Fatal("c.rng is nil")
+	}
+	if c.rng.meter != "ISK/m1" {
+		t.Errorf("expected %v, received %v", "ISK/m1", c.rng.meter)
+	}
+	if !c.rng.from.Equal(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected %v, received %v", "2020-01-02", c.rng.from)
+	}
+	if !c.rng.to.Equal(time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected %v, received %v", "2020-01-03", c.rng.to)
+	}
+	if c.pag.size != 10 {
+		t.Errorf("expected %v, received %v", 10, c.pag.size)
+	}
+}
+
+func TestContextBadRange(t *testing.T) {
+	for _, q := range []string{"from=yesterday", "from=2020-01-03&to=2020-01-02", "meter=ISK/m1&page=1&size=10"} {
+		r := httptest.NewRequest("GET", "http://localhost/measurements?"+q, nil)
+		if c := getContext(r); c.err == nil {
+			t.Errorf("%s: expected an error", q)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/peterzandbergen/iec62056/adapters/cloudrepo"
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+// DefaultMaxIngestBody is the maximum size of a posted body.
+const DefaultMaxIngestBody = 16 << 20
+
+// IngestHandler stores the measurements posted by the uploaders of remote
+// loggers, a single cloudrepo.Measurement or an array of them. The repo
+// stores a measurement under the time and the meter, so a measurement that
+// is posted again overwrites itself and retried uploads are harmless. Wrap
+// the handler with a cloudrepo.Verifier for authentication.
+type IngestHandler struct {
+	Repo model.MeasurementRepo
+	// MaxBody is the maximum size of a request, DefaultMaxIngestBody if 0.
+	MaxBody int64
+}
+
+// IngestResponse is the response to a successful POST.
+type IngestResponse struct {
+	Stored int
+}
+
+// ServeHTTP stores the measurements of the request.
+func (h *IngestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	max := h.MaxBody
+	if max <= 0 {
+		max = DefaultMaxIngestBody
+	}
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, max))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("bad request: %s", err.Error()), http.StatusRequestEntityTooLarge)
+		return
+	}
+	mm, err := cloudrepo.DecodeMeasurements(b)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("bad request: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	for _, m := range mm {
+		if err := h.Repo.Put(m.Model()); err != nil {
+			log.Printf("ingest: %s", err.Error())
+			http.Error(w, fmt.Sprintf("internal error: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+	}
+	log.Printf("ingest: stored %d measurements", len(mm))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(&IngestResponse{Stored: len(mm)})
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/adapters/cache"
+	"github.com/peterzandbergen/iec62056/adapters/cloudrepo"
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+func TestIngest(t *testing.T) {
+	repo, err := cache.Open(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	defer repo.Close()
+	creds := &cloudrepo.Credentials{Token: "token", HMACKey: []byte("key")}
+	ingest := cloudrepo.NewVerifier(creds).Handler(&IngestHandler{Repo: repo})
+	svc := NewHttpLocalService(":0", repo,
+		Route{Pattern: "POST /measurements", Handler: ingest},
+		Route{Pattern: "POST /measurements/", Handler: ingest},
+	).(*HTTPLocalService)
+	ts := httptest.NewServer(svc.server.Handler)
+	defer ts.Close()
+
+	remote, err := cloudrepo.New(ts.URL + "/measurements")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	remote.Credentials = creds
+	remote.MaxRetries = -1
+	t0 := time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)
+	var mm []*model.Measurement
+	for i := 0; i < 3; i++ {
+		mm = append(mm, &model.Measurement{Time: t0.Add(time.Duration(i) * time.Minute), ManufacturerID: "ISK", Identification: "meter1"})
+	}
+	// A retried upload stores nothing new.
+	for i := 0; i < 2; i++ {
+		if err := remote.PutBatch(context.Background(), mm); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+	if err := remote.Put(mm[0]); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	all, err := repo.GetAll()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(all) != len(mm) {
+		t.Errorf("expected %v, received %v", len(mm), len(all))
+	}
+
+	// Without credentials.
+	b, _ := json.Marshal(cloudrepo.NewMeasurement(mm[0]))
+	resp, err := http.Post(ts.URL+"/measurements", "application/json", bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected %v, received %v", http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	// Reading still works.
+	resp, err = http.Get(ts.URL + "/measurements/last")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected %v, received %v", http.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestIngestBadRequest(t *testing.T) {
+	h := &IngestHandler{Repo: nil}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/measurements", bytes.NewBufferString(`[{"time":"2020-01-02T15:04:05Z"}]`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected %v, received %v", http.StatusBadRequest, w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/measurements", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected %v, received %v", http.StatusMethodNotAllowed, w.Code)
+	}
+}
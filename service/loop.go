@@ -0,0 +1,56 @@
+package service
+
+import (
+	"context"
+	"sync"
+)
+
+// loop runs the background goroutine of a service, see Uploader, Reencoder
+// and Compactor.
+type loop struct {
+	lock   sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// start runs fn in a goroutine. The context of fn ends with ctx or when
+// stop is called.
+func (l *loop) start(ctx context.Context, fn func(ctx context.Context)) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.done != nil {
+		return ErrAlreadyRunning
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var rctx context.Context
+	rctx, l.cancel = context.WithCancel(ctx)
+	done := make(chan struct{})
+	l.done = done
+	go func() {
+		defer close(done)
+		fn(rctx)
+	}()
+	return nil
+}
+
+// stop cancels the context of fn and waits until fn returned, or until ctx
+// expires.
+func (l *loop) stop(ctx context.Context) error {
+	l.lock.Lock()
+	if l.done == nil {
+		l.lock.Unlock()
+		return ErrNotRunning
+	}
+	cancel, done := l.cancel, l.done
+	l.done = nil
+	l.lock.Unlock()
+	cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLoop(t *testing.T) {
+	var l loop
+	ended := make(chan struct{})
+	run := func(ctx context.Context) {
+		<-ctx.Done()
+		close(ended)
+	}
+	if err := l.stop(context.Background()); err != ErrNotRunning {
+		t.Errorf("expected %v, received %v", ErrNotRunning, err)
+	}
+	// The loop ends with the context of start.
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := l.start(ctx, run); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := l.start(ctx, run); err != ErrAlreadyRunning {
+		t.Errorf("expected %v, received %v", ErrAlreadyRunning, err)
+	}
+	cancel()
+	select {
+	case <-ended:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the loop to end")
+	}
+	if err := l.stop(context.Background()); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if err := l.start(ctx, run); err != context.Canceled {
+		t.Errorf("expected %v, received %v", context.Canceled, err)
+	}
+
+	// Stop waits for the loop.
+	ended = make(chan struct{})
+	if err := l.start(context.Background(), run); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if err := l.stop(context.Background()); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	select {
+	case <-ended:
+	default:
+		t.Errorf("expected the loop to have ended")
+	}
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+// Route adds a handler to the HTTP service.
+type Route struct {
+	Pattern string
+	Handler http.Handler
+}
+
+// MeterReadHandler reads the meter on request, stores the measurement in
+// the repo and returns it. Only POST is accepted.
+type MeterReadHandler struct {
+	Read func(ctx context.Context) (*model.Measurement, error)
+	Repo model.MeasurementRepo
+	// Timeout for waiting for and reading the meter, default is 2 minutes.
+	Timeout time.Duration
+}
+
+// ServeHTTP performs the on-demand read.
+func (h *MeterReadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	m, err := h.Read(ctx)
+	if err != nil {
+		log.Printf("on-demand read failed: %s", err.Error())
+		http.Error(w, fmt.Sprintf("read error: %s", err.Error()), http.StatusServiceUnavailable)
+		return
+	}
+	if err := h.Repo.Put(m); err != nil {
+		http.Error(w, fmt.Sprintf("internal error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, &MeasurementsResponse{Data: m})
+}
+
+// StatusHandler returns the value of Status as JSON.
+type StatusHandler struct {
+	Status func() interface{}
+}
+
+// ServeHTTP writes the status.
+func (h *StatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, h.Status())
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("internal error: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(j)
+}
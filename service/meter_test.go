@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+// putRepo records the measurements that are put.
+type putRepo struct {
+	model.MeasurementRepo
+	put []*model.Measurement
+}
+
+func (r *putRepo) Put(m *model.Measurement) error {
+	r.put = append(r.put, m)
+	return nil
+}
+
+func TestMeterReadHandler(t *testing.T) {
+	repo := &putRepo{}
+	h := &MeterReadHandler{
+		Read: func(ctx context.Context) (*model.Measurement, error) {
+			return &model.Measurement{Identification: "meter"}, nil
+		},
+		Repo: repo,
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "http://localhost/meter/read", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, received %d", http.StatusOK, w.Code)
+	}
+	if len(repo.put) != 1 {
+		t.Errorf("expected 1 stored measurement, received %d", len(repo.put))
+	}
+	var resp struct{ Data model.Measurement }
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("bad response: %s", err.Error())
+	}
+	if resp.Data.Identification != "meter" {
+		t.Errorf("expected %s, received %s", "meter", resp.Data.Identification)
+	}
+}
+
+func TestMeterReadHandlerErrors(t *testing.T) {
+	h := &MeterReadHandler{
+		Read: func(ctx context.Context) (*model.Measurement, error) {
+			return nil, errors.New("port busy")
+		},
+		Repo: &putRepo{},
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/meter/read", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d, received %d", http.StatusMethodNotAllowed, w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "http://localhost/meter/read", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, received %d", http.StatusServiceUnavailable, w.Code)
+	}
+}
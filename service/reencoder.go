@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Reencodable converts stored records step by step, e.g. a cache.Cache.
+type Reencodable interface {
+	// Reencode converts up to n records after the cursor and returns the
+	// cursor of the next step, empty when done.
+	Reencode(cursor string, n int) (string, int, error)
+}
+
+// Defaults for the re-encoder.
+const (
+	DefaultReencodeBatchSize = 1000
+	DefaultReencodePause     = 100 * time.Millisecond
+)
+
+// Reencoder converts the records of the repo to its current encoding in the
+// background, one batch at a time with a pause in between, so the other
+// users of the repo are not blocked. It ends after one pass.
+type Reencoder struct {
+	Repo Reencodable
+	// BatchSize is the number of records per step.
+	BatchSize int
+	// Pause between the steps.
+	Pause time.Duration
+
+	loop loop
+}
+
+// Start starts the conversion, until Stop is called or ctx ends.
+func (r *Reencoder) Start(ctx context.Context) error {
+	return r.loop.start(ctx, r.run)
+}
+
+// Stop stops the conversion after the running step, or when ctx expires.
+func (r *Reencoder) Stop(ctx context.Context) error {
+	return r.loop.stop(ctx)
+}
+
+func (r *Reencoder) run(ctx context.Context) {
+	size := r.BatchSize
+	if size <= 0 {
+		size = DefaultReencodeBatchSize
+	}
+	pause := r.Pause
+	if pause <= 0 {
+		pause = DefaultReencodePause
+	}
+	cursor, total := "", 0
+	for {
+		next, n, err := r.Repo.Reencode(cursor, size)
+		if err != nil {
+			log.Printf("reencoder: %s", err.Error())
+			return
+		}
+		total += n
+		if len(next) == 0 {
+			if total > 0 {
+				log.Printf("reencoder: converted %d records", total)
+			}
+			return
+		}
+		cursor = next
+		t := time.NewTimer(pause)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
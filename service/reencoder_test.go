@@ -0,0 +1,45 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stepRepo has steps batches to convert.
+type stepRepo struct {
+	lock  sync.Mutex
+	steps int
+	calls int
+}
+
+func (r *stepRepo) Reencode(cursor string, n int) (string, int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.calls++
+	if r.calls >= r.steps {
+		return "", n, nil
+	}
+	return "next", n, nil
+}
+
+func TestReencoder(t *testing.T) {
+	repo := &stepRepo{steps: 3}
+	r := &Reencoder{Repo: repo, Pause: time.Millisecond}
+	if err := r.Start(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	done := r.loop.done
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reencoder did not finish")
+	}
+	if err := r.Stop(context.Background()); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if repo.calls != 3 {
+		t.Errorf("expected %v, received %v", 3, repo.calls)
+	}
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+// SeriesHandler serves GET /series?address=&meter=&from=&to=&size= with
+// the time series of a register, e.g. address=1-0:1.7.0 for the power,
+// from a repo with series indexes such as a cache.Cache. The meter, the
+// range and size, the maximum number of points, are optional. The response
+// has a series per meter and register:
+//
+//	{"Data":[{"MeterID":"ISK/1","Address":"1.7.0","Unit":"kW","Points":[{"Time":"...","Value":"0.271"}]}]}
+type SeriesHandler struct {
+	Repo model.SeriesWalker
+}
+
+// seriesPoint is a point of a series in the response.
+type seriesPoint struct {
+	Time  time.Time
+	Value string
+}
+
+// ServeHTTP streams the series, see streamMeasurements for the errors.
+func (h *SeriesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	address, tr, size, err := getSeriesParams(r)
+	if err != nil {
+		http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	started := false
+	var cur *model.Sample
+	enc := json.NewEncoder(w)
+	n := 0
+	err = h.Repo.WalkSeries(address, tr.meter, tr.from, tr.to, func(s *model.Sample) error {
+		if !started {
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"Data":[`)
+			started = true
+		}
+		// A new series for every meter, register and unit.
+		if cur == nil || s.MeterID != cur.MeterID || s.Address != cur.Address || s.Unit != cur.Unit {
+			if cur != nil {
+				io.WriteString(w, "]},")
+			}
+			if _, err := fmt.Fprintf(w, `{"MeterID":%s,"Address":%s,"Unit":%s,"Points":[`, quote(s.MeterID), quote(s.Address), quote(s.Unit)); err != nil {
+				return err
+			}
+			cur = s
+		} else if _, err := io.WriteString(w, ","); err != nil {
+			return err
+		}
+		n++
+		if err := enc.Encode(&seriesPoint{Time: s.Time, Value: s.Value}); err != nil {
+			return err
+		}
+		if size > 0 && n >= size {
+			return model.ErrStopWalk
+		}
+		return nil
+	})
+	if err != nil {
+		if !started {
+			http.Error(w, fmt.Sprintf("internal error: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("streaming series failed after %d points: %s", n, err.Error())
+		return
+	}
+	if !started {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"Data":[`)
+	}
+	if cur != nil {
+		io.WriteString(w, "]}")
+	}
+	io.WriteString(w, "]}\n")
+	log.Printf("streamed %d points of %s", n, address)
+}
+
+// quote returns s as a JSON string.
+func quote(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// getSeriesParams returns the address, the range and the size of the request.
+func getSeriesParams(r *http.Request) (string, *timeRange, int, error) {
+	address := r.FormValue("address")
+	if len(address) == 0 {
+		return "", nil, 0, ErrBadParameter
+	}
+	size := 0
+	if s := r.FormValue("size"); len(s) > 0 {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 0 {
+			return "", nil, 0, ErrBadParameter
+		}
+		size = v
+	}
+	tr, err := getTimeRange(r)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	if tr == nil {
+		tr = &timeRange{}
+	}
+	return address, tr, size, nil
+}
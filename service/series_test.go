@@ -0,0 +1,88 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/adapters/cache"
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+func TestSeriesHandler(t *testing.T) {
+	repo, err := cache.Open(filepath.Join(t.TempDir(), "cache"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	defer repo.Close()
+	t0 := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	for _, meter := range []string{"m1", "m2"} {
+		for i := 0; i < 10; i++ {
+			m := &model.Measurement{
+				Time:           t0.Add(time.Duration(i) * time.Hour),
+				ManufacturerID: "ISK",
+				Identification: meter,
+				Readings: []model.DataSet{
+					{Address: "1.7.0", Value: strconv.Itoa(i), Unit: "kW"},
+					{Address: "1.8.1", Value: "000051.394", Unit: "kWh"},
+				},
+			}
+			if err := repo.Put(m); err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+		}
+	}
+	h := &SeriesHandler{Repo: repo}
+
+	tests := []struct {
+		url    string
+		series int
+		points int
+	}{
+		{"/series?address=1-0:1.7.0", 2, 20},
+		{"/series?address=1.7.0&meter=ISK/m2&from=2020-01-02T02:00:00Z&to=2020-01-02T05:00:00Z", 1, 3},
+		{"/series?address=1.7.0&size=12", 2, 12},
+		{"/series?address=2.7.0", 0, 0},
+	}
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.url, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected %v, received %v", tc.url, http.StatusOK, w.Code)
+		}
+		var res struct {
+			Data []struct {
+				MeterID, Address, Unit string
+				Points                 []seriesPoint
+			}
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("%s: unexpected error: %s", tc.url, err.Error())
+		}
+		if len(res.Data) != tc.series {
+			t.Errorf("%s: expected %v, received %v", tc.url, tc.series, len(res.Data))
+		}
+		points := 0
+		for _, s := range res.Data {
+			if s.Address != "1.7.0" || s.Unit != "kW" {
+				t.Errorf("%s: expected %v, received %v", tc.url, "1.7.0 in kW", s)
+			}
+			points += len(s.Points)
+		}
+		if points != tc.points {
+			t.Errorf("%s: expected %v, received %v", tc.url, tc.points, points)
+		}
+	}
+
+	for _, url := range []string{"/series", "/series?address=1.7.0&size=x", "/series?address=1.7.0&from=2020-01-03&to=2020-01-02"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected %v, received %v", url, http.StatusBadRequest, w.Code)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Snapshotter writes a consistent archive of its data, e.g. a cache.Cache.
+type Snapshotter interface {
+	Snapshot(w io.Writer) (int, error)
+}
+
+// SnapshotHandler sends a snapshot of the repo as an attachment, so the
+// database can be copied while it is in use. An error before the first
+// byte is a 500, a later one ends the response early; the archive is then
+// incomplete and cache.Restore rejects it. Wrap the handler with a
+// cloudrepo.Verifier for authentication.
+type SnapshotHandler struct {
+	Repo Snapshotter
+}
+
+// ServeHTTP writes the snapshot.
+func (h *SnapshotHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	lw := &lazyWriter{w: w, filename: fmt.Sprintf("emlog-%s.snap", time.Now().UTC().Format("20060102T150405Z"))}
+	n, err := h.Repo.Snapshot(lw)
+	if err != nil {
+		log.Printf("snapshot: %s", err.Error())
+		if !lw.started {
+			http.Error(w, fmt.Sprintf("internal error: %s", err.Error()), http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Printf("snapshot: sent %d records", n)
+}
+
+// lazyWriter sends the headers with the first write.
+type lazyWriter struct {
+	w        http.ResponseWriter
+	filename string
+	started  bool
+}
+
+func (l *lazyWriter) Write(b []byte) (int, error) {
+	if !l.started {
+		l.started = true
+		l.w.Header().Set("Content-Type", "application/octet-stream")
+		l.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", l.filename))
+		l.w.WriteHeader(http.StatusOK)
+	}
+	return l.w.Write(b)
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/adapters/cache"
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+func TestSnapshotHandler(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := cache.Open(filepath.Join(dir, "db"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	defer repo.Close()
+	m := &model.Measurement{Time: time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC), ManufacturerID: "ISK", Identification: "meter1"}
+	if err := repo.Put(m); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	w := httptest.NewRecorder()
+	(&SnapshotHandler{Repo: repo}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/snapshot", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %v, received %v", http.StatusOK, w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/octet-stream" {
+		t.Errorf("expected %v, received %v", "application/octet-stream", ct)
+	}
+	restored := filepath.Join(dir, "restored")
+	if _, err := cache.Restore(restored, w.Body); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	r, err := cache.Open(restored)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	defer r.Close()
+	if all, _ := r.GetAll(); len(all) != 1 || !all[0].Time.Equal(m.Time) {
+		t.Errorf("expected %v, received %v", m, all)
+	}
+}
+
+// failSnapshot fails after writing n bytes.
+type failSnapshot struct {
+	n int
+}
+
+func (f failSnapshot) Snapshot(w io.Writer) (int, error) {
+	if f.n > 0 {
+		w.Write(bytes.Repeat([]byte{0}, f.n))
+	}
+	return 0, errors.New("failed")
+}
+
+func TestSnapshotHandlerError(t *testing.T) {
+	w := httptest.NewRecorder()
+	(&SnapshotHandler{Repo: failSnapshot{}}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/snapshot", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected %v, received %v", http.StatusInternalServerError, w.Code)
+	}
+	w = httptest.NewRecorder()
+	(&SnapshotHandler{Repo: failSnapshot{n: 10}}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/snapshot", nil))
+	if w.Code != http.StatusOK || w.Body.Len() != 10 {
+		t.Errorf("expected %v, received %v", "a truncated body", w.Code)
+	}
+	w = httptest.NewRecorder()
+	(&SnapshotHandler{Repo: failSnapshot{}}).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/snapshot", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected %v, received %v", http.StatusMethodNotAllowed, w.Code)
+	}
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+// streamMeasurements writes the measurements of the walk as a
+// MeasurementsResponse, one measurement at a time. An error before the first
+// measurement is sent as a 500 status. Later errors can only end the
+// response early, the client receives incomplete JSON.
+func streamMeasurements(w http.ResponseWriter, walk func(fn model.WalkFunc) error) {
+	started := false
+	start := func() {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"Data":[`)
+		started = true
+	}
+	enc := json.NewEncoder(w)
+	n := 0
+	err := walk(func(m *model.Measurement) error {
+		if !started {
+			start()
+		} else if _, err := io.WriteString(w, ","); err != nil {
+			return err
+		}
+		n++
+		return enc.Encode(m)
+	})
+	if err != nil {
+		if !started {
+			http.Error(w, fmt.Sprintf("internal error: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("streaming measurements failed after %d: %s", n, err.Error())
+		return
+	}
+	if !started {
+		start()
+	}
+	io.WriteString(w, "]}\n")
+	log.Printf("streamed %d measurements", n)
+}
+
+// limitWalk stops the walk after limit measurements, 0 or less is no limit.
+func limitWalk(limit int, fn model.WalkFunc) model.WalkFunc {
+	if limit <= 0 {
+		return fn
+	}
+	n := 0
+	return func(m *model.Measurement) error {
+		n++
+		if err := fn(m); err != nil {
+			return err
+		}
+		if n >= limit {
+			return model.ErrStopWalk
+		}
+		return nil
+	}
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/adapters/cache"
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+// walkRepo walks the measurements and then returns err.
+type walkRepo struct {
+	model.MeasurementRepo
+	mm  []*model.Measurement
+	err error
+}
+
+func (r *walkRepo) Walk(meterID string, from, to time.Time, fn model.WalkFunc) error {
+	for _, m := range r.mm {
+		if err := fn(m); err != nil {
+			if err == model.ErrStopWalk {
+				return nil
+			}
+			return err
+		}
+	}
+	return r.err
+}
+
+func serve(repo model.MeasurementRepo, url string) *httptest.ResponseRecorder {
+	h := NewHttpLocalService(":0", repo).(*HTTPLocalService).server.Handler
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+	return w
+}
+
+func TestStreamMeasurements(t *testing.T) {
+	t0 := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	repo := &walkRepo{}
+	for i := 0; i < 5; i++ {
+		repo.mm = append(repo.mm, &model.Measurement{Time: t0.Add(time.Duration(i) * time.Minute), Identification: "m1"})
+	}
+	tests := []struct {
+		url string
+		n   int
+	}{
+		{"/measurements/", 5},
+		{"/measurements/?meter=/m1&size=2", 2},
+		{"/measurements/?from=2020-01-02", 5},
+	}
+	for _, tc := range tests {
+		w := serve(repo, tc.url)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected %v, received %v", tc.url, http.StatusOK, w.Code)
+		}
+		var res struct{ Data []*model.Measurement }
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("%s: unexpected error: %s", tc.url, err.Error())
+		}
+		if len(res.Data) != tc.n {
+			t.Errorf("%s: expected %v, received %v", tc.url, tc.n, len(res.Data))
+		}
+	}
+
+	// Empty.
+	w := serve(&walkRepo{}, "/measurements/")
+	if w.Body.String() != "{\"Data\":[]}\n" {
+		t.Errorf("expected %v, received %v", "{\"Data\":[]}", w.Body.String())
+	}
+}
+
+func TestStreamErrors(t *testing.T) {
+	// Before the first measurement.
+	w := serve(&walkRepo{err: errors.New("corrupt")}, "/measurements/")
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected %v, received %v", http.StatusInternalServerError, w.Code)
+	}
+	// After the first measurement the JSON is incomplete.
+	w = serve(&walkRepo{mm: []*model.Measurement{{}}, err: errors.New("corrupt")}, "/measurements/")
+	var res MeasurementsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err == nil {
+		t.Errorf("expected a JSON error for %s", w.Body.String())
+	}
+}
+
+func TestCursorPages(t *testing.T) {
+	repo, err := cache.Open(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	defer repo.Close()
+	t0 := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		repo.Put(&model.Measurement{Time: t0.Add(time.Duration(i) * time.Minute), Identification: "m1"})
+	}
+	next := "/measurements/?size=2"
+	n, pages := 0, 0
+	for len(next) > 0 {
+		w := serve(repo, next)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected %v, received %v", next, http.StatusOK, w.Code)
+		}
+		var res struct {
+			Data []*model.Measurement
+			Next string
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		n += len(res.Data)
+		pages++
+		next = res.Next
+	}
+	if n != 5 || pages != 3 {
+		t.Errorf("expected %v in %v pages, received %v in %v", 5, 3, n, pages)
+	}
+	if w := serve(repo, "/measurements/?size=2&cursor=bad"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected %v, received %v", http.StatusBadRequest, w.Code)
+	}
+}
+
+// TestCursorWithoutCursors rejects a cursor for a repo without cursors.
+func TestCursorWithoutCursors(t *testing.T) {
+	w := serve(&walkRepo{}, "/measurements/?cursor=x&size=2")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected %v, received %v", http.StatusBadRequest, w.Code)
+	}
+}
@@ -0,0 +1,235 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/adapters/cloudrepo"
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+// Outbox holds the measurements waiting for upload, e.g. a cache opened
+// with the outbox enabled.
+type Outbox interface {
+	// Pending returns up to n measurements with their keys, in the order of
+	// the outbox, e.g. per meter oldest first for a cache or in the order
+	// they were stored for the segments.
+	Pending(n int) ([][]byte, []*model.Measurement, error)
+	// Ack removes the measurements with the keys from the outbox.
+	Ack(keys [][]byte) error
+	// Backlog returns the number of waiting measurements.
+	Backlog() (int, error)
+}
+
+// Sink receives the uploaded measurements, e.g. a cloudrepo.CloudRepo.
+// PutBatch returns nil only after the sink has stored all measurements.
+type Sink interface {
+	PutBatch(ctx context.Context, mm []*model.Measurement) error
+}
+
+// Defaults for the uploader.
+const (
+	DefaultUploadInterval  = time.Minute
+	DefaultUploadBatchSize = 100
+)
+
+// Uploader drains the outbox to the sink in the order of Pending. A
+// measurement is removed from the outbox only after the sink acknowledged
+// it, so a restart does not lose measurements. A crash between the
+// acknowledgement and the removal sends the batch again, the sink must
+// ignore measurements it already has.
+//
+// A batch that the sink rejects, see rejected, is split until the rejected
+// measurements are found. Those are logged and removed from the outbox, they
+// stay in the local store, so one bad measurement does not block the others.
+type Uploader struct {
+	Outbox Outbox
+	Sink   Sink
+	// Interval between checks for new measurements and between attempts
+	// after a failed upload.
+	Interval time.Duration
+	// BatchSize is the number of measurements per upload.
+	BatchSize int
+
+	loop     loop
+	lock     sync.Mutex
+	uploaded int
+	lastOK   time.Time
+	lastErr  error
+	rejected int
+	// lastRejected is the error of the last rejected measurement.
+	lastRejected error
+	wake         chan struct{}
+}
+
+// UploaderStatus reports the progress of the uploader.
+type UploaderStatus struct {
+	// Backlog is the number of measurements waiting for upload.
+	Backlog int
+	// Uploaded since the start.
+	Uploaded   int
+	LastUpload time.Time `json:",omitempty"`
+	LastError  string    `json:",omitempty"`
+	// Rejected is the number of measurements the sink rejected since the
+	// start, they are not uploaded.
+	Rejected     int
+	LastRejected string `json:",omitempty"`
+}
+
+// Start starts draining the outbox, until Stop is called or ctx ends.
+func (u *Uploader) Start(ctx context.Context) error {
+	u.lock.Lock()
+	if u.wake == nil {
+		u.wake = make(chan struct{}, 1)
+	}
+	u.lock.Unlock()
+	return u.loop.start(ctx, u.run)
+}
+
+// Stop stops the uploader after the running upload, or when ctx expires.
+func (u *Uploader) Stop(ctx context.Context) error {
+	return u.loop.stop(ctx)
+}
+
+// Notify starts an upload without waiting for the interval, e.g. after a
+// new measurement was stored.
+func (u *Uploader) Notify() {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if u.wake == nil {
+		return
+	}
+	select {
+	case u.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Status returns the backlog and the result of the last upload.
+func (u *Uploader) Status() UploaderStatus {
+	n, err := u.Outbox.Backlog()
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	s := UploaderStatus{
+		Backlog:    n,
+		Uploaded:   u.uploaded,
+		LastUpload: u.lastOK,
+	}
+	if u.lastErr != nil {
+		s.LastError = u.lastErr.Error()
+	} else if err != nil {
+		s.LastError = err.Error()
+	}
+	s.Rejected = u.rejected
+	if u.lastRejected != nil {
+		s.LastRejected = u.lastRejected.Error()
+	}
+	return s
+}
+
+func (u *Uploader) run(ctx context.Context) {
+	interval := u.Interval
+	if interval <= 0 {
+		interval = DefaultUploadInterval
+	}
+	for {
+		// Drain while uploads succeed.
+		for {
+			n, err := u.upload(ctx)
+			if err != nil || n == 0 {
+				break
+			}
+		}
+		t := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-u.wake:
+		case <-t.C:
+		}
+		t.Stop()
+	}
+}
+
+// upload sends one batch and returns the number of measurements removed
+// from the outbox, uploaded or rejected.
+func (u *Uploader) upload(ctx context.Context) (int, error) {
+	size := u.BatchSize
+	if size <= 0 {
+		size = DefaultUploadBatchSize
+	}
+	keys, mm, err := u.Outbox.Pending(size)
+	n := 0
+	if err == nil && len(mm) > 0 {
+		n, err = u.send(ctx, keys, mm)
+	}
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("uploader: %s", err.Error())
+		}
+		u.lastErr = err
+		return n, err
+	}
+	u.lastErr = nil
+	return n, nil
+}
+
+// send uploads the measurements and removes them from the outbox. A
+// rejected batch is split in halves, which are sent in order, so the
+// outbox is acknowledged in the order of Pending. It returns the number of
+// measurements removed from the outbox.
+func (u *Uploader) send(ctx context.Context, keys [][]byte, mm []*model.Measurement) (int, error) {
+	err := u.Sink.PutBatch(ctx, mm)
+	if err == nil {
+		if err := u.Outbox.Ack(keys); err != nil {
+			return 0, err
+		}
+		u.lock.Lock()
+		u.uploaded += len(mm)
+		u.lastOK = time.Now()
+		u.lock.Unlock()
+		return len(mm), nil
+	}
+	if !rejected(err) {
+		return 0, err
+	}
+	if len(mm) == 1 {
+		log.Printf("uploader: %s at %s rejected: %s", mm[0].MeterID(), mm[0].Time.Format(time.RFC3339), err.Error())
+		if err := u.Outbox.Ack(keys); err != nil {
+			return 0, err
+		}
+		u.lock.Lock()
+		u.rejected++
+		u.lastRejected = err
+		u.lock.Unlock()
+		return 1, nil
+	}
+	h := len(mm) / 2
+	n, err := u.send(ctx, keys[:h], mm[:h])
+	if err != nil {
+		return n, err
+	}
+	m, err := u.send(ctx, keys[h:], mm[h:])
+	return n + m, err
+}
+
+// rejected returns true if the sink refused the measurements themselves.
+// Other errors, e.g. a bad token or an unavailable sink, are retried.
+func rejected(err error) bool {
+	var se *cloudrepo.StatusError
+	if !errors.As(err, &se) {
+		return false
+	}
+	switch se.StatusCode {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/peterzandbergen/iec62056/adapters/cache"
+	"github.com/peterzandbergen/iec62056/adapters/cloudrepo"
+	"github.com/peterzandbergen/iec62056/model"
+)
+
+// testSink fails the first fail uploads and rejects a batch with a
+// measurement without identification, like the cloud service.
+type testSink struct {
+	lock     sync.Mutex
+	fail     int
+	received []*model.Measurement
+}
+
+func (s *testSink) PutBatch(ctx context.Context, mm []*model.Measurement) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.fail > 0 {
+		s.fail--
+		return errors.New("sink unavailable")
+	}
+	for _, m := range mm {
+		if len(m.Identification) == 0 {
+			return &cloudrepo.StatusError{StatusCode: http.StatusBadRequest, Status: "400 Bad Request"}
+		}
+	}
+	s.received = append(s.received, mm...)
+	return nil
+}
+
+func (s *testSink) count() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.received)
+}
+
+func TestUploader(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	c, err := cache.OpenWithOptions(dir, &cache.Options{Outbox: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	t0 := time.Date(2020, 1, 2, 15, 0, 0, 0, time.UTC)
+	// Stored in reverse order, uploaded in time order.
+	for i := 4; i >= 0; i-- {
+		c.Put(&model.Measurement{Time: t0.Add(time.Duration(i) * time.Minute), ManufacturerID: "ISK", Identification: "m1"})
+	}
+	sink := &testSink{fail: 1}
+	u := &Uploader{Outbox: c, Sink: sink, Interval: 10 * time.Millisecond, BatchSize: 2}
+	if s := u.Status(); s.Backlog != 5 {
+		t.Errorf("expected %v, received %v", 5, s.Backlog)
+	}
+	if err := u.Start(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	for i := 0; i < 100 && sink.count() < 5; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := u.Stop(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if sink.count() != 5 {
+		t.Fatalf("expected %v, received %v", 5, sink.count())
+	}
+	for i, m := range sink.received {
+		if !m.Time.Equal(t0.Add(time.Duration(i) * time.Minute)) {
+			t.Errorf("expected %v, received %v", t0.Add(time.Duration(i)*time.Minute), m.Time)
+		}
+	}
+	if s := u.Status(); s.Backlog != 0 || s.Uploaded != 5 || s.LastError != "" {
+		t.Errorf("unexpected status: %+v", s)
+	}
+	// Nothing is pending after a restart, the measurements stay in the cache.
+	c.Close()
+	c, err = cache.OpenWithOptions(dir, &cache.Options{Outbox: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	defer c.Close()
+	if n, _ := c.Backlog(); n != 0 {
+		t.Errorf("expected %v, received %v", 0, n)
+	}
+	if mm, _ := c.GetAll(); len(mm) != 5 {
+		t.Errorf("expected %v, received %v", 5, len(mm))
+	}
+}
+
+// TestUploaderNotify uploads a new measurement before the interval expires.
+func TestUploaderNotify(t *testing.T) {
+	c, err := cache.OpenWithOptions(filepath.Join(t.TempDir(), "db"), &cache.Options{Outbox: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	defer c.Close()
+	sink := &testSink{}
+	u := &Uploader{Outbox: c, Sink: sink, Interval: time.Hour}
+	// Ignored while the uploader is not running.
+	u.Notify()
+	if err := u.Start(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	defer u.Stop(context.Background())
+	c.Put(&model.Measurement{Time: time.Date(2020, 1, 2, 15, 0, 0, 0, time.UTC), ManufacturerID: "ISK", Identification: "m1"})
+	u.Notify()
+	for i := 0; i < 100 && sink.count() < 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if sink.count() != 1 {
+		t.Errorf("expected %v, received %v", 1, sink.count())
+	}
+}
+
+// TestUploaderRejected skips the measurements the sink rejects.
+func TestUploaderRejected(t *testing.T) {
+	c, err := cache.OpenWithOptions(filepath.Join(t.TempDir(), "db"), &cache.Options{Outbox: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	defer c.Close()
+	t0 := time.Date(2020, 1, 2, 15, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		m := &model.Measurement{Time: t0.Add(time.Duration(i) * time.Minute), ManufacturerID: "ISK", Identification: "m1"}
+		if i == 2 {
+			m.Identification = ""
+		}
+		c.Put(m)
+	}
+	sink := &testSink{}
+	u := &Uploader{Outbox: c, Sink: sink, BatchSize: 10}
+	n, err := u.upload(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if n != 5 || sink.count() != 4 {
+		t.Errorf("expected %v, received %v", "5 removed and 4 uploaded", []int{n, sink.count()})
+	}
+	if s := u.Status(); s.Backlog != 0 || s.Uploaded != 4 || s.Rejected != 1 || s.LastRejected == "" {
+		t.Errorf("unexpected status: %+v", s)
+	}
+	// An unavailable sink is retried.
+	c.Put(&model.Measurement{Time: t0.Add(time.Hour), ManufacturerID: "ISK", Identification: "m1"})
+	sink.fail = 1
+	if _, err := u.upload(context.Background()); err == nil {
+		t.Errorf("expected an error")
+	}
+	if s := u.Status(); s.Backlog != 1 || s.Rejected != 1 {
+		t.Errorf("unexpected status: %+v", s)
+	}
+}